@@ -0,0 +1,141 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var export = &cobra.Command{
+	Use:   "export",
+	Short: "write stored check results to an archive of JSON lines, newest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := storer.New(ctx, os.Getenv("DATABASE_URL"), os.Getenv("DATABASE_CA_CERT"), false)
+		if err != nil {
+			log.Fatal().Err(err).Msg("creating storer")
+		}
+		defer s.Close()
+		var filter storer.ResultsFilter
+		if filter.Start, err = timeFlag(cmd, "start"); err != nil {
+			log.Fatal().Err(err).Msg("parsing start flag")
+		}
+		if filter.End, err = timeFlag(cmd, "end"); err != nil {
+			log.Fatal().Err(err).Msg("parsing end flag")
+		}
+		if filter.AppIDs, err = cmd.Flags().GetStringSlice("apps"); err != nil {
+			log.Fatal().Err(err).Msg("reading apps flag")
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			log.Fatal().Err(err).Msg("reading output flag")
+		}
+		compress, err := cmd.Flags().GetBool("gzip")
+		if err != nil {
+			log.Fatal().Err(err).Msg("reading gzip flag")
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" && output != "-" {
+			f, err := os.Create(output)
+			if err != nil {
+				log.Fatal().Err(err).Msg("creating output file")
+			}
+			defer func() {
+				if err := f.Close(); err != nil {
+					log.Fatal().Err(err).Msg("closing output file")
+				}
+			}()
+			w = f
+		}
+		if compress {
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			w = gz
+		}
+		archive := storer.NewArchiveWriter(w)
+		var exported int
+		page := storer.Page{Limit: storer.MaxPageLimit}
+		for {
+			results, err := s.QueryResults(ctx, filter, page)
+			if err != nil {
+				log.Fatal().Err(err).Msg("querying results")
+			}
+			for _, r := range results.Results {
+				if err := archive.Write(r); err != nil {
+					log.Fatal().Err(err).Msg("writing archive")
+				}
+			}
+			exported += len(results.Results)
+			if results.NextCursor == "" {
+				break
+			}
+			page.Cursor = results.NextCursor
+		}
+		log.Info().Int("results", exported).Msg("exported results")
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <archive>...",
+	Short: "save check results from archives, which may be gzipped; results already stored are skipped",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := storer.New(ctx, os.Getenv("DATABASE_URL"), os.Getenv("DATABASE_CA_CERT"), false)
+		if err != nil {
+			log.Fatal().Err(err).Msg("creating storer")
+		}
+		defer s.Close()
+		for _, path := range args {
+			var imported int
+			err := importArchive(path, func(r check.CheckResults) error {
+				if err := s.SaveCheckResults(ctx, r); err != nil {
+					return fmt.Errorf("saving result from %s: %w", r.TS.Format(time.RFC3339Nano), err)
+				}
+				imported++
+				return nil
+			})
+			if err != nil {
+				log.Fatal().Err(err).Str("archive", path).Msg("importing archive")
+			}
+			log.Info().Str("archive", path).Int("results", imported).Msg("imported archive")
+		}
+	},
+}
+
+func importArchive(path string, f func(check.CheckResults) error) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	return storer.ReadArchive(r, f)
+}
+
+// timeFlag parses an optional RFC 3339 timestamp flag.
+func timeFlag(cmd *cobra.Command, name string) (time.Time, error) {
+	v, err := cmd.Flags().GetString(name)
+	if err != nil || v == "" {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(time.RFC3339Nano, v, time.UTC)
+}
+
+func init() {
+	export.Flags().String("start", "", "start timestamp")
+	export.Flags().String("end", "", "end timestamp")
+	export.Flags().StringSlice("apps", []string{}, "list of app ids to export")
+	export.Flags().StringP("output", "o", "", "archive file to write, or stdout if empty")
+	export.Flags().Bool("gzip", false, "compress the archive")
+	maxInterval.AddCommand(export, importCmd)
+}
@@ -17,7 +17,7 @@ var ctx context.Context
 var maxInterval = &cobra.Command{
 	Use: "analyze",
 	Run: func(cmd *cobra.Command, args []string) {
-		storer, err := storer.NewMySQLStorer(ctx, os.Getenv("DATABASE_URL"), os.Getenv("DATABASE_CA_CERT"), false)
+		storer, err := storer.New(ctx, os.Getenv("DATABASE_URL"), os.Getenv("DATABASE_CA_CERT"), false)
 		if err != nil {
 			log.Fatal().Err(err).Msg("creating storer")
 		}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var ctx context.Context
+
+var migrate = &cobra.Command{
+	Use:   "migrate",
+	Short: "apply pending schema migrations to the database in DATABASE_URL",
+	Run: func(cmd *cobra.Command, args []string) {
+		migrator, closer := openMigrator()
+		defer closer()
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing dry-run flag")
+		}
+		applied, err := migrator.Up(ctx, dryRun)
+		for _, m := range applied {
+			if dryRun {
+				fmt.Printf("-- pending %04d_%s\n%s\n", m.Version, m.Name, m.SQL)
+			} else {
+				fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+			}
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("migrating")
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	},
+}
+
+var migrateStatus = &cobra.Command{
+	Use:   "status",
+	Short: "list schema migrations and whether they have been applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		migrator, closer := openMigrator()
+		defer closer()
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("querying migration status")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		w.Flush()
+	},
+}
+
+// openMigrator connects to the storer without creating tables.
+func openMigrator() (*storer.Migrator, func()) {
+	s, err := storer.New(ctx, os.Getenv("DATABASE_URL"), os.Getenv("DATABASE_CA_CERT"), false)
+	if err != nil {
+		log.Fatal().Err(err).Msg("creating storer")
+	}
+	m, ok := storer.Primary(s).(storer.Migratable)
+	if !ok {
+		s.Close()
+		log.Fatal().Msg("storer does not support migrations")
+	}
+	return m.Migrator(), func() { s.Close() }
+}
+
+func init() {
+	migrate.Flags().Bool("dry-run", false, "print pending migrations without applying them")
+	migrate.AddCommand(migrateStatus)
+}
+
+func main() {
+	ctx = context.Background()
+	if err := migrate.Execute(); err != nil {
+		log.Fatal().Err(err).Msg("migrating")
+	}
+}
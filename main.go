@@ -13,12 +13,15 @@ import (
 	"sync"
 	"time"
 
+	"github.com/digitalocean/apps-self-check/pkg/auth"
 	"github.com/digitalocean/apps-self-check/pkg/checker"
+	"github.com/digitalocean/apps-self-check/pkg/otlp"
 	"github.com/digitalocean/apps-self-check/pkg/storer"
 	"github.com/digitalocean/apps-self-check/pkg/types/check"
 	"github.com/go-sql-driver/mysql"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -50,7 +53,43 @@ func main() {
 		bindAddr = b
 	}
 
-	s, err := storer.New(ctx, os.Getenv("DATABASE_URL"), os.Getenv("DATABASE_CA_CERT"), true)
+	var storerOpts []storer.StorerOption
+	if spoolDir := os.Getenv("SPOOL_DIR"); spoolDir != "" {
+		var maxBytes int64
+		if maxBytesS := os.Getenv("SPOOL_MAX_BYTES"); maxBytesS != "" {
+			var err error
+			if maxBytes, err = strconv.ParseInt(maxBytesS, 10, 64); err != nil {
+				log.Fatal().Err(err).Msg("parsing SPOOL_MAX_BYTES")
+			}
+		}
+		storerOpts = append(storerOpts, storer.WithSpool(spoolDir, maxBytes))
+	}
+	depthS, policyS := os.Getenv("SAVE_QUEUE_DEPTH"), os.Getenv("SAVE_QUEUE_OVERFLOW")
+	if depthS != "" || policyS != "" {
+		var depth int
+		var policy storer.OverflowPolicy
+		var err error
+		if depthS != "" {
+			if depth, err = strconv.Atoi(depthS); err != nil {
+				log.Fatal().Err(err).Msg("parsing SAVE_QUEUE_DEPTH")
+			}
+		}
+		if policyS != "" {
+			if policy, err = storer.ParseOverflowPolicy(policyS); err != nil {
+				log.Fatal().Err(err).Msg("parsing SAVE_QUEUE_OVERFLOW")
+			}
+		}
+		storerOpts = append(storerOpts, storer.WithQueue(depth, policy))
+	}
+	if workersS := os.Getenv("SAVE_QUEUE_WORKERS"); workersS != "" {
+		workers, err := strconv.Atoi(workersS)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing SAVE_QUEUE_WORKERS")
+		}
+		storerOpts = append(storerOpts, storer.WithWorkers(workers))
+	}
+	storerOpts = append(storerOpts, storer.WithWebhook(webhookConfig()))
+	s, err := storer.New(ctx, os.Getenv("DATABASE_URL"), os.Getenv("DATABASE_CA_CERT"), true, storerOpts...)
 	if err != nil {
 		log.Fatal().Err(err).Msg("creating storer")
 	}
@@ -79,6 +118,84 @@ func main() {
 		checker.WithStorer(s),
 	}
 
+	if checkTimeoutS := os.Getenv("CHECK_TIMEOUT"); checkTimeoutS != "" {
+		checkTimeout, err := time.ParseDuration(checkTimeoutS)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing CHECK_TIMEOUT")
+		}
+		checkerOpts = append(checkerOpts, checker.WithTimeout(checkTimeout))
+	}
+
+	if required := os.Getenv("READY_REQUIRED_CHECKS"); required != "" {
+		var names []string
+		for _, name := range strings.Split(required, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		checkerOpts = append(checkerOpts, checker.WithRequiredChecks(names...))
+	}
+	var checkInterval time.Duration
+	if checkIntervalS := os.Getenv("CHECK_INTERVAL"); checkIntervalS != "" {
+		if checkInterval, err = time.ParseDuration(checkIntervalS); err != nil {
+			log.Fatal().Err(err).Msg("parsing CHECK_INTERVAL")
+		}
+	}
+	// Without periodic cycles, only a full on-demand check could pass the gate, so it's off by
+	// default.
+	startupGate := checkInterval > 0
+	if gate := os.Getenv("READY_STARTUP_GATE"); gate != "" {
+		if startupGate, err = strconv.ParseBool(gate); err != nil {
+			log.Fatal().Err(err).Msg("parsing READY_STARTUP_GATE")
+		}
+	}
+	checkerOpts = append(checkerOpts, checker.WithStartupGate(startupGate))
+	if graceS := os.Getenv("READY_GRACE_PERIOD"); graceS != "" {
+		grace, err := time.ParseDuration(graceS)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing READY_GRACE_PERIOD")
+		}
+		checkerOpts = append(checkerOpts, checker.WithGracePeriod(grace))
+	}
+	if windowS := os.Getenv("RESTORE_WINDOW"); windowS != "" {
+		window, err := time.ParseDuration(windowS)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing RESTORE_WINDOW")
+		}
+		checkerOpts = append(checkerOpts, checker.WithRestoreWindow(window))
+	}
+
+	if ttlS := os.Getenv("CHECK_CACHE_TTL"); ttlS != "" {
+		ttl, err := time.ParseDuration(ttlS)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing CHECK_CACHE_TTL")
+		}
+		checkerOpts = append(checkerOpts, checker.WithCheckCacheTTL(ttl))
+	}
+	if limitS := os.Getenv("CHECK_RATE_LIMIT"); limitS != "" {
+		limit, err := strconv.ParseFloat(limitS, 64)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing CHECK_RATE_LIMIT")
+		}
+		burst := 1
+		if burstS := os.Getenv("CHECK_RATE_BURST"); burstS != "" {
+			if burst, err = strconv.Atoi(burstS); err != nil {
+				log.Fatal().Err(err).Msg("parsing CHECK_RATE_BURST")
+			}
+		}
+		checkerOpts = append(checkerOpts, checker.WithCheckRateLimit(rate.Limit(limit), burst))
+	}
+
+	otlpConfig, err := otlp.ConfigFromEnv(os.Getenv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("configuring otlp exporter")
+	}
+	if otlpConfig != nil {
+		exporter := otlp.New(*otlpConfig, instance)
+		defer exporter.Close()
+		checkerOpts = append(checkerOpts, checker.WithOTLPExporter(exporter))
+	}
+
 	if publicURL := os.Getenv("PUBLIC_URL"); publicURL != "" {
 		checkerOpts = append(checkerOpts, checker.WithCheck("self_public_http", checkMust(checker.NewHTTPCheck(publicURL))))
 	}
@@ -98,15 +215,49 @@ func main() {
 			checker.WithCheck("database_dns", checkMust(checker.NewDNSCheck(checkDB, os.Getenv("CHECK_DATABASE_CIDR")))))
 	}
 
+	authenticator, err := authFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("configuring authentication")
+	}
+	protect := func(h http.HandlerFunc) http.Handler {
+		if authenticator == nil {
+			return h
+		}
+		return auth.Middleware(authenticator, h)
+	}
+
 	c := checker.NewChecker(checkerOpts...)
+	if err := c.ValidateRequiredChecks(); err != nil {
+		log.Fatal().Err(err).Msg("parsing READY_REQUIRED_CHECKS")
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/check", c.CheckHandler)
-	mux.HandleFunc("/status", c.StatusHandler)
+	mux.HandleFunc("/ready", c.ReadyHandler)
+	mux.Handle("/check", protect(c.CheckHandler))
+	mux.Handle("/status", protect(c.StatusHandler))
+	mux.Handle("/status.html", protect(c.StatusHTMLHandler))
+	mux.Handle("/metrics", protect(c.MetricsHandler))
+	mux.Handle("/events", protect(c.EventsHandler))
+	mux.Handle("/history", protect(c.HistoryHandler))
+	if authenticator != nil {
+		// Labels can be changed at runtime, so the endpoint is only served with authentication.
+		mux.Handle("/labels", protect(c.LabelsHandler))
+	}
 
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
+	// Restoring queries the storer, so it runs in the background rather than delaying startup.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		restoreCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := c.Restore(restoreCtx); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("restoring status from previous instances")
+		}
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -131,11 +282,7 @@ func main() {
 			return s.UpdateInstance(ctx, instance)
 		})
 
-	if checkIntervalS := os.Getenv("CHECK_INTERVAL"); checkIntervalS != "" {
-		checkInterval, err := time.ParseDuration(checkIntervalS)
-		if err != nil {
-			log.Fatal().Err(err).Msg("parsing CHECK_INTERVAL")
-		}
+	if checkInterval > 0 {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -143,12 +290,44 @@ func main() {
 		}()
 	}
 
+	if rawAgeS := os.Getenv("RETENTION_RAW_AGE"); rawAgeS != "" {
+		retainer, ok := storer.Primary(s).(storer.Retainer)
+		if !ok {
+			log.Fatal().Msg("RETENTION_RAW_AGE is set but the storer does not support retention")
+		}
+		policy := storer.RetentionPolicy{BatchSize: storer.DefaultRetentionBatchSize}
+		retentionInterval := time.Hour
+		for env, d := range map[string]*time.Duration{
+			"RETENTION_RAW_AGE":    &policy.RawAge,
+			"RETENTION_HOURLY_AGE": &policy.HourlyAge,
+			"RETENTION_DAILY_AGE":  &policy.DailyAge,
+			"RETENTION_INTERVAL":   &retentionInterval,
+		} {
+			if v := os.Getenv(env); v != "" {
+				if *d, err = time.ParseDuration(v); err != nil {
+					log.Fatal().Err(err).Msgf("parsing %s", env)
+				}
+			}
+		}
+		if batchS := os.Getenv("RETENTION_BATCH_SIZE"); batchS != "" {
+			if policy.BatchSize, err = strconv.Atoi(batchS); err != nil {
+				log.Fatal().Err(err).Msg("parsing RETENTION_BATCH_SIZE")
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			storer.RunRetention(ctx, retainer, policy, retentionInterval)
+		}()
+	}
+
 	serverAddr := net.JoinHostPort(bindAddr, strconv.Itoa(int(port)))
 	log.Ctx(ctx).Info().Str("addr", serverAddr).Msg("starting server")
 	server := http.Server{
 		Addr:    serverAddr,
 		Handler: mux,
 	}
+	server.RegisterOnShutdown(c.CloseEvents)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -158,6 +337,7 @@ func main() {
 		if err := server.Shutdown(ctx); err != nil {
 			log.Error().Err(err).Msg("shutting down server")
 		}
+		c.WaitOnDemandChecks()
 	}()
 	if err := server.ListenAndServe(); err != nil && ctx.Err() == nil {
 		log.Fatal().Err(err).Msg("failed to start server")
@@ -176,6 +356,65 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, http.StatusText(http.StatusOK), http.StatusOK)
 }
 
+// authFromEnv builds an authenticator from the AUTH_* env vars. If an IP allowlist is configured it
+// must always pass, and if a bearer token or HMAC secret are configured one of them must pass. A nil
+// authenticator is returned if nothing is configured.
+func authFromEnv() (auth.Authenticator, error) {
+	var all, credentials []auth.Authenticator
+	if allowlist := os.Getenv("AUTH_IP_ALLOWLIST"); allowlist != "" {
+		a, err := auth.NewIPAllowlist(strings.Split(allowlist, ","), os.Getenv("AUTH_IP_HEADER"))
+		if err != nil {
+			return nil, fmt.Errorf("parsing AUTH_IP_ALLOWLIST: %w", err)
+		}
+		all = append(all, a)
+	}
+	if token := os.Getenv("AUTH_BEARER_TOKEN"); token != "" {
+		credentials = append(credentials, auth.NewBearerToken(token))
+	}
+	if secret := os.Getenv("AUTH_HMAC_SECRET"); secret != "" {
+		window := auth.DefaultHMACWindow
+		if w := os.Getenv("AUTH_HMAC_WINDOW"); w != "" {
+			var err error
+			if window, err = time.ParseDuration(w); err != nil {
+				return nil, fmt.Errorf("parsing AUTH_HMAC_WINDOW: %w", err)
+			}
+		}
+		credentials = append(credentials, auth.NewHMAC([]byte(secret), window))
+	}
+	if len(credentials) > 0 {
+		all = append(all, auth.Any(credentials...))
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return auth.All(all...), nil
+}
+
+// webhookConfig configures the webhook storer, used when DATABASE_URL is an http(s) URL.
+// WEBHOOK_HEADERS uses the same format as LABELS, ex. `authorization=Bearer%20xyz`.
+func webhookConfig() storer.WebhookConfig {
+	var cfg storer.WebhookConfig
+	var err error
+	if cfg.Headers, err = parseLabels(os.Getenv("WEBHOOK_HEADERS")); err != nil {
+		log.Fatal().Err(err).Msg("parsing WEBHOOK_HEADERS")
+	}
+	if secret := os.Getenv("WEBHOOK_HMAC_SECRET"); secret != "" {
+		cfg.Secret = []byte(secret)
+	}
+	cfg.BodyTemplate = os.Getenv("WEBHOOK_TEMPLATE")
+	if batchS := os.Getenv("WEBHOOK_BATCH_SIZE"); batchS != "" {
+		if cfg.BatchSize, err = strconv.Atoi(batchS); err != nil {
+			log.Fatal().Err(err).Msg("parsing WEBHOOK_BATCH_SIZE")
+		}
+	}
+	if timeoutS := os.Getenv("WEBHOOK_TIMEOUT"); timeoutS != "" {
+		if cfg.Timeout, err = time.ParseDuration(timeoutS); err != nil {
+			log.Fatal().Err(err).Msg("parsing WEBHOOK_TIMEOUT")
+		}
+	}
+	return cfg
+}
+
 func parseLabels(labels string) (map[string]string, error) {
 	out := make(map[string]string)
 	asQuery, err := url.ParseQuery(labels)
@@ -0,0 +1,221 @@
+// Package auth provides pluggable authentication for the HTTP handlers.
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// TimestampHeader carries the unix timestamp (in seconds) of an HMAC signed request.
+	TimestampHeader = "X-Self-Check-Timestamp"
+	// SignatureHeader carries the hex encoded HMAC-SHA256 signature of a request.
+	SignatureHeader = "X-Self-Check-Signature"
+
+	// DefaultHMACWindow is the default tolerance for the timestamp of signed requests.
+	DefaultHMACWindow = 5 * time.Minute
+
+	maxSignedBodySize = 1 << 20
+)
+
+var (
+	// ErrUnauthorized indicates the request lacked valid credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden indicates the request came from a disallowed source.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Authenticator validates requests.
+type Authenticator interface {
+	// Authenticate returns nil if the request is allowed. Errors should wrap ErrUnauthorized or
+	// ErrForbidden.
+	Authenticate(r *http.Request) error
+}
+
+// AuthenticatorFunc adapts a func to the Authenticator interface.
+type AuthenticatorFunc func(r *http.Request) error
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *http.Request) error {
+	return f(r)
+}
+
+// Middleware rejects requests which fail authentication.
+func Middleware(a Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.Authenticate(r); err != nil {
+			log.Ctx(r.Context()).Info().Err(err).
+				Str("path", r.URL.Path).
+				Str("remote_addr", r.RemoteAddr).
+				Msg("rejected request")
+			if errors.Is(err, ErrForbidden) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			w.Header().Add("www-authenticate", `Bearer realm="self-check"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Any allows requests which pass at least one of the authenticators.
+func Any(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) error {
+		err := fmt.Errorf("%w: no credentials", ErrUnauthorized)
+		for _, a := range auths {
+			if err = a.Authenticate(r); err == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// All allows requests which pass every authenticator.
+func All(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) error {
+		for _, a := range auths {
+			if err := a.Authenticate(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// NewBearerToken authenticates requests with an `Authorization: Bearer <token>` header.
+func NewBearerToken(token string) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) error {
+		scheme, provided, ok := strings.Cut(r.Header.Get("authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") {
+			return fmt.Errorf("%w: missing bearer token", ErrUnauthorized)
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			return fmt.Errorf("%w: invalid bearer token", ErrUnauthorized)
+		}
+		return nil
+	})
+}
+
+// NewHMAC authenticates requests signed with the shared secret. Requests must include the
+// TimestampHeader and SignatureHeader; the timestamp must be within the window of the current
+// time. Bodies larger than 1MiB are rejected. See Sign for the signed payload.
+func NewHMAC(secret []byte, window time.Duration) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) error {
+		tsHeader := r.Header.Get(TimestampHeader)
+		sigHeader := r.Header.Get(SignatureHeader)
+		if tsHeader == "" || sigHeader == "" {
+			return fmt.Errorf("%w: missing signature", ErrUnauthorized)
+		}
+		ts, err := strconv.ParseInt(tsHeader, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid timestamp", ErrUnauthorized)
+		}
+		if skew := time.Since(time.Unix(ts, 0)); skew > window || skew < -window {
+			return fmt.Errorf("%w: timestamp outside of window", ErrUnauthorized)
+		}
+		sig, err := hex.DecodeString(sigHeader)
+		if err != nil {
+			return fmt.Errorf("%w: invalid signature encoding", ErrUnauthorized)
+		}
+		var body []byte
+		if r.Body != nil {
+			body, err = io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+			if err != nil {
+				return fmt.Errorf("%w: reading body: %v", ErrUnauthorized, err)
+			}
+			if len(body) > maxSignedBodySize {
+				// Leave the body intact for any other authenticator rather than verify a prefix.
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				return fmt.Errorf("%w: body exceeds %d bytes", ErrUnauthorized, maxSignedBodySize)
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if !hmac.Equal(sig, signature(secret, tsHeader, r.Method, r.URL.RequestURI(), body)) {
+			return fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+		}
+		return nil
+	})
+}
+
+// Sign adds HMAC signature headers to a request. The signature covers the timestamp, method,
+// request URI and a SHA-256 hash of the body, separated by newlines.
+func Sign(r *http.Request, secret []byte, body []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(TimestampHeader, ts)
+	r.Header.Set(SignatureHeader, hex.EncodeToString(signature(secret, ts, r.Method, r.URL.RequestURI(), body)))
+}
+
+func signature(secret []byte, ts, method, uri string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "\n" + method + "\n" + uri + "\n" + hex.EncodeToString(bodyHash[:])))
+	return mac.Sum(nil)
+}
+
+// NewIPAllowlist allows requests from the listed addresses, which may be IPs or CIDRs. If header is
+// non-empty the client address is read from that header, which must be set by a trusted proxy
+// (ex. `do-connecting-ip` on App Platform), rather than from the connection.
+func NewIPAllowlist(allowed []string, header string) (Authenticator, error) {
+	var nets []*net.IPNet
+	for _, a := range allowed {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, fmt.Errorf("parsing allowed ip %q", a)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, fmt.Errorf("parsing allowed cidr: %w", err)
+		}
+		nets = append(nets, n)
+	}
+	return AuthenticatorFunc(func(r *http.Request) error {
+		addr := r.RemoteAddr
+		if header != "" {
+			addr = r.Header.Get(header)
+		} else if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		ip := net.ParseIP(strings.TrimSpace(addr))
+		if ip == nil {
+			return fmt.Errorf("%w: unable to determine client ip", ErrForbidden)
+		}
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: client ip %s is not allowed", ErrForbidden, ip)
+	}), nil
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	secret := []byte("hmac-secret")
+	allowlist, err := NewIPAllowlist([]string{"10.0.0.0/8", "192.0.2.1"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := All(allowlist, Any(NewBearerToken("token"), NewHMAC(secret, time.Minute)))
+	h := Middleware(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tcs := []struct {
+		name         string
+		remoteAddr   string
+		prepare      func(r *http.Request)
+		expectStatus int
+	}{
+		{
+			name:         "no credentials",
+			remoteAddr:   "10.1.2.3:1234",
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "bearer token",
+			remoteAddr: "10.1.2.3:1234",
+			prepare: func(r *http.Request) {
+				r.Header.Set("authorization", "Bearer token")
+			},
+			expectStatus: http.StatusNoContent,
+		},
+		{
+			name:       "wrong bearer token",
+			remoteAddr: "10.1.2.3:1234",
+			prepare: func(r *http.Request) {
+				r.Header.Set("authorization", "Bearer nope")
+			},
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "disallowed ip",
+			remoteAddr: "192.0.2.2:1234",
+			prepare: func(r *http.Request) {
+				r.Header.Set("authorization", "Bearer token")
+			},
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:       "hmac",
+			remoteAddr: "192.0.2.1:1234",
+			prepare: func(r *http.Request) {
+				Sign(r, secret, []byte("body"), time.Now())
+			},
+			expectStatus: http.StatusNoContent,
+		},
+		{
+			name:       "expired hmac",
+			remoteAddr: "192.0.2.1:1234",
+			prepare: func(r *http.Request) {
+				Sign(r, secret, []byte("body"), time.Now().Add(-2*time.Minute))
+			},
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "hmac with wrong secret",
+			remoteAddr: "192.0.2.1:1234",
+			prepare: func(r *http.Request) {
+				Sign(r, []byte("wrong"), []byte("body"), time.Now())
+			},
+			expectStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/check?save=false", bytes.NewReader([]byte("body")))
+			r.RemoteAddr = tc.remoteAddr
+			if tc.prepare != nil {
+				tc.prepare(r)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.expectStatus {
+				t.Errorf("expected status %d, got %d", tc.expectStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestIPAllowlistHeader(t *testing.T) {
+	a, err := NewIPAllowlist([]string{"203.0.113.0/24"}, "do-connecting-ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/status", nil)
+	r.Header.Set("do-connecting-ip", "203.0.113.7")
+	if err := a.Authenticate(r); err != nil {
+		t.Errorf("expected header ip to be allowed: %v", err)
+	}
+	r.Header.Set("do-connecting-ip", "198.51.100.1")
+	if err := a.Authenticate(r); err == nil {
+		t.Errorf("expected header ip to be rejected")
+	}
+}
+
+func TestHMACBodyLimit(t *testing.T) {
+	secret := []byte("hmac-secret")
+	body := bytes.Repeat([]byte("a"), maxSignedBodySize+1)
+	a := NewHMAC(secret, time.Minute)
+	// Neither the whole body nor the prefix which fits the limit may be verified.
+	for _, signed := range [][]byte{body, body[:maxSignedBodySize]} {
+		r := httptest.NewRequest(http.MethodPost, "/check", bytes.NewReader(body))
+		Sign(r, secret, signed, time.Now())
+		if err := a.Authenticate(r); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("expected an oversized body to be rejected, got %v", err)
+		}
+	}
+
+	// Another authenticator may still allow the request, with its body intact.
+	h := Middleware(Any(NewHMAC(secret, time.Minute), NewBearerToken("token")), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, _ := io.ReadAll(r.Body); !bytes.Equal(got, body) {
+			t.Errorf("expected the whole body, got %d bytes", len(got))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/check", bytes.NewReader(body))
+	Sign(r, secret, body, time.Now())
+	r.Header.Set("authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+}
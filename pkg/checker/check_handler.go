@@ -0,0 +1,336 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	formatJSON       = "json"
+	formatText       = "text"
+	formatPrometheus = "prometheus"
+)
+
+// onDemandSaveTimeout bounds saving an on-demand cycle's results, which starts once its checks
+// finish or time out.
+const onDemandSaveTimeout = 10 * time.Second
+
+// Values of the x-self-check-source header describing how on-demand results were produced.
+const (
+	sourceFresh     = "fresh"
+	sourceCoalesced = "coalesced"
+	sourceCached    = "cached"
+)
+
+// WithCheckCacheTTL allows on-demand checks to be answered with results from an identical
+// on-demand check which finished within the ttl.
+func WithCheckCacheTTL(ttl time.Duration) CheckerOption {
+	return func(c *checker) {
+		c.onDemand.ttl = ttl
+	}
+}
+
+// WithCheckRateLimit limits the rate at which on-demand checks start new check cycles. Requests
+// answered from an in-flight or cached cycle are not limited.
+func WithCheckRateLimit(limit rate.Limit, burst int) CheckerOption {
+	return func(c *checker) {
+		c.onDemand.limiter = rate.NewLimiter(limit, burst)
+	}
+}
+
+// onDemandCall is an on-demand check cycle which may be shared by several requests.
+type onDemandCall struct {
+	done     chan struct{}
+	results  check.CheckResults
+	outcomes []checkOutcome
+	finished time.Time
+}
+
+// onDemandRuns coalesces concurrent on-demand checks with the same parameters.
+type onDemandRuns struct {
+	lock     sync.Mutex
+	ttl      time.Duration
+	limiter  *rate.Limiter
+	inflight map[string]*onDemandCall
+	cached   map[string]*onDemandCall
+	// wg tracks running calls, including saving their results.
+	wg sync.WaitGroup
+	// joined is called with the source of each request's call, allowing tests to synchronize.
+	joined func(source string)
+}
+
+// retryAfter estimates how long until the rate limit allows a new cycle. It is zero if the limit
+// never allows one.
+func (o *onDemandRuns) retryAfter() time.Duration {
+	limit := o.limiter.Limit()
+	if limit <= 0 {
+		return 0
+	}
+	d := time.Duration((1 - o.limiter.Tokens()) / float64(limit) * float64(time.Second))
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// checkRequest describes the options of an on-demand check.
+type checkRequest struct {
+	checks  []checkFunc
+	save    bool
+	timeout time.Duration
+	format  string
+}
+
+// CheckHandler runs checks on-demand. The following query parameters are supported:
+//   - check: run only the named check; may be repeated.
+//   - save: if false, results are not persisted to the storer.
+//   - timeout: a duration limiting the checks; capped at the checker's timeout. Checks still
+//     running when it expires are reported as timed out.
+//   - format: one of json (default), text or prometheus.
+//
+// A 503 status is returned if any of the selected checks fail. Concurrent requests with the same
+// checks, save and timeout options share a single check cycle, and a 429 status is returned if a
+// new cycle would exceed the rate limit.
+func (c *checker) CheckHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req, err := c.parseCheckRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	call, source := c.onDemandCall(ctx, req)
+	if call == nil {
+		if d := c.onDemand.retryAfter(); d > 0 {
+			w.Header().Set("retry-after", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+		}
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	if c.onDemand.joined != nil {
+		c.onDemand.joined(source)
+	}
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return
+	}
+	results, outcomes := call.results, call.outcomes
+	w.Header().Add("x-self-check-source", source)
+
+	status := http.StatusOK
+	for _, o := range outcomes {
+		if o.err != nil {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	switch req.format {
+	case formatText:
+		w.Header().Add("content-type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		writeCheckSummary(w, outcomes)
+	case formatPrometheus:
+		w.Header().Add("content-type", metricsContentType)
+		w.WriteHeader(status)
+		pw := newPromWriter(w)
+		writeCheckMetrics(pw, results, outcomes)
+		if err := pw.Flush(); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("writing spot check metrics")
+		}
+	default:
+		j := json.NewEncoder(w)
+		j.SetIndent("", "  ")
+		w.Header().Add("content-type", "application/json")
+		w.WriteHeader(status)
+		if err := j.Encode(results); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("encoding spot check")
+		}
+	}
+}
+
+// onDemandCall returns an in-flight or cached call matching the request, or starts a new one. A nil
+// call is returned if starting a new call would exceed the rate limit.
+func (c *checker) onDemandCall(ctx context.Context, req checkRequest) (*onDemandCall, string) {
+	names := make([]string, 0, len(req.checks))
+	for _, ch := range req.checks {
+		names = append(names, ch.name)
+	}
+	sort.Strings(names)
+	// Calls with a shorter timeout may have cut off checks which a longer one would complete.
+	key := strings.Join(names, ",") + "|save=" + strconv.FormatBool(req.save) + "|timeout=" + req.timeout.String()
+
+	o := &c.onDemand
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if call, ok := o.inflight[key]; ok {
+		return call, sourceCoalesced
+	}
+	if call, ok := o.cached[key]; ok {
+		if c.now().Sub(call.finished) < o.ttl {
+			return call, sourceCached
+		}
+		delete(o.cached, key)
+	}
+	if o.limiter != nil && !o.limiter.Allow() {
+		return nil, ""
+	}
+
+	call := &onDemandCall{done: make(chan struct{})}
+	if o.inflight == nil {
+		o.inflight = make(map[string]*onDemandCall)
+		o.cached = make(map[string]*onDemandCall)
+	}
+	o.inflight[key] = call
+	// The cycle is detached from the request which started it, so other requests sharing it aren't
+	// affected if that client disconnects.
+	detached := log.Ctx(ctx).WithContext(context.Background())
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		checkCtx, cancel := context.WithTimeout(detached, req.timeout)
+		call.results, call.outcomes = c.doCycle(checkCtx, req.checks, true)
+		cancel()
+		sortOutcomes(call.outcomes)
+		if req.save {
+			// The checks may have used up the timeout, so saving gets its own.
+			saveCtx, cancel := context.WithTimeout(detached, onDemandSaveTimeout)
+			if err := c.storer.SaveCheckResults(saveCtx, call.results); err != nil {
+				log.Ctx(saveCtx).Err(err).Msg("saving check results")
+			}
+			cancel()
+		}
+		call.finished = c.now()
+
+		o.lock.Lock()
+		delete(o.inflight, key)
+		if o.ttl > 0 {
+			o.cached[key] = call
+		}
+		o.lock.Unlock()
+		close(call.done)
+	}()
+	return call, sourceFresh
+}
+
+// WaitOnDemandChecks waits for running on-demand check cycles to finish and save their results.
+// It should be called once the server has shut down, before closing the storer.
+func (c *checker) WaitOnDemandChecks() {
+	c.onDemand.wg.Wait()
+}
+
+func (c *checker) parseCheckRequest(r *http.Request) (checkRequest, error) {
+	q := r.URL.Query()
+	req := checkRequest{
+		checks:  c.checks,
+		save:    true,
+		timeout: c.timeout,
+		format:  formatJSON,
+	}
+	if names := q["check"]; len(names) > 0 {
+		var err error
+		if req.checks, err = c.selectChecks(names); err != nil {
+			return req, err
+		}
+	}
+	if s := q.Get("save"); s != "" {
+		var err error
+		if req.save, err = strconv.ParseBool(s); err != nil {
+			return req, fmt.Errorf("parsing save: %w", err)
+		}
+	}
+	if t := q.Get("timeout"); t != "" {
+		timeout, err := time.ParseDuration(t)
+		if err != nil {
+			return req, fmt.Errorf("parsing timeout: %w", err)
+		}
+		if timeout <= 0 {
+			return req, fmt.Errorf("timeout must be positive")
+		}
+		if timeout < req.timeout {
+			req.timeout = timeout
+		}
+	}
+	if f := q.Get("format"); f != "" {
+		switch f {
+		case formatJSON, formatText, formatPrometheus:
+			req.format = f
+		default:
+			return req, fmt.Errorf("unsupported format %q", f)
+		}
+	}
+	return req, nil
+}
+
+// selectChecks returns the named checks, or an error if any are unknown.
+func (c *checker) selectChecks(names []string) ([]checkFunc, error) {
+	want := make(map[string]bool, len(names))
+	for _, n := range names {
+		want[n] = true
+	}
+	var out []checkFunc
+	for _, ch := range c.checks {
+		if want[ch.name] {
+			out = append(out, ch)
+			delete(want, ch.name)
+		}
+	}
+	if len(want) > 0 {
+		return nil, fmt.Errorf("unknown checks: %v", sortedKeys(want))
+	}
+	return out, nil
+}
+
+// writeCheckSummary writes one line per check.
+func writeCheckSummary(w http.ResponseWriter, outcomes []checkOutcome) {
+	for _, o := range outcomes {
+		switch {
+		case o.aborted:
+			fmt.Fprintf(w, "ABORTED %s %s: %v\n", o.name, o.duration.Round(time.Millisecond), o.err)
+		case o.err != nil:
+			fmt.Fprintf(w, "FAIL    %s %s: %v\n", o.name, o.duration.Round(time.Millisecond), o.err)
+		default:
+			fmt.Fprintf(w, "OK      %s %s\n", o.name, o.duration.Round(time.Millisecond))
+		}
+	}
+}
+
+// writeCheckMetrics renders a single set of check results in the prometheus text format.
+func writeCheckMetrics(pw *promWriter, results check.CheckResults, outcomes []checkOutcome) {
+	pw.header("self_check_up", "gauge", "Whether the check succeeded.")
+	for _, o := range outcomes {
+		var up float64
+		if o.err == nil {
+			up = 1
+		}
+		pw.sample("self_check_up", []promLabel{{"check", o.name}}, up)
+	}
+	pw.header("self_check_last_duration_seconds", "gauge", "Duration of the check.")
+	for _, o := range outcomes {
+		pw.sample("self_check_last_duration_seconds", []promLabel{{"check", o.name}}, o.duration.Seconds())
+	}
+	if len(results.Measurements) > 0 {
+		pw.header("self_check_measurement", "gauge", "Value reported for each check measurement.")
+		for _, m := range results.Measurements {
+			pw.sample("self_check_measurement", []promLabel{{"check", m.Check}}, m.Value)
+		}
+	}
+}
+
+func sortOutcomes(outcomes []checkOutcome) {
+	sort.Slice(outcomes, func(i, j int) bool {
+		return outcomes[i].name < outcomes[j].name
+	})
+}
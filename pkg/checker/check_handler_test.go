@@ -0,0 +1,232 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	gomock "github.com/golang/mock/gomock"
+	"golang.org/x/time/rate"
+)
+
+func TestCheckHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := storer.NewMockStorer(ctrl)
+
+	c := NewChecker(
+		WithStorer(m),
+		WithCheck("ok", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, nil
+		}),
+		WithCheck("broken", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, errors.New("nope")
+		}),
+	)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	tcs := []struct {
+		name         string
+		url          string
+		expectSave   bool
+		expectStatus int
+		expectBody   string
+	}{
+		{
+			name:         "subset without save",
+			url:          "/check?check=ok&save=false&format=text",
+			expectStatus: http.StatusOK,
+			expectBody:   "OK      ok 0s\n",
+		},
+		{
+			name:         "failure",
+			url:          "/check?check=broken&format=prometheus",
+			expectSave:   true,
+			expectStatus: http.StatusServiceUnavailable,
+			expectBody: "# HELP self_check_up Whether the check succeeded.\n" +
+				"# TYPE self_check_up gauge\n" +
+				"self_check_up{check=\"broken\"} 0\n" +
+				"# HELP self_check_last_duration_seconds Duration of the check.\n" +
+				"# TYPE self_check_last_duration_seconds gauge\n" +
+				"self_check_last_duration_seconds{check=\"broken\"} 0\n",
+		},
+		{
+			name:         "unknown check",
+			url:          "/check?check=missing",
+			expectStatus: http.StatusBadRequest,
+			expectBody:   "unknown checks: [missing]\n",
+		},
+		{
+			name:         "bad format",
+			url:          "/check?format=xml",
+			expectStatus: http.StatusBadRequest,
+			expectBody:   "unsupported format \"xml\"\n",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expectSave {
+				m.EXPECT().SaveCheckResults(gomock.Any(), gomock.Any()).Return(nil)
+			}
+			rec := httptest.NewRecorder()
+			c.CheckHandler(rec, httptest.NewRequest("GET", tc.url, nil))
+			if rec.Code != tc.expectStatus {
+				t.Errorf("expected status %d, got %d", tc.expectStatus, rec.Code)
+			}
+			if got := rec.Body.String(); got != tc.expectBody {
+				t.Errorf("expected body %q, got %q", tc.expectBody, got)
+			}
+		})
+	}
+}
+
+func TestCheckHandlerTimeout(t *testing.T) {
+	c := NewChecker(
+		WithCheck("ok", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, nil
+		}),
+		WithCheck("hang", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+	)
+	for _, url := range []string{"/check?check=hang&save=false&timeout=10ms", "/check?save=false&timeout=10ms"} {
+		rec := httptest.NewRecorder()
+		c.CheckHandler(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status %d, got %d", url, http.StatusServiceUnavailable, rec.Code)
+		}
+		var r check.CheckResults
+		if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		expected := []check.CheckError{{Check: "hang", Error: "timed out: context deadline exceeded"}}
+		if !reflect.DeepEqual(r.Errors, expected) {
+			t.Errorf("%s: expected the cut off check to be reported, got %+v", url, r.Errors)
+		}
+	}
+	// Only the run of every check counts as a cycle.
+	if stats := c.status().Stats; stats.Cycles != 1 || stats.CyclesWithErrors != 1 || stats.PartialCycles != 1 {
+		t.Errorf("expected 1 full and 1 partial cycle, got %+v", stats)
+	}
+}
+
+func TestCheckHandlerSavesAfterTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := storer.NewMockStorer(ctrl)
+	c := NewChecker(
+		WithStorer(m),
+		WithCheck("hang", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+	)
+	m.EXPECT().SaveCheckResults(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, r check.CheckResults) error {
+		if err := ctx.Err(); err != nil {
+			t.Errorf("expected the results to be saved with a live ctx, got %v", err)
+		}
+		if len(r.Errors) != 1 {
+			t.Errorf("expected the timed out check to be saved, got %+v", r.Errors)
+		}
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	c.CheckHandler(rec, httptest.NewRequest("GET", "/check?timeout=10ms", nil))
+	c.WaitOnDemandChecks()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestCheckHandlerCoalescing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := storer.NewMockStorer(ctrl)
+
+	var runs int32
+	release := make(chan struct{})
+	c := NewChecker(
+		WithStorer(m),
+		WithCheckCacheTTL(time.Minute),
+		WithCheckRateLimit(rate.Every(time.Hour), 1),
+		WithCheck("slow", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			atomic.AddInt32(&runs, 1)
+			<-release
+			return nil, nil
+		}),
+	)
+	m.EXPECT().SaveCheckResults(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	joined := make(chan string)
+	c.onDemand.joined = func(source string) {
+		joined <- source
+	}
+
+	var wg sync.WaitGroup
+	sources := make([]string, 3)
+	for i := range sources {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			c.CheckHandler(rec, httptest.NewRequest("GET", "/check", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+			sources[i] = rec.Header().Get("x-self-check-source")
+		}()
+	}
+	// Wait until every request has joined the in-flight cycle.
+	for range sources {
+		select {
+		case <-joined:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for requests to join")
+		}
+	}
+	c.onDemand.joined = nil
+	close(release)
+	wg.Wait()
+
+	if runs != 1 {
+		t.Errorf("expected checks to run once, ran %d times", runs)
+	}
+	sort.Strings(sources)
+	if want := []string{sourceCoalesced, sourceCoalesced, sourceFresh}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("expected sources %v, got %v", want, sources)
+	}
+
+	rec := httptest.NewRecorder()
+	c.CheckHandler(rec, httptest.NewRequest("GET", "/check", nil))
+	if got := rec.Header().Get("x-self-check-source"); got != sourceCached {
+		t.Errorf("expected cached results, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	c.CheckHandler(rec, httptest.NewRequest("GET", "/check?save=false", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("retry-after"); got != "3600" {
+		t.Errorf("expected retry-after 3600, got %q", got)
+	}
+
+	// A shorter timeout doesn't share the cached cycle, and is rate limited instead.
+	rec = httptest.NewRecorder()
+	c.CheckHandler(rec, httptest.NewRequest("GET", "/check?timeout=1ms", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+}
@@ -0,0 +1,236 @@
+package checker
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog/log"
+)
+
+// sparklineSamples is the number of recent durations retained for each check's sparkline.
+const sparklineSamples = 60
+
+const (
+	sparklineWidth  = 120
+	sparklineHeight = 24
+)
+
+//go:embed dashboard
+var dashboardFS embed.FS
+
+var dashboardTemplate = template.Must(template.New("status.html.tmpl").Funcs(template.FuncMap{
+	"ts":       formatDashboardTime,
+	"duration": formatDashboardDuration,
+}).ParseFS(dashboardFS, "dashboard/*"))
+
+// latencyRing retains the most recent check durations.
+type latencyRing struct {
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyRing(size int) latencyRing {
+	return latencyRing{samples: make([]time.Duration, size)}
+}
+
+func (l *latencyRing) add(d time.Duration) {
+	if len(l.samples) == 0 {
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// values returns the retained durations, oldest first.
+func (l *latencyRing) values() []time.Duration {
+	if !l.full {
+		return append([]time.Duration(nil), l.samples[:l.next]...)
+	}
+	return append(append([]time.Duration(nil), l.samples[l.next:]...), l.samples[:l.next]...)
+}
+
+type sparkline struct {
+	Width  int
+	Height int
+	Points string
+	Max    time.Duration
+}
+
+// newSparkline scales the durations to an SVG polyline, with the slowest sample at the top.
+func newSparkline(durations []time.Duration) sparkline {
+	s := sparkline{Width: sparklineWidth, Height: sparklineHeight}
+	for _, d := range durations {
+		if d > s.Max {
+			s.Max = d
+		}
+	}
+	if len(durations) < 2 {
+		return s
+	}
+	points := make([]string, 0, len(durations))
+	step := float64(sparklineWidth) / float64(len(durations)-1)
+	for i, d := range durations {
+		y := float64(sparklineHeight)
+		if s.Max > 0 {
+			// Leave a pixel at each edge so the stroke isn't clipped.
+			y = 1 + float64(sparklineHeight-2)*(1-float64(d)/float64(s.Max))
+		}
+		points = append(points, strconv.FormatFloat(float64(i)*step, 'f', 1, 64)+","+strconv.FormatFloat(y, 'f', 1, 64))
+	}
+	s.Points = strings.Join(points, " ")
+	return s
+}
+
+type dashboardCheck struct {
+	Name         string
+	State        string
+	LastRun      time.Time
+	LastError    string
+	LastErrorAt  time.Time
+	FailingSince time.Time
+	Successes    uint64
+	Failures     uint64
+	Sparkline    sparkline
+}
+
+type dashboardLabel struct {
+	Key   string
+	Value string
+}
+
+type dashboardInstance struct {
+	UUID       string
+	AppID      string
+	Hostname   string
+	PublicIPv4 string
+	Labels     []dashboardLabel
+	StartedAt  time.Time
+	Uptime     time.Duration
+}
+
+type dashboard struct {
+	Now          time.Time
+	Instance     *dashboardInstance
+	Stats        CheckStats
+	Queue        *storer.QueueStats
+	Spool        *storer.SpoolStats
+	Checks       []dashboardCheck
+	RecentErrors []check.CheckResults
+}
+
+// StatusHTMLHandler renders the status as a self-contained HTML page.
+func (c *checker) StatusHTMLHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	d := c.dashboard()
+	w.Header().Add("content-type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := dashboardTemplate.Execute(w, d); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("rendering status dashboard")
+	}
+}
+
+func (c *checker) dashboard() dashboard {
+	status := c.status()
+	d := dashboard{
+		Now:          c.now(),
+		Stats:        status.Stats,
+		Queue:        status.Queue,
+		Spool:        status.Spool,
+		RecentErrors: status.RecentErrors,
+	}
+	if c.instance != nil {
+		c.instance.Lock()
+		d.Instance = &dashboardInstance{
+			UUID:       c.instance.UUID,
+			AppID:      c.instance.AppID,
+			Hostname:   c.instance.Hostname,
+			PublicIPv4: c.instance.PublicIPv4,
+			StartedAt:  c.instance.StartedAt,
+		}
+		for _, k := range sortedKeys(c.instance.Labels) {
+			d.Instance.Labels = append(d.Instance.Labels, dashboardLabel{k, c.instance.Labels[k]})
+		}
+		c.instance.Unlock()
+		if !d.Instance.StartedAt.IsZero() {
+			d.Instance.Uptime = d.Now.Sub(d.Instance.StartedAt)
+		}
+	}
+
+	c.statLock.Lock()
+	defer c.statLock.Unlock()
+	for _, ch := range c.checks {
+		s := c.checkStates[ch.name]
+		dc := dashboardCheck{
+			Name:  ch.name,
+			State: checkStateName(s),
+		}
+		if s != nil {
+			dc.LastRun = s.lastRun
+			dc.LastError = s.lastError
+			dc.LastErrorAt = s.lastErrorAt
+			dc.FailingSince = s.failingSince
+			dc.Successes = s.successes
+			dc.Failures = s.failures
+			dc.Sparkline = newSparkline(s.latencies.values())
+		}
+		d.Checks = append(d.Checks, dc)
+	}
+	return d
+}
+
+// prefersHTML reports whether the request accepts HTML but not JSON, as browsers do.
+func prefersHTML(r *http.Request) bool {
+	var html, json bool
+	for _, accept := range r.Header.Values("accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			if q, ok := params["q"]; ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					continue
+				}
+			}
+			switch mediaType {
+			case "text/html":
+				html = true
+			case "application/json":
+				json = true
+			}
+		}
+	}
+	return html && !json
+}
+
+func formatDashboardTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatDashboardDuration rounds durations to a precision which is readable at a glance.
+func formatDashboardDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		hours := int(d.Hours())
+		return fmt.Sprintf("%dd%dh", hours/24, hours%24)
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	default:
+		return d.Round(time.Millisecond).String()
+	}
+}
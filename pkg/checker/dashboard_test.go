@@ -0,0 +1,73 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestStatusHTML(t *testing.T) {
+	now := time.Date(2022, 11, 2, 0, 0, 0, 0, time.UTC)
+	c := NewChecker(
+		WithInstance(&check.Instance{
+			UUID:      "a9f1b7c4-6ab2-4c43-b5a4-9d6f6c0a5b26",
+			AppID:     "app",
+			Hostname:  "host",
+			Labels:    map[string]string{"deployment-id": "d1"},
+			StartedAt: now.Add(-26 * time.Hour),
+		}),
+		WithCheck("ok", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, nil
+		}),
+		WithCheck("broken", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, errors.New("<nope>")
+		}),
+	)
+	c.now = func() time.Time { return now }
+	c.doChecks(context.Background(), c.checks)
+	c.doChecks(context.Background(), c.checks)
+
+	r := httptest.NewRequest("GET", "/status", nil)
+	r.Header.Set("accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	rec := httptest.NewRecorder()
+	c.StatusHandler(rec, r)
+	if ct := rec.Header().Get("content-type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("expected html content type, got %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<dd>app</dd>`,
+		`deployment-id=d1`,
+		`(up 1d2h)`,
+		`<span class="state passing">passing</span>`,
+		`<span class="state failing">failing</span>`,
+		`&lt;nope&gt;`,
+		`<polyline fill="none" points="`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard missing %q:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	c.StatusHandler(rec, httptest.NewRequest("GET", "/status", nil))
+	if ct := rec.Header().Get("content-type"); ct != "application/json" {
+		t.Errorf("expected json by default, got %q", ct)
+	}
+}
+
+func TestLatencyRing(t *testing.T) {
+	l := newLatencyRing(3)
+	for i := 1; i <= 4; i++ {
+		l.add(time.Duration(i))
+	}
+	got := l.values()
+	if len(got) != 3 || got[0] != 2 || got[2] != 4 {
+		t.Errorf("expected oldest to newest [2 3 4], got %v", got)
+	}
+}
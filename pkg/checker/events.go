@@ -0,0 +1,200 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	eventResult     = "result"
+	eventTransition = "transition"
+
+	// eventBufferSize is the number of events buffered per client. Events are dropped for clients
+	// which fall further behind.
+	eventBufferSize = 32
+
+	eventKeepAliveInterval = 15 * time.Second
+
+	checkStateUnknown = "unknown"
+	checkStatePassing = "passing"
+	checkStateFailing = "failing"
+)
+
+// Transition describes a check changing between the passing and failing states.
+type Transition struct {
+	Check string
+	From  string
+	To    string
+	Error string `json:",omitempty"`
+	TS    time.Time
+}
+
+type event struct {
+	kind   string
+	checks []string
+	data   interface{}
+}
+
+type subscriber struct {
+	events chan event
+	checks map[string]bool
+	names  []string
+}
+
+// eventBroker fans out events to subscribed clients.
+type eventBroker struct {
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: make(map[*subscriber]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (b *eventBroker) subscribe(checks []string) *subscriber {
+	s := &subscriber{events: make(chan event, eventBufferSize), names: checks}
+	if len(checks) > 0 {
+		s.checks = make(map[string]bool, len(checks))
+		for _, c := range checks {
+			s.checks[c] = true
+		}
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+func (b *eventBroker) unsubscribe(s *subscriber) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.subscribers, s)
+}
+
+// publish delivers the event to interested subscribers without blocking.
+func (b *eventBroker) publish(e event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for s := range b.subscribers {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+		}
+	}
+}
+
+func (b *eventBroker) close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+func (s *subscriber) wants(e event) bool {
+	if s.checks == nil {
+		return true
+	}
+	for _, c := range e.checks {
+		if s.checks[c] {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseEvents disconnects all event stream clients. It should be registered with
+// http.Server.RegisterOnShutdown so long-lived streams don't block shutdown.
+func (c *checker) CloseEvents() {
+	c.events.close()
+}
+
+// EventsHandler streams check results and state transitions as Server-Sent Events. The stream may
+// be limited to specific checks with one or more `check` query parameters.
+func (c *checker) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	names := r.URL.Query()["check"]
+	if len(names) > 0 {
+		if _, err := c.selectChecks(names); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub := c.events.subscribe(names)
+	defer c.events.unsubscribe(sub)
+
+	w.Header().Add("content-type", "text/event-stream")
+	w.Header().Add("cache-control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.events.done:
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case e := <-sub.events:
+			data := e.data
+			if r, ok := data.(check.CheckResults); ok {
+				data = r.FilterChecks(sub.names)
+			}
+			b, err := json.Marshal(data)
+			if err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msg("encoding event")
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.kind, b); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// publishCycle emits the results of a cycle and any state transitions.
+func (c *checker) publishCycle(r check.CheckResults, outcomes []checkOutcome, transitions []Transition) {
+	names := make([]string, 0, len(outcomes))
+	for _, o := range outcomes {
+		names = append(names, o.name)
+	}
+	c.events.publish(event{kind: eventResult, checks: names, data: r})
+	for _, t := range transitions {
+		c.events.publish(event{kind: eventTransition, checks: []string{t.Check}, data: t})
+	}
+}
+
+func checkStateName(s *checkState) string {
+	switch {
+	case s == nil || s.lastRun.IsZero():
+		return checkStateUnknown
+	case s.failingSince.IsZero():
+		return checkStatePassing
+	default:
+		return checkStateFailing
+	}
+}
@@ -0,0 +1,90 @@
+package checker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestEventsHandler(t *testing.T) {
+	c := NewChecker(
+		// Measurements of a check whose name extends the selected one's mustn't be included.
+		WithCheck("broken_dns", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, nil
+		}),
+		WithCheck("broken", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, errors.New("nope")
+		}),
+	)
+	srv := httptest.NewServer(http.HandlerFunc(c.EventsHandler))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?check=broken", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("content-type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content-type %q", ct)
+	}
+
+	// Wait for the subscription to register before running checks.
+	waitForSubscribers(t, c, 1)
+	c.doChecks(ctx, c.checks)
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && len(lines) < 4 {
+		if l := scanner.Text(); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %q", lines)
+	}
+	if lines[0] != "event: result" || !strings.Contains(lines[1], `"Errors":[{"Check":"broken","Error":"nope"}]`) {
+		t.Errorf("unexpected result event: %q", lines[:2])
+	}
+	if strings.Contains(lines[1], `"broken_dns_duration"`) {
+		t.Errorf("expected results for unselected checks to be filtered: %q", lines[1])
+	}
+	if lines[2] != "event: transition" || !strings.Contains(lines[3], `"From":"unknown","To":"failing"`) {
+		t.Errorf("unexpected transition event: %q", lines[2:])
+	}
+
+	cancel()
+	resp.Body.Close()
+	waitForSubscribers(t, c, 0)
+}
+
+// waitForSubscribers waits for the checker to have n event subscribers.
+func waitForSubscribers(t *testing.T, c *checker, n int) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		c.events.lock.Lock()
+		got := len(c.events.subscribers)
+		c.events.lock.Unlock()
+		if got == n {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("expected %d subscribers, got %d", n, got)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
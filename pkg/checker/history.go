@@ -0,0 +1,100 @@
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	historyScopeInstance = "instance"
+	historyScopeApp      = "app"
+)
+
+// HistoryHandler returns stored check results for this instance, newest first. The following
+// query parameters are supported:
+//   - scope: instance (default) or app to include all instances of this app.
+//   - start, end: RFC3339 timestamps bounding the results.
+//   - check: limit results to the named check; may be repeated.
+//   - limit: the page size.
+//   - cursor: the NextCursor of a previous page.
+func (c *checker) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	filter, page, err := c.parseHistoryRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out, err := c.storer.QueryResults(ctx, filter, page)
+	if errors.Is(err, storer.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, storer.ErrUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Ctx(ctx).Err(err).Msg("querying history")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	j := json.NewEncoder(w)
+	j.SetIndent("", "  ")
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := j.Encode(out); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("encoding history")
+	}
+}
+
+func (c *checker) parseHistoryRequest(r *http.Request) (storer.ResultsFilter, storer.Page, error) {
+	q := r.URL.Query()
+	var filter storer.ResultsFilter
+	var page storer.Page
+	if c.instance == nil {
+		return filter, page, errors.New("instance unknown")
+	}
+
+	c.instance.Lock()
+	uuid, appID := c.instance.UUID, c.instance.AppID
+	c.instance.Unlock()
+	switch q.Get("scope") {
+	case "", historyScopeInstance:
+		filter.InstanceUUIDs = []string{uuid}
+	case historyScopeApp:
+		if appID == "" {
+			return filter, page, errors.New("app scope requires an app id")
+		}
+		filter.AppIDs = []string{appID}
+	default:
+		return filter, page, fmt.Errorf("unsupported scope %q", q.Get("scope"))
+	}
+
+	var err error
+	if s := q.Get("start"); s != "" {
+		if filter.Start, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			return filter, page, fmt.Errorf("parsing start: %w", err)
+		}
+	}
+	if e := q.Get("end"); e != "" {
+		if filter.End, err = time.Parse(time.RFC3339Nano, e); err != nil {
+			return filter, page, fmt.Errorf("parsing end: %w", err)
+		}
+	}
+	filter.Checks = q["check"]
+	if l := q.Get("limit"); l != "" {
+		if page.Limit, err = strconv.Atoi(l); err != nil {
+			return filter, page, fmt.Errorf("parsing limit: %w", err)
+		}
+	}
+	page.Cursor = q.Get("cursor")
+	return filter, page, nil
+}
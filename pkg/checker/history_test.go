@@ -0,0 +1,55 @@
+package checker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	gomock "github.com/golang/mock/gomock"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := storer.NewMockStorer(ctrl)
+	c := NewChecker(
+		WithStorer(m),
+		WithInstance(&check.Instance{UUID: "instance-uuid", AppID: "app"}),
+	)
+
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	m.EXPECT().QueryResults(gomock.Any(), storer.ResultsFilter{
+		AppIDs: []string{"app"},
+		Checks: []string{"database"},
+		Start:  start,
+	}, storer.Page{Limit: 5, Cursor: "abc"}).Return(storer.ResultsPage{NextCursor: "def"}, nil)
+	rec := httptest.NewRecorder()
+	c.HistoryHandler(rec, httptest.NewRequest("GET", "/history?scope=app&check=database&start=2022-11-01T00:00:00Z&limit=5&cursor=abc", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if want := "{\n  \"Results\": null,\n  \"NextCursor\": \"def\"\n}\n"; rec.Body.String() != want {
+		t.Errorf("expected body %q, got %q", want, rec.Body.String())
+	}
+
+	m.EXPECT().QueryResults(gomock.Any(), storer.ResultsFilter{
+		InstanceUUIDs: []string{"instance-uuid"},
+	}, storer.Page{}).Return(storer.ResultsPage{}, storer.ErrUnsupported)
+	rec = httptest.NewRecorder()
+	c.HistoryHandler(rec, httptest.NewRequest("GET", "/history", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("expected status %d, got %d", http.StatusNotImplemented, rec.Code)
+	}
+
+	m.EXPECT().QueryResults(gomock.Any(), gomock.Any(), storer.Page{Cursor: "!"}).
+		Return(storer.ResultsPage{}, fmt.Errorf("querying: %w", storer.ErrInvalidCursor))
+	rec = httptest.NewRecorder()
+	c.HistoryHandler(rec, httptest.NewRequest("GET", "/history?cursor=!", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
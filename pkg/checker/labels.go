@@ -0,0 +1,118 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxLabelLength is the size of the instance_labels columns.
+	maxLabelLength = 64
+	maxLabelsBody  = 64 << 10
+)
+
+// LabelsHandler reads and changes this instance's labels at runtime. GET returns the labels as a
+// JSON object. PUT replaces them with the object in the body, and PATCH merges the object into
+// them, removing keys whose value is null. Changes are saved to the storer immediately; if that
+// fails the save is retried in the background and the response status is 202 Accepted.
+func (c *checker) LabelsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if c.instance == nil {
+		http.Error(w, "instance unknown", http.StatusNotFound)
+		return
+	}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		var changes map[string]*string
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxLabelsBody)).Decode(&changes); err != nil {
+			http.Error(w, fmt.Sprintf("parsing labels: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := validateLabels(changes, r.Method == http.MethodPatch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.instance.Lock()
+		labels := make(map[string]string, len(changes))
+		if r.Method == http.MethodPatch {
+			for k, v := range c.instance.Labels {
+				labels[k] = v
+			}
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(labels, k)
+			} else {
+				labels[k] = *v
+			}
+		}
+		// Replace rather than modify the map, so copies taken by readers stay consistent.
+		c.instance.Labels = labels
+		c.instance.Unlock()
+		log.Ctx(ctx).Info().Interface("labels", labels).Msg("instance labels changed")
+
+		if err := c.storer.UpdateInstance(ctx, c.instance); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("saving instance labels, will retry")
+			c.storer.AsyncQueryRetry(
+				// The request ctx ends with the response.
+				log.Ctx(ctx).WithContext(context.Background()),
+				storer.SaveBackOffSchedule,
+				func(ctx context.Context, attempt int) error {
+					return c.storer.UpdateInstance(ctx, c.instance)
+				})
+			status = http.StatusAccepted
+		}
+	default:
+		w.Header().Set("allow", "GET, PUT, PATCH")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.instance.Lock()
+	labels := c.instance.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	b, err := json.MarshalIndent(labels, "", "  ")
+	c.instance.Unlock()
+	if err != nil {
+		log.Ctx(ctx).Err(err).Msg("encoding labels")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
+
+// validateLabels checks labels fit the storer's columns. Null values are only allowed when
+// patching.
+func validateLabels(labels map[string]*string, patch bool) error {
+	for k, v := range labels {
+		if k == "" {
+			return errors.New("label keys must not be empty")
+		}
+		if len(k) > maxLabelLength {
+			return fmt.Errorf("label key %q exceeds %d bytes", k, maxLabelLength)
+		}
+		if v == nil {
+			if !patch {
+				return fmt.Errorf("label %q is null; use PATCH to remove labels", k)
+			}
+			continue
+		}
+		if len(*v) > maxLabelLength {
+			return fmt.Errorf("label %q value exceeds %d bytes", k, maxLabelLength)
+		}
+	}
+	return nil
+}
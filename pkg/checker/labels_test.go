@@ -0,0 +1,113 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	gomock "github.com/golang/mock/gomock"
+)
+
+func TestLabelsHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := storer.NewMockStorer(ctrl)
+	instance := &check.Instance{UUID: "instance-uuid", Labels: map[string]string{"deployment": "a", "team": "x"}}
+	c := NewChecker(WithStorer(m), WithInstance(instance))
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		body       string
+		saveErr    error
+		wantStatus int
+		wantLabels map[string]string
+	}{
+		{
+			name:       "patch",
+			method:     http.MethodPatch,
+			body:       `{"deployment": "b", "team": null}`,
+			wantStatus: http.StatusOK,
+			wantLabels: map[string]string{"deployment": "b"},
+		},
+		{
+			name:       "put with failed save",
+			method:     http.MethodPut,
+			body:       `{"region": "nyc"}`,
+			saveErr:    errors.New("database unavailable"),
+			wantStatus: http.StatusAccepted,
+			wantLabels: map[string]string{"region": "nyc"},
+		},
+		{
+			name:       "put null",
+			method:     http.MethodPut,
+			body:       `{"region": null}`,
+			wantStatus: http.StatusBadRequest,
+			wantLabels: map[string]string{"region": "nyc"},
+		},
+		{
+			name:       "get",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantLabels: map[string]string{"region": "nyc"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.method != http.MethodGet && tc.wantStatus != http.StatusBadRequest {
+				m.EXPECT().UpdateInstance(gomock.Any(), instance).Return(tc.saveErr)
+			}
+			if tc.saveErr != nil {
+				m.EXPECT().AsyncQueryRetry(gomock.Any(), storer.SaveBackOffSchedule, gomock.Any())
+			}
+			rec := httptest.NewRecorder()
+			c.LabelsHandler(rec, httptest.NewRequest(tc.method, "/labels", strings.NewReader(tc.body)))
+			if rec.Code != tc.wantStatus {
+				t.Errorf("expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if len(instance.Labels) != len(tc.wantLabels) {
+				t.Fatalf("expected labels %v, got %v", tc.wantLabels, instance.Labels)
+			}
+			for k, v := range tc.wantLabels {
+				if instance.Labels[k] != v {
+					t.Errorf("expected labels %v, got %v", tc.wantLabels, instance.Labels)
+				}
+			}
+		})
+	}
+}
+
+func TestLabelsChangeWhileResultsAreRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := storer.NewMockStorer(ctrl)
+	instance := &check.Instance{UUID: "instance-uuid", Labels: map[string]string{"deployment": "a"}}
+	c := NewChecker(WithStorer(m), WithInstance(instance))
+	m.EXPECT().UpdateInstance(gomock.Any(), instance).Return(nil).AnyTimes()
+
+	r, _ := c.doChecks(context.Background(), nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Storers encode results without the instance lock.
+		for i := 0; i < 100; i++ {
+			if _, err := json.Marshal(r); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		c.LabelsHandler(rec, httptest.NewRequest(http.MethodPatch, "/labels", strings.NewReader(`{"deployment": "b"}`)))
+	}
+	<-done
+	if r.Instance == instance || r.Instance.Labels["deployment"] != "a" {
+		t.Errorf("expected the result to keep a copy of the labels, got %v", r.Instance.Labels)
+	}
+}
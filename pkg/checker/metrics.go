@@ -0,0 +1,251 @@
+package checker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/apps-self-check/pkg/otlp"
+	"github.com/rs/zerolog/log"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// defaultDurationBuckets mirror the default buckets used by the prometheus client libraries.
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// histogram is a minimal cumulative histogram compatible with the prometheus exposition format.
+type histogram struct {
+	upperBounds []float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+func newHistogram(upperBounds []float64) histogram {
+	return histogram{
+		upperBounds: upperBounds,
+		counts:      make([]uint64, len(upperBounds)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.upperBounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// MetricsHandler exposes check results and checker stats in the prometheus text format.
+func (c *checker) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Add("content-type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	pw := newPromWriter(w)
+	c.writeMetrics(pw)
+	if err := pw.Flush(); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("writing metrics")
+	}
+}
+
+func (c *checker) writeMetrics(pw *promWriter) {
+	var instanceLabels, infoLabels []promLabel
+	if c.instance != nil {
+		c.instance.Lock()
+		instanceLabels = labelsFromMap(c.instance.Labels)
+		infoLabels = append([]promLabel{
+			{"uuid", c.instance.UUID},
+			{"app_id", c.instance.AppID},
+			{"hostname", c.instance.Hostname},
+			{"public_ipv4", c.instance.PublicIPv4},
+		}, instanceLabels...)
+		c.instance.Unlock()
+	}
+
+	c.statLock.Lock()
+	defer c.statLock.Unlock()
+
+	if infoLabels != nil {
+		pw.header("self_check_instance_info", "gauge", "Static information about this instance.")
+		pw.sample("self_check_instance_info", infoLabels, 1)
+	}
+
+	pw.header("self_check_cycles_total", "counter", "Check cycles completed by this instance.")
+	pw.sample("self_check_cycles_total", nil, float64(c.stats.Cycles))
+	pw.header("self_check_cycles_with_errors_total", "counter", "Check cycles which included at least one error.")
+	pw.sample("self_check_cycles_with_errors_total", nil, float64(c.stats.CyclesWithErrors))
+
+	if len(c.measurements) > 0 {
+		pw.header("self_check_measurement", "gauge", "Latest value reported for each check measurement.")
+		for _, name := range sortedKeys(c.measurements) {
+			labels := append([]promLabel{{"check", name}}, instanceLabels...)
+			pw.sample("self_check_measurement", labels, c.measurements[name])
+		}
+	}
+
+	if len(c.checkStates) == 0 {
+		return
+	}
+	names := sortedKeys(c.checkStates)
+	pw.header("self_check_success_total", "counter", "Successful executions of each check.")
+	for _, name := range names {
+		pw.sample("self_check_success_total", []promLabel{{"check", name}}, float64(c.checkStates[name].successes))
+	}
+	pw.header("self_check_failure_total", "counter", "Failed executions of each check.")
+	for _, name := range names {
+		pw.sample("self_check_failure_total", []promLabel{{"check", name}}, float64(c.checkStates[name].failures))
+	}
+	pw.header("self_check_duration_seconds", "histogram", "Duration of each check execution.")
+	for _, name := range names {
+		pw.histogram("self_check_duration_seconds", []promLabel{{"check", name}}, &c.checkStates[name].durations)
+	}
+}
+
+// otlpMetrics builds the OTLP equivalents of the prometheus metrics. The caller must hold the
+// statLock.
+func (c *checker) otlpMetrics() []otlp.Metric {
+	metrics := []otlp.Metric{
+		{
+			Name:        "self_check.cycles",
+			Description: "Check cycles completed by this instance.",
+			Kind:        otlp.Counter,
+			Points:      []otlp.Point{{Value: float64(c.stats.Cycles)}},
+		},
+		{
+			Name:        "self_check.cycles_with_errors",
+			Description: "Check cycles which included at least one error.",
+			Kind:        otlp.Counter,
+			Points:      []otlp.Point{{Value: float64(c.stats.CyclesWithErrors)}},
+		},
+	}
+	measurements := otlp.Metric{
+		Name:        "self_check.measurement",
+		Description: "Latest value reported for each check measurement.",
+		Kind:        otlp.Gauge,
+	}
+	for _, name := range sortedKeys(c.measurements) {
+		measurements.Points = append(measurements.Points, otlp.Point{
+			Attributes: map[string]string{"check": name},
+			Value:      c.measurements[name],
+		})
+	}
+	successes := otlp.Metric{
+		Name:        "self_check.check.successes",
+		Description: "Successful executions of each check.",
+		Kind:        otlp.Counter,
+	}
+	failures := otlp.Metric{
+		Name:        "self_check.check.failures",
+		Description: "Failed executions of each check.",
+		Kind:        otlp.Counter,
+	}
+	for _, name := range sortedKeys(c.checkStates) {
+		attrs := map[string]string{"check": name}
+		successes.Points = append(successes.Points, otlp.Point{Attributes: attrs, Value: float64(c.checkStates[name].successes)})
+		failures.Points = append(failures.Points, otlp.Point{Attributes: attrs, Value: float64(c.checkStates[name].failures)})
+	}
+	return append(metrics, measurements, successes, failures)
+}
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+// labelsFromMap converts instance labels to prometheus labels. Keys are prefixed to avoid collisions
+// with the labels we set directly. Duplicate names would get the whole scrape rejected, so if
+// several keys sanitize to the same name only the first (in key order) is kept.
+func labelsFromMap(m map[string]string) []promLabel {
+	out := make([]promLabel, 0, len(m))
+	seen := make(map[string]bool, len(m))
+	for _, k := range sortedKeys(m) {
+		name := "label_" + sanitizeLabelName(k)
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, promLabel{name: name, value: m[k]})
+		}
+	}
+	return out
+}
+
+func sanitizeLabelName(name string) string {
+	return invalidLabelChars.ReplaceAllString(name, "_")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// promWriter writes metrics in the prometheus text exposition format.
+type promWriter struct {
+	*bufio.Writer
+}
+
+func newPromWriter(w io.Writer) *promWriter {
+	return &promWriter{Writer: bufio.NewWriter(w)}
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	fmt.Fprintf(p, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) sample(name string, labels []promLabel, value float64) {
+	p.WriteString(name)
+	if len(labels) > 0 {
+		p.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				p.WriteByte(',')
+			}
+			p.WriteString(l.name)
+			p.WriteString(`="`)
+			p.WriteString(escapeLabelValue(l.value))
+			p.WriteByte('"')
+		}
+		p.WriteByte('}')
+	}
+	p.WriteByte(' ')
+	p.WriteString(formatFloat(value))
+	p.WriteByte('\n')
+}
+
+func (p *promWriter) histogram(name string, labels []promLabel, h *histogram) {
+	for i, b := range h.upperBounds {
+		p.sample(name+"_bucket", append(labels[:len(labels):len(labels)], promLabel{"le", formatFloat(b)}), float64(h.counts[i]))
+	}
+	p.sample(name+"_bucket", append(labels[:len(labels):len(labels)], promLabel{"le", "+Inf"}), float64(h.count))
+	p.sample(name+"_sum", labels, h.sum)
+	p.sample(name+"_count", labels, float64(h.count))
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
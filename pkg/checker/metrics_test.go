@@ -0,0 +1,59 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	c := NewChecker(
+		WithInstance(&check.Instance{
+			UUID:     "a9f1b7c4-6ab2-4c43-b5a4-9d6f6c0a5b26",
+			AppID:    "app",
+			Hostname: "host",
+			Labels:   map[string]string{"deployment-id": "d1"},
+		}),
+		WithCheck("ok", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return []check.CheckMeasurement{{Check: "ok_rtt", Value: 0.5}}, nil
+		}),
+		WithCheck("broken", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, errors.New("nope")
+		}),
+	)
+	c.doChecks(context.Background(), c.checks)
+
+	rec := httptest.NewRecorder()
+	c.MetricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`self_check_instance_info{uuid="a9f1b7c4-6ab2-4c43-b5a4-9d6f6c0a5b26",app_id="app",hostname="host",public_ipv4="",label_deployment_id="d1"} 1`,
+		`self_check_cycles_total 1`,
+		`self_check_cycles_with_errors_total 1`,
+		`self_check_measurement{check="ok_rtt",label_deployment_id="d1"} 0.5`,
+		`self_check_success_total{check="ok"} 1`,
+		`self_check_failure_total{check="broken"} 1`,
+		`self_check_duration_seconds_bucket{check="ok",le="+Inf"} 1`,
+		`self_check_duration_seconds_count{check="broken"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics output missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestLabelsFromMapDuplicateNames(t *testing.T) {
+	labels := labelsFromMap(map[string]string{"team-name": "a", "team.name": "b", "team_name": "c", "region": "nyc"})
+	var got []string
+	for _, l := range labels {
+		got = append(got, l.name+"="+l.value)
+	}
+	// Keys which sanitize to the same name keep the first in key order.
+	if want := "label_region=nyc,label_team_name=a"; strings.Join(got, ",") != want {
+		t.Errorf("expected %s, got %s", want, strings.Join(got, ","))
+	}
+}
@@ -0,0 +1,138 @@
+package checker
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WithRequiredChecks limits the checks which determine readiness. By default all checks are
+// required.
+func WithRequiredChecks(names ...string) CheckerOption {
+	return func(c *checker) {
+		c.requiredChecks = names
+	}
+}
+
+// WithStartupGate controls whether the instance is considered unready until the first full check
+// cycle passes all required checks.
+func WithStartupGate(enabled bool) CheckerOption {
+	return func(c *checker) {
+		c.startupGate = enabled
+	}
+}
+
+// WithGracePeriod allows a required check to fail for up to the specified duration before the
+// instance is considered unready.
+func WithGracePeriod(grace time.Duration) CheckerOption {
+	return func(c *checker) {
+		c.gracePeriod = grace
+	}
+}
+
+type readyCheck struct {
+	Ready        bool
+	LastRun      time.Time `json:",omitempty"`
+	LastError    string    `json:",omitempty"`
+	FailingSince time.Time `json:",omitempty"`
+}
+
+type readyStatus struct {
+	Ready  bool
+	Reason string `json:",omitempty"`
+	Checks map[string]readyCheck
+}
+
+// ReadyHandler reports whether the required checks are passing. It returns a 503 status if the
+// instance should be removed from rotation.
+func (c *checker) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	out := c.readiness()
+	status := http.StatusOK
+	if !out.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	j := json.NewEncoder(w)
+	j.SetIndent("", "  ")
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(status)
+	if err := j.Encode(out); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("encoding readiness")
+	}
+}
+
+func (c *checker) readiness() readyStatus {
+	now := c.now()
+	c.statLock.Lock()
+	defer c.statLock.Unlock()
+
+	out := readyStatus{
+		Ready:  true,
+		Checks: make(map[string]readyCheck),
+	}
+	if c.startupGate && !c.passedFullCycle {
+		out.Ready = false
+		out.Reason = "waiting for the first full check cycle to pass"
+	}
+	for _, name := range c.requiredCheckNames() {
+		s, ok := c.checkStates[name]
+		if !ok {
+			// Without the startup gate, checks which haven't run yet don't block readiness.
+			out.Checks[name] = readyCheck{Ready: !c.startupGate}
+			continue
+		}
+		rc := readyCheck{
+			Ready:        s.failingSince.IsZero() || now.Sub(s.failingSince) < c.gracePeriod,
+			LastRun:      s.lastRun,
+			FailingSince: s.failingSince,
+		}
+		if !s.failingSince.IsZero() {
+			rc.LastError = s.lastError
+		}
+		if !rc.Ready {
+			out.Ready = false
+			if out.Reason == "" {
+				out.Reason = "required check " + name + " is failing"
+			}
+		}
+		out.Checks[name] = rc
+	}
+	return out
+}
+
+// ValidateRequiredChecks returns an error if any of the required checks isn't registered, as it
+// would never let the instance become ready.
+func (c *checker) ValidateRequiredChecks() error {
+	if len(c.requiredChecks) == 0 {
+		return nil
+	}
+	_, err := c.selectChecks(c.requiredChecks)
+	return err
+}
+
+func (c *checker) requiredCheckNames() []string {
+	if len(c.requiredChecks) > 0 {
+		return c.requiredChecks
+	}
+	names := make([]string, 0, len(c.checks))
+	for _, ch := range c.checks {
+		names = append(names, ch.name)
+	}
+	return names
+}
+
+// requiredChecksPassed reports whether every required check succeeded in the outcomes.
+func (c *checker) requiredChecksPassed(outcomes []checkOutcome) bool {
+	passed := make(map[string]bool, len(outcomes))
+	for _, o := range outcomes {
+		passed[o.name] = o.err == nil
+	}
+	for _, name := range c.requiredCheckNames() {
+		if !passed[name] {
+			return false
+		}
+	}
+	return true
+}
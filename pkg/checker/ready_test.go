@@ -0,0 +1,95 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestReadiness(t *testing.T) {
+	var dbErr error
+	now := time.Now()
+	c := NewChecker(
+		WithStartupGate(true),
+		WithRequiredChecks("database"),
+		WithGracePeriod(time.Minute),
+		WithCheck("database", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, dbErr
+		}),
+		WithCheck("optional", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			return nil, errors.New("always broken")
+		}),
+	)
+	c.now = func() time.Time { return now }
+
+	if c.readiness().Ready {
+		t.Fatalf("expected unready before the first cycle")
+	}
+
+	dbErr = errors.New("unreachable")
+	c.doChecks(context.Background(), c.checks)
+	if c.readiness().Ready {
+		t.Fatalf("expected unready when the first cycle fails")
+	}
+
+	dbErr = nil
+	c.doChecks(context.Background(), c.checks)
+	if r := c.readiness(); !r.Ready {
+		t.Fatalf("expected ready after passing cycle: %s", r.Reason)
+	}
+
+	dbErr = errors.New("unreachable")
+	c.doChecks(context.Background(), c.checks)
+	if r := c.readiness(); !r.Ready {
+		t.Fatalf("expected ready within grace period: %s", r.Reason)
+	}
+
+	now = now.Add(2 * time.Minute)
+	c.doChecks(context.Background(), c.checks)
+	if c.readiness().Ready {
+		t.Fatalf("expected unready after grace period")
+	}
+}
+
+func TestValidateRequiredChecks(t *testing.T) {
+	ok := func(ctx context.Context) ([]check.CheckMeasurement, error) { return nil, nil }
+	if err := NewChecker(WithRequiredChecks("database"), WithCheck("database", ok)).ValidateRequiredChecks(); err != nil {
+		t.Errorf("expected a registered check to be valid, got %v", err)
+	}
+	err := NewChecker(WithRequiredChecks("databse"), WithCheck("database", ok)).ValidateRequiredChecks()
+	if err == nil || err.Error() != "unknown checks: [databse]" {
+		t.Errorf("expected an unknown check error, got %v", err)
+	}
+}
+
+func TestReadinessCheckTimeout(t *testing.T) {
+	c := NewChecker(
+		WithCheck("database", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+			// Hangs like a connection to a blackholed host.
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+	)
+
+	// A cycle canceled by shutdown isn't the check's fault.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.doChecks(ctx, c.checks)
+	if r := c.readiness(); !r.Ready {
+		t.Fatalf("expected ready after a canceled cycle: %s", r.Reason)
+	}
+
+	// A check still running at the cycle's deadline fails.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r, _ := c.doChecks(ctx, c.checks)
+	if len(r.Errors) != 1 || r.Errors[0].Error != "timed out: context deadline exceeded" {
+		t.Errorf("expected a timed out error, got %+v", r.Errors)
+	}
+	if r := c.readiness(); r.Ready || r.Checks["database"].LastError == "" {
+		t.Errorf("expected unready after the check timed out, got %+v", r)
+	}
+}
@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultRestoreWindow = 24 * time.Hour
+
+// WithRestoreWindow limits how far back Restore looks for results of previous instances. A value of
+// 0 disables restoring.
+func WithRestoreWindow(window time.Duration) CheckerOption {
+	return func(c *checker) {
+		c.restoreWindow = window
+	}
+}
+
+// Restore seeds the recent errors and stats from results saved by previous instances of the same
+// app, so that status survives redeploys. Restored errors are marked as coming from a predecessor,
+// and their stats are reported separately from this instance's. It is a no-op if the app is unknown
+// or the storer can't read back results.
+func (c *checker) Restore(ctx context.Context) error {
+	if c.storer == nil || c.instance == nil || c.restoreWindow <= 0 {
+		return nil
+	}
+	c.instance.Lock()
+	uuid, appID := c.instance.UUID, c.instance.AppID
+	c.instance.Unlock()
+	if appID == "" {
+		return nil
+	}
+
+	filter := storer.ResultsFilter{
+		AppIDs:               []string{appID},
+		ExcludeInstanceUUIDs: []string{uuid},
+		Start:                c.now().Add(-c.restoreWindow),
+	}
+	stats, err := c.storer.QueryResultStats(ctx, filter)
+	if errors.Is(err, storer.ErrUnsupported) {
+		log.Ctx(ctx).Debug().Msg("storer does not support restoring status")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("restoring stats: %w", err)
+	}
+	if stats.Cycles == 0 {
+		return nil
+	}
+
+	var page storer.ResultsPage
+	if c.recentErrorsLimit != 0 && stats.CyclesWithErrors > 0 {
+		filter.ErrorsOnly = true
+		p := storer.Page{}
+		if c.recentErrorsLimit > 0 {
+			p.Limit = c.recentErrorsLimit
+		}
+		if page, err = c.storer.QueryResults(ctx, filter, p); err != nil {
+			return fmt.Errorf("restoring recent errors: %w", err)
+		}
+	}
+
+	c.statLock.Lock()
+	defer c.statLock.Unlock()
+	c.stats.Predecessors = &CheckStats{
+		Cycles:           stats.Cycles,
+		CyclesWithErrors: stats.CyclesWithErrors,
+		LastCheck:        stats.LastCheck,
+	}
+	// Results are newest first, and any errors recorded by this instance since startup are newer.
+	for _, r := range page.Results {
+		if c.recentErrorsLimit > 0 && c.recentErrors.Len() >= c.recentErrorsLimit {
+			break
+		}
+		r.Predecessor = true
+		c.recentErrors.PushBack(r)
+	}
+	log.Ctx(ctx).Info().
+		Int("cycles", stats.Cycles).
+		Int("recent_errors", len(page.Results)).
+		Msg("restored status from previous instances")
+	return nil
+}
@@ -0,0 +1,79 @@
+package checker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/storer"
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	gomock "github.com/golang/mock/gomock"
+)
+
+func TestRestore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := storer.NewMockStorer(ctrl)
+	now := time.Date(2022, 11, 2, 0, 0, 0, 0, time.UTC)
+	c := NewChecker(
+		WithStorer(m),
+		WithInstance(&check.Instance{UUID: "instance-uuid", AppID: "app"}),
+		WithRecentErrorsLimit(2),
+	)
+	c.now = func() time.Time { return now }
+
+	filter := storer.ResultsFilter{
+		AppIDs:               []string{"app"},
+		ExcludeInstanceUUIDs: []string{"instance-uuid"},
+		Start:                now.Add(-defaultRestoreWindow),
+	}
+	lastCheck := now.Add(-time.Minute)
+	m.EXPECT().QueryResultStats(gomock.Any(), filter).Return(storer.ResultStats{
+		Cycles:           10,
+		CyclesWithErrors: 3,
+		LastCheck:        lastCheck,
+	}, nil)
+	errorsFilter := filter
+	errorsFilter.ErrorsOnly = true
+	m.EXPECT().QueryResults(gomock.Any(), errorsFilter, storer.Page{Limit: 2}).Return(storer.ResultsPage{
+		Results: []check.CheckResults{
+			{TS: lastCheck, Errors: []check.CheckError{{Check: "database", Error: "newest"}}},
+			{TS: lastCheck.Add(-time.Minute), Errors: []check.CheckError{{Check: "database", Error: "older"}}},
+		},
+		NextCursor: "more",
+	}, nil)
+
+	if err := c.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := (CheckStats{Cycles: 10, CyclesWithErrors: 3, LastCheck: lastCheck}); c.stats.Predecessors == nil || *c.stats.Predecessors != want {
+		t.Errorf("expected predecessor stats %+v, got %+v", want, c.stats.Predecessors)
+	}
+	if c.stats.Cycles != 0 {
+		t.Errorf("expected restored cycles to be kept separate, got %d", c.stats.Cycles)
+	}
+	if c.recentErrors.Len() != 2 {
+		t.Fatalf("expected 2 recent errors, got %d", c.recentErrors.Len())
+	}
+	front := c.recentErrors.Front().Value.(check.CheckResults)
+	if !front.Predecessor || front.Errors[0].Error != "newest" {
+		t.Errorf("expected newest predecessor error first, got %+v", front)
+	}
+}
+
+func TestRestoreUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := storer.NewMockStorer(ctrl)
+	c := NewChecker(
+		WithStorer(m),
+		WithInstance(&check.Instance{UUID: "instance-uuid", AppID: "app"}),
+	)
+	m.EXPECT().QueryResultStats(gomock.Any(), gomock.Any()).Return(storer.ResultStats{}, storer.ErrUnsupported)
+	if err := c.Restore(context.Background()); err != nil {
+		t.Errorf("expected unsupported storers to be skipped, got %v", err)
+	}
+	if c.stats.Predecessors != nil {
+		t.Errorf("expected no predecessor stats, got %+v", c.stats.Predecessors)
+	}
+}
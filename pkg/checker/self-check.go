@@ -3,23 +3,26 @@ package checker
 import (
 	"container/list"
 	"context"
+	"crypto/tls"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"reflect"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/digitalocean/apps-self-check/pkg/otlp"
 	"github.com/digitalocean/apps-self-check/pkg/storer"
 	"github.com/digitalocean/apps-self-check/pkg/types/check"
 	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
 	"github.com/iancoleman/strcase"
 	"github.com/rs/zerolog/log"
 	"github.com/xo/dburl"
@@ -33,9 +36,14 @@ const (
 // NewChecker creates a checker.
 func NewChecker(opts ...CheckerOption) *checker {
 	c := &checker{
-		now:          time.Now,
-		timeout:      defaultTimeout,
-		recentErrors: list.New(),
+		now:               time.Now,
+		timeout:           defaultTimeout,
+		recentErrors:      list.New(),
+		recentErrorsLimit: defaultRecentErrorsLimit,
+		checkStates:       make(map[string]*checkState),
+		measurements:      make(map[string]float64),
+		events:            newEventBroker(),
+		restoreWindow:     defaultRestoreWindow,
 	}
 	for _, o := range opts {
 		o(c)
@@ -73,21 +81,28 @@ func WithStorer(s storer.Storer) CheckerOption {
 	}
 }
 
-// WithTimeout sets a timeout for all tests.
+// WithTimeout sets a timeout for all tests. It also caps the timeout of on-demand checks.
 func WithTimeout(timeout time.Duration) CheckerOption {
 	return func(c *checker) {
 		c.timeout = timeout
 	}
 }
 
-// WithRecentErrorsLimit sets the number of recent errors which will be retained. Explicitly
-// setting a value of 0 will log no errors, -1 will be unlimited.
+// WithRecentErrorsLimit sets the number of recent errors which will be retained, 10 by default.
+// Explicitly setting a value of 0 will log no errors, -1 will be unlimited.
 func WithRecentErrorsLimit(limit int) CheckerOption {
 	return func(c *checker) {
 		c.recentErrorsLimit = limit
 	}
 }
 
+// WithOTLPExporter emits each check cycle as a trace and its measurements as metrics.
+func WithOTLPExporter(e *otlp.Exporter) CheckerOption {
+	return func(c *checker) {
+		c.exporter = e
+	}
+}
+
 type checkFunc struct {
 	f    check.Check
 	name string
@@ -97,6 +112,11 @@ type CheckStats struct {
 	Cycles           int
 	CyclesWithErrors int
 	LastCheck        time.Time
+	// PartialCycles counts on-demand runs of a subset of the checks, which aren't included in the
+	// other stats.
+	PartialCycles int `json:",omitempty"`
+	// Predecessors summarizes the results of previous instances of the same app, if restored.
+	Predecessors *CheckStats `json:",omitempty"`
 }
 
 type checker struct {
@@ -109,6 +129,38 @@ type checker struct {
 	recentErrorsLimit int
 	statLock          sync.Mutex
 	stats             CheckStats
+	checkStates       map[string]*checkState
+	measurements      map[string]float64
+	exporter          *otlp.Exporter
+	requiredChecks    []string
+	startupGate       bool
+	gracePeriod       time.Duration
+	passedFullCycle   bool
+	events            *eventBroker
+	restoreWindow     time.Duration
+	onDemand          onDemandRuns
+}
+
+// checkState tracks the running totals for an individual check.
+type checkState struct {
+	successes    uint64
+	failures     uint64
+	durations    histogram
+	latencies    latencyRing
+	lastRun      time.Time
+	lastError    string
+	lastErrorAt  time.Time
+	failingSince time.Time // zero if the most recent execution succeeded.
+}
+
+// checkOutcome describes the result of a single check within a cycle.
+type checkOutcome struct {
+	name     string
+	duration time.Duration
+	err      error
+	// aborted is set if the check failed because the cycle's ctx was canceled, or an on-demand
+	// cycle timed out.
+	aborted bool
 }
 
 // Run executes periodically until the ctx is cancelled.
@@ -128,77 +180,89 @@ func (c *checker) Run(ctx context.Context, interval time.Duration) {
 				defer wg.Done()
 				checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
 				defer cancel()
-				r := c.doChecks(checkCtx)
+				r, _ := c.doChecks(checkCtx, c.checks)
 				if ctx.Err() != nil {
 					return // abandon results if the ctx was canceled mid-check.
 				}
-				c.storer.AsyncQueryRetry(ctx, storer.SaveBackOffSchedule, func(ctx context.Context, attempt int) error {
-					err := c.storer.SaveCheckResults(ctx, r)
-					if err != nil {
-						r.Errors = append(r.Errors, check.CheckError{
-							Check: "result_save_attempt_" + strconv.Itoa(attempt),
-							Error: err.Error(),
-						})
-						return err
-					}
-					return nil
-				})
+				c.storer.AsyncSaveCheckResults(ctx, r, storer.SaveBackOffSchedule)
 			}()
 		}
 	}
 }
 
-func (c *checker) CheckHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	results := c.doChecks(ctx)
-	if err := c.storer.SaveCheckResults(ctx, results); err != nil {
-		log.Ctx(ctx).Err(err).Msg("saving check results")
+type statusOutput struct {
+	RecentErrors []check.CheckResults
+	Stats        CheckStats
+	Instance     *check.Instance
+	// Queue describes the storer's async saves, if it queues them.
+	Queue *storer.QueueStats `json:",omitempty"`
+	// Spool describes results waiting to be saved, if the storer spools failed saves.
+	Spool *storer.SpoolStats `json:",omitempty"`
+}
+
+// StatusHandler displays the most recent check results which include errors + some stats. Browsers
+// which prefer HTML are served the status dashboard.
+func (c *checker) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if prefersHTML(r) {
+		c.StatusHTMLHandler(w, r)
+		return
 	}
+	ctx := r.Context()
+	out := c.status()
+
 	j := json.NewEncoder(w)
 	j.SetIndent("", "  ")
 	w.Header().Add("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := j.Encode(results); err != nil {
-		log.Ctx(ctx).Warn().Err(err).Msg("encoding spot check")
+	if err := j.Encode(out); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("encoding error results check")
 	}
 }
 
-// StatusHandler displays the most recent check results which include errors + some stats.
-func (c *checker) StatusHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+func (c *checker) status() statusOutput {
 	c.statLock.Lock()
-	out := struct {
-		RecentErrors []check.CheckResults
-		Stats        CheckStats
-		Instance     *check.Instance
-	}{
+	defer c.statLock.Unlock()
+	out := statusOutput{
 		RecentErrors: make([]check.CheckResults, 0, c.recentErrors.Len()),
 		Stats:        c.stats,
-		Instance:     c.instance,
+		Instance:     c.instance.Copy(),
 	}
 	for e := c.recentErrors.Front(); e != nil; e = e.Next() {
 		r := e.Value.(check.CheckResults)
 		out.RecentErrors = append(out.RecentErrors, r)
 	}
-	c.statLock.Unlock()
-
-	j := json.NewEncoder(w)
-	j.SetIndent("", "  ")
-	w.Header().Add("content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := j.Encode(out); err != nil {
-		log.Ctx(ctx).Warn().Err(err).Msg("encoding error results check")
+	if q, ok := c.storer.(storer.Queuer); ok {
+		stats := q.QueueStats()
+		out.Queue = &stats
 	}
+	if s, ok := c.storer.(storer.Spooler); ok {
+		if stats, ok := s.SpoolStats(); ok {
+			out.Spool = &stats
+		}
+	}
+	return out
 }
 
-func (c *checker) doChecks(ctx context.Context) check.CheckResults {
+func (c *checker) doChecks(ctx context.Context, checks []checkFunc) (check.CheckResults, []checkOutcome) {
+	return c.doCycle(ctx, checks, false)
+}
+
+// doCycle runs the checks and records their outcomes. Checks still running when the cycle's
+// deadline passes fail as timed out. Checks cut off by cancellation aren't blamed for failing, nor
+// are those of on-demand cycles cut off by the deadline, since the caller chose the timeout; the
+// latter are still recorded as timed out in the result.
+func (c *checker) doCycle(ctx context.Context, checks []checkFunc, onDemand bool) (check.CheckResults, []checkOutcome) {
 	var wg sync.WaitGroup
+	// Labels can change while storers read the result, so it gets a copy of the instance.
 	r := check.CheckResults{
+		ID:       uuid.NewString(),
 		TS:       c.now(),
-		Instance: c.instance,
+		Instance: c.instance.Copy(),
 	}
 	var l sync.Mutex
-	for _, ch := range c.checks {
+	var outcomes []checkOutcome
+	ctx, cycleSpan := c.exporter.StartTrace(ctx, "self_check.cycle")
+	for _, ch := range checks {
 		ch := ch
 		log.Ctx(ctx).Info().
 			Str("check", ch.name).
@@ -206,9 +270,13 @@ func (c *checker) doChecks(ctx context.Context) check.CheckResults {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			checkCtx, span := otlp.Start(ctx, "check "+ch.name, otlp.SpanKindInternal)
+			span.SetAttribute("self_check.check", ch.name)
 			start := c.now()
-			measurements, err := ch.f(ctx)
+			measurements, err := ch.f(checkCtx)
 			finish := c.now()
+			span.RecordError(err)
+			span.End()
 			log.Ctx(ctx).Debug().
 				Str("check", ch.name).
 				Dur("duration", finish.Sub(start)).
@@ -217,15 +285,30 @@ func (c *checker) doChecks(ctx context.Context) check.CheckResults {
 			l.Lock()
 			defer l.Unlock()
 			if err != nil {
-				if ctx.Err() != nil { // If parent ctx is canceled this test isn't to blame.
+				timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+				if timedOut {
+					err = fmt.Errorf("timed out: %w", err)
+				}
+				// If the cycle was canceled, or an on-demand caller's timeout ran out, this test
+				// isn't to blame.
+				if ctx.Err() != nil && (!timedOut || onDemand) {
+					outcomes = append(outcomes, checkOutcome{name: ch.name, duration: finish.Sub(start), err: err, aborted: true})
+					if timedOut {
+						r.Errors = append(r.Errors, check.CheckError{
+							Check: ch.name,
+							Error: err.Error(),
+						})
+					}
 					return
 				}
+				outcomes = append(outcomes, checkOutcome{name: ch.name, duration: finish.Sub(start), err: err})
 				r.Errors = append(r.Errors, check.CheckError{
 					Check: ch.name,
 					Error: err.Error(),
 				})
 				return
 			}
+			outcomes = append(outcomes, checkOutcome{name: ch.name, duration: finish.Sub(start)})
 			r.Measurements = append(r.Measurements, measurements...)
 			r.Measurements = append(r.Measurements, check.CheckMeasurement{
 				Check: ch.name + "_duration",
@@ -234,14 +317,65 @@ func (c *checker) doChecks(ctx context.Context) check.CheckResults {
 		}()
 	}
 	wg.Wait()
+	cycleSpan.SetAttribute("self_check.errors", len(r.Errors))
+	cycleSpan.End()
 	c.statLock.Lock()
 	defer c.statLock.Unlock()
-	c.stats.Cycles++
-	c.stats.LastCheck = r.TS
+	partial := len(checks) < len(c.checks)
+	if partial {
+		c.stats.PartialCycles++
+	} else {
+		c.stats.Cycles++
+		c.stats.LastCheck = r.TS
+	}
+	var transitions []Transition
+	for _, o := range outcomes {
+		if o.aborted {
+			continue
+		}
+		s, ok := c.checkStates[o.name]
+		if !ok {
+			s = &checkState{
+				durations: newHistogram(defaultDurationBuckets),
+				latencies: newLatencyRing(sparklineSamples),
+			}
+			c.checkStates[o.name] = s
+		}
+		from := checkStateName(s)
+		s.lastRun = r.TS
+		if o.err != nil {
+			s.failures++
+			s.lastError = o.err.Error()
+			s.lastErrorAt = r.TS
+			if s.failingSince.IsZero() {
+				s.failingSince = r.TS
+			}
+		} else {
+			s.successes++
+			s.failingSince = time.Time{}
+		}
+		s.durations.observe(o.duration.Seconds())
+		s.latencies.add(o.duration)
+		if to := checkStateName(s); to != from {
+			t := Transition{Check: o.name, From: from, To: to, TS: r.TS}
+			if o.err != nil {
+				t.Error = o.err.Error()
+			}
+			transitions = append(transitions, t)
+		}
+	}
+	if len(checks) == len(c.checks) && c.requiredChecksPassed(outcomes) {
+		c.passedFullCycle = true
+	}
+	for _, m := range r.Measurements {
+		c.measurements[m.Check] = m.Value
+	}
 	if len(r.Errors) > 0 {
-		c.stats.CyclesWithErrors++
-		if c.recentErrorsLimit != -1 {
-			for c.recentErrorsLimit >= c.recentErrors.Len() && c.recentErrors.Len() > 0 {
+		if !partial {
+			c.stats.CyclesWithErrors++
+		}
+		if c.recentErrorsLimit > 0 {
+			for c.recentErrors.Len() >= c.recentErrorsLimit {
 				// Trim any excess errors before adding more.
 				c.recentErrors.Remove(c.recentErrors.Back())
 			}
@@ -250,7 +384,11 @@ func (c *checker) doChecks(ctx context.Context) check.CheckResults {
 			c.recentErrors.PushFront(r)
 		}
 	}
-	return r
+	if c.exporter != nil {
+		c.exporter.ExportMetrics(ctx, c.otlpMetrics())
+	}
+	c.publishCycle(r, outcomes, transitions)
+	return r, outcomes
 }
 
 // NewDNSCheck adds a check which probes the specified hostname
@@ -303,15 +441,20 @@ func NewHTTPCheck(url string) (check.Check, error) {
 	return func(ctx context.Context) ([]check.CheckMeasurement, error) {
 		c := http.Client{}
 		defer c.CloseIdleConnections()
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		ctx, span := otlp.Start(ctx, "GET "+url, otlp.SpanKindClient)
+		defer span.End()
+		span.SetAttribute("http.url", url)
+		req, err := http.NewRequestWithContext(traceHTTPPhases(ctx), http.MethodGet, url, nil)
 		if err != nil {
 			return nil, fmt.Errorf("building request: %w", err)
 		}
 		resp, err := c.Do(req)
 		if err != nil {
+			span.RecordError(err)
 			return nil, err
 		}
 		defer resp.Body.Close()
+		span.SetAttribute("http.status_code", resp.StatusCode)
 		if resp.StatusCode < 200 || resp.StatusCode > 299 {
 			var additionalInfo string
 			if originCode := resp.Header.Get("x-do-orig-status"); originCode != "" {
@@ -323,12 +466,72 @@ func NewHTTPCheck(url string) (check.Check, error) {
 			if code := resp.Header.Get("x-do-failure-code"); code != "" {
 				additionalInfo += " " + code
 			}
-			return nil, fmt.Errorf("unexpected status code: %d%s", resp.StatusCode, additionalInfo)
+			err := fmt.Errorf("unexpected status code: %d%s", resp.StatusCode, additionalInfo)
+			span.RecordError(err)
+			return nil, err
 		}
 		return nil, nil
 	}, nil
 }
 
+// traceHTTPPhases records the DNS, connect, TLS and time-to-first-byte phases of a request as
+// spans if the ctx is part of a trace.
+func traceHTTPPhases(ctx context.Context) context.Context {
+	var l sync.Mutex
+	var dnsSpan, tlsSpan, waitSpan *otlp.Span
+	connects := make(map[string]*otlp.Span)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			l.Lock()
+			defer l.Unlock()
+			_, dnsSpan = otlp.Start(ctx, "dns", otlp.SpanKindClient)
+			dnsSpan.SetAttribute("net.peer.name", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			l.Lock()
+			defer l.Unlock()
+			dnsSpan.RecordError(info.Err)
+			dnsSpan.End()
+		},
+		ConnectStart: func(network, addr string) {
+			l.Lock()
+			defer l.Unlock()
+			_, span := otlp.Start(ctx, "connect", otlp.SpanKindClient)
+			span.SetAttribute("net.peer.addr", addr)
+			connects[network+addr] = span
+		},
+		ConnectDone: func(network, addr string, err error) {
+			l.Lock()
+			defer l.Unlock()
+			span := connects[network+addr]
+			span.RecordError(err)
+			span.End()
+		},
+		TLSHandshakeStart: func() {
+			l.Lock()
+			defer l.Unlock()
+			_, tlsSpan = otlp.Start(ctx, "tls_handshake", otlp.SpanKindClient)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			l.Lock()
+			defer l.Unlock()
+			tlsSpan.RecordError(err)
+			tlsSpan.End()
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			l.Lock()
+			defer l.Unlock()
+			_, waitSpan = otlp.Start(ctx, "wait_first_byte", otlp.SpanKindClient)
+			waitSpan.RecordError(info.Err)
+		},
+		GotFirstResponseByte: func() {
+			l.Lock()
+			defer l.Unlock()
+			waitSpan.End()
+		},
+	})
+}
+
 // NewMySQLCheck will connect to the provided mysql server, execute a ping and disconnect.
 func NewMySQLCheck(uri, cert string) (check.Check, error) {
 	// All of this only gets done once.
@@ -364,7 +567,12 @@ func NewMySQLCheck(uri, cert string) (check.Check, error) {
 	}
 	// Ok we are FINALLY done with all of the setup.  This is the real check.
 	return func(ctx context.Context) ([]check.CheckMeasurement, error) {
+		_, span := otlp.Start(ctx, "mysql.connect", otlp.SpanKindClient)
+		span.SetAttribute("db.system", "mysql")
+		span.SetAttribute("net.peer.addr", config.Addr)
 		dbConn, err := connector.Connect(ctx)
+		span.RecordError(err)
+		span.End()
 		if err != nil {
 			return nil, err
 		}
@@ -373,6 +581,11 @@ func NewMySQLCheck(uri, cert string) (check.Check, error) {
 		if !ok {
 			return nil, errors.New("mysql driver missing Ping(ctx)")
 		}
-		return nil, pinger.Ping(ctx)
+		_, span = otlp.Start(ctx, "mysql.ping", otlp.SpanKindClient)
+		span.SetAttribute("db.system", "mysql")
+		err = pinger.Ping(ctx)
+		span.RecordError(err)
+		span.End()
+		return nil, err
 	}, nil
 }
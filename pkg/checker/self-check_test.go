@@ -0,0 +1,44 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestRecentErrorsLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   []CheckerOption
+		expect int
+	}{
+		{name: "default", expect: 4},
+		{name: "limited", opts: []CheckerOption{WithRecentErrorsLimit(2)}, expect: 2},
+		{name: "disabled", opts: []CheckerOption{WithRecentErrorsLimit(0)}, expect: 0},
+		{name: "unlimited", opts: []CheckerOption{WithRecentErrorsLimit(-1)}, expect: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+			now := start
+			c := NewChecker(append(tc.opts, WithCheck("broken", func(ctx context.Context) ([]check.CheckMeasurement, error) {
+				return nil, errors.New("broken")
+			}))...)
+			c.now = func() time.Time { return now }
+			for i := 0; i < 4; i++ {
+				c.doChecks(context.Background(), c.checks)
+				now = now.Add(time.Minute)
+			}
+			errs := c.status().RecentErrors
+			if len(errs) != tc.expect {
+				t.Fatalf("expected %d recent errors, got %d", tc.expect, len(errs))
+			}
+			// The newest errors are kept, newest first.
+			if len(errs) > 0 && !errs[0].TS.Equal(start.Add(3*time.Minute)) {
+				t.Errorf("expected the newest error first, got %s", errs[0].TS)
+			}
+		})
+	}
+}
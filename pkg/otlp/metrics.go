@@ -0,0 +1,126 @@
+package otlp
+
+import (
+	"context"
+	"time"
+)
+
+// MetricKind describes how a metric's points should be interpreted.
+type MetricKind int
+
+const (
+	// Gauge metrics report the most recent value.
+	Gauge MetricKind = iota
+	// Counter metrics report a monotonic, cumulative sum since the exporter was created.
+	Counter
+)
+
+const aggregationTemporalityCumulative = 2
+
+// Metric is a named set of data points.
+type Metric struct {
+	Name        string
+	Description string
+	Unit        string
+	Kind        MetricKind
+	Points      []Point
+}
+
+// Point is a single value of a Metric.
+type Point struct {
+	Attributes map[string]string
+	Value      float64
+}
+
+// ExportMetrics sends the metrics to the collector in the background.
+func (e *Exporter) ExportMetrics(ctx context.Context, metrics []Metric) {
+	if e == nil || e.cfg.MetricsEndpoint == "" || len(metrics) == 0 {
+		return
+	}
+	now := unixNano(time.Now())
+	start := unixNano(e.start)
+	encoded := make([]metricJSON, 0, len(metrics))
+	for _, m := range metrics {
+		points := make([]numberDataPoint, 0, len(m.Points))
+		for _, p := range m.Points {
+			attrs := make(map[string]interface{}, len(p.Attributes))
+			for k, v := range p.Attributes {
+				attrs[k] = v
+			}
+			dp := numberDataPoint{
+				Attributes:   newAttributes(attrs),
+				TimeUnixNano: now,
+				AsDouble:     p.Value,
+			}
+			if m.Kind == Counter {
+				dp.StartTimeUnixNano = start
+			}
+			points = append(points, dp)
+		}
+		mj := metricJSON{
+			Name:        m.Name,
+			Description: m.Description,
+			Unit:        m.Unit,
+		}
+		switch m.Kind {
+		case Counter:
+			mj.Sum = &sumJSON{
+				DataPoints:             points,
+				AggregationTemporality: aggregationTemporalityCumulative,
+				IsMonotonic:            true,
+			}
+		default:
+			mj.Gauge = &gaugeJSON{DataPoints: points}
+		}
+		encoded = append(encoded, mj)
+	}
+	payload := metricsPayload{
+		ResourceMetrics: []resourceMetrics{{
+			Resource: e.resource(),
+			ScopeMetrics: []scopeMetrics{{
+				Scope:   scope{Name: scopeName},
+				Metrics: encoded,
+			}},
+		}},
+	}
+	e.exportAsync(ctx, e.cfg.MetricsEndpoint, e.cfg.MetricsHeaders, payload)
+}
+
+type metricsPayload struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type scopeMetrics struct {
+	Scope   scope        `json:"scope"`
+	Metrics []metricJSON `json:"metrics"`
+}
+
+type metricJSON struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Gauge       *gaugeJSON `json:"gauge,omitempty"`
+	Sum         *sumJSON   `json:"sum,omitempty"`
+}
+
+type gaugeJSON struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type sumJSON struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type numberDataPoint struct {
+	Attributes        []attribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string      `json:"timeUnixNano"`
+	AsDouble          float64     `json:"asDouble"`
+}
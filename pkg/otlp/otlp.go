@@ -0,0 +1,298 @@
+// Package otlp implements a small OTLP/HTTP exporter which emits check cycles as traces and check
+// measurements as metrics. Payloads use the OTLP JSON encoding so we avoid pulling the
+// OpenTelemetry SDK and its protobuf dependencies into the binary.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultServiceName = "apps-self-check"
+	scopeName          = "github.com/digitalocean/apps-self-check"
+
+	protocolJSON = "http/json"
+)
+
+// Config describes where and how telemetry is exported.
+type Config struct {
+	TracesEndpoint     string
+	MetricsEndpoint    string
+	TracesHeaders      map[string]string
+	MetricsHeaders     map[string]string
+	Timeout            time.Duration
+	ServiceName        string
+	ResourceAttributes map[string]string
+}
+
+// ConfigFromEnv builds a Config from the standard OTEL_EXPORTER_OTLP_* environment variables. A nil
+// Config is returned if no endpoint has been configured.
+func ConfigFromEnv(getenv func(string) string) (*Config, error) {
+	if strings.EqualFold(getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil, nil
+	}
+	c := &Config{
+		Timeout:     defaultTimeout,
+		ServiceName: defaultServiceName,
+	}
+	if base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+		base = strings.TrimSuffix(base, "/")
+		c.TracesEndpoint = base + "/v1/traces"
+		c.MetricsEndpoint = base + "/v1/metrics"
+	}
+	if e := getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); e != "" {
+		c.TracesEndpoint = e
+	}
+	if e := getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"); e != "" {
+		c.MetricsEndpoint = e
+	}
+	if c.TracesEndpoint == "" && c.MetricsEndpoint == "" {
+		return nil, nil
+	}
+
+	for _, v := range []string{"OTEL_EXPORTER_OTLP_PROTOCOL", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_METRICS_PROTOCOL"} {
+		if p := getenv(v); p != "" && p != protocolJSON {
+			return nil, fmt.Errorf("%s: unsupported protocol %q, only %q is supported", v, p, protocolJSON)
+		}
+	}
+
+	headers, err := parseKeyValues(getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	if c.TracesHeaders, err = mergeKeyValues(headers, getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")); err != nil {
+		return nil, fmt.Errorf("parsing OTEL_EXPORTER_OTLP_TRACES_HEADERS: %w", err)
+	}
+	if c.MetricsHeaders, err = mergeKeyValues(headers, getenv("OTEL_EXPORTER_OTLP_METRICS_HEADERS")); err != nil {
+		return nil, fmt.Errorf("parsing OTEL_EXPORTER_OTLP_METRICS_HEADERS: %w", err)
+	}
+
+	if t := getenv("OTEL_EXPORTER_OTLP_TIMEOUT"); t != "" {
+		ms, err := strconv.ParseUint(t, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing OTEL_EXPORTER_OTLP_TIMEOUT: %w", err)
+		}
+		c.Timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	if c.ResourceAttributes, err = parseKeyValues(getenv("OTEL_RESOURCE_ATTRIBUTES")); err != nil {
+		return nil, fmt.Errorf("parsing OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+	if s := getenv("OTEL_SERVICE_NAME"); s != "" {
+		c.ServiceName = s
+	} else if s := c.ResourceAttributes["service.name"]; s != "" {
+		c.ServiceName = s
+	}
+	return c, nil
+}
+
+// parseKeyValues parses the comma separated, url-encoded key=value format used by the OTEL_*
+// environment variables.
+func parseKeyValues(s string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key-value pair %q", kv)
+		}
+		k, err := url.QueryUnescape(strings.TrimSpace(k))
+		if err != nil {
+			return nil, err
+		}
+		v, err = url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+func mergeKeyValues(base map[string]string, s string) (map[string]string, error) {
+	specific, err := parseKeyValues(s)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(base)+len(specific))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range specific {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// Exporter sends traces and metrics to an OTLP/HTTP collector.
+type Exporter struct {
+	cfg      Config
+	client   *http.Client
+	instance *check.Instance
+	start    time.Time
+	wg       sync.WaitGroup
+}
+
+// New creates an exporter. The instance fields are sent as resource attributes.
+func New(cfg Config, instance *check.Instance) *Exporter {
+	return &Exporter{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		instance: instance,
+		start:    time.Now(),
+	}
+}
+
+// Close waits for any in-flight exports to complete.
+func (e *Exporter) Close() error {
+	e.wg.Wait()
+	return nil
+}
+
+func (e *Exporter) resource() resource {
+	attrs := make(map[string]interface{}, len(e.cfg.ResourceAttributes)+8)
+	for k, v := range e.cfg.ResourceAttributes {
+		attrs[k] = v
+	}
+	attrs["service.name"] = e.cfg.ServiceName
+	if e.instance != nil {
+		e.instance.Lock()
+		attrs["service.instance.id"] = e.instance.UUID
+		attrs["host.name"] = e.instance.Hostname
+		if e.instance.AppID != "" {
+			attrs["self_check.app_id"] = e.instance.AppID
+		}
+		if e.instance.PublicIPv4 != "" {
+			attrs["self_check.public_ipv4"] = e.instance.PublicIPv4
+		}
+		for k, v := range e.instance.Labels {
+			attrs["self_check.label."+k] = v
+		}
+		e.instance.Unlock()
+	}
+	return resource{Attributes: newAttributes(attrs)}
+}
+
+// exportAsync posts the payload in the background. Failures are logged rather than returned
+// because telemetry should never block or fail a check cycle.
+func (e *Exporter) exportAsync(ctx context.Context, endpoint string, headers map[string]string, payload interface{}) {
+	if endpoint == "" {
+		return
+	}
+	ll := log.Ctx(ctx)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		if err := e.post(context.Background(), endpoint, headers, payload); err != nil {
+			ll.Warn().Err(err).Str("endpoint", endpoint).Msg("exporting telemetry")
+		}
+	}()
+}
+
+func (e *Exporter) post(ctx context.Context, endpoint string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("content-type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type attribute struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+// anyValue follows the protobuf JSON mapping where 64bit integers are encoded as strings.
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newAttributes(m map[string]interface{}) []attribute {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]attribute, 0, len(m))
+	for _, k := range keys {
+		out = append(out, newAttribute(k, m[k]))
+	}
+	return out
+}
+
+func newAttribute(key string, v interface{}) attribute {
+	a := attribute{Key: key}
+	switch v := v.(type) {
+	case string:
+		a.Value.StringValue = &v
+	case bool:
+		a.Value.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		a.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		a.Value.IntValue = &s
+	case float64:
+		a.Value.DoubleValue = &v
+	case time.Duration:
+		f := v.Seconds()
+		a.Value.DoubleValue = &f
+	case error:
+		s := v.Error()
+		a.Value.StringValue = &s
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
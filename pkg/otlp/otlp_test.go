@@ -0,0 +1,131 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+// collector is a stand-in for an OTLP collector which records received payloads.
+type collector struct {
+	lock    sync.Mutex
+	traces  []tracesPayload
+	metrics []metricsPayload
+	headers []http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.headers = append(c.headers, r.Header)
+	var err error
+	switch r.URL.Path {
+	case "/v1/traces":
+		var p tracesPayload
+		err = json.NewDecoder(r.Body).Decode(&p)
+		c.traces = append(c.traces, p)
+	case "/v1/metrics":
+		var p metricsPayload
+		err = json.NewDecoder(r.Body).Decode(&p)
+		c.metrics = append(c.metrics, p)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	col := &collector{}
+	srv := httptest.NewServer(col)
+	defer srv.Close()
+
+	env := map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": srv.URL,
+		"OTEL_EXPORTER_OTLP_HEADERS":  "x-api-key=secret%20value",
+	}
+	cfg, err := ConfigFromEnv(func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(*cfg, &check.Instance{UUID: "instance-uuid", AppID: "app", Hostname: "host"})
+
+	ctx, root := e.StartTrace(context.Background(), "cycle")
+	childCtx, child := Start(ctx, "check", SpanKindInternal)
+	_, grandchild := Start(childCtx, "dns", SpanKindClient)
+	grandchild.End()
+	child.RecordError(errors.New("failed"))
+	child.End()
+	root.End()
+	e.ExportMetrics(ctx, []Metric{{Name: "m", Kind: Counter, Points: []Point{{Value: 2}}}})
+	e.Close()
+
+	col.lock.Lock()
+	defer col.lock.Unlock()
+	if len(col.traces) != 1 || len(col.metrics) != 1 {
+		t.Fatalf("expected 1 trace and 1 metrics payload, got %d and %d", len(col.traces), len(col.metrics))
+	}
+	for _, h := range col.headers {
+		if got := h.Get("x-api-key"); got != "secret value" {
+			t.Errorf("expected x-api-key header %q, got %q", "secret value", got)
+		}
+	}
+
+	spans := col.traces[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	byName := make(map[string]spanJSON)
+	for _, s := range spans {
+		if s.TraceID != spans[0].TraceID {
+			t.Errorf("span %q has mismatched trace id", s.Name)
+		}
+		byName[s.Name] = s
+	}
+	if byName["cycle"].ParentSpanID != "" {
+		t.Errorf("expected root span without parent")
+	}
+	if byName["check"].ParentSpanID != byName["cycle"].SpanID {
+		t.Errorf("expected check span to be a child of cycle")
+	}
+	if byName["dns"].ParentSpanID != byName["check"].SpanID {
+		t.Errorf("expected dns span to be a child of check")
+	}
+	if s := byName["check"].Status; s == nil || s.Code != statusCodeError || s.Message != "failed" {
+		t.Errorf("expected error status on check span, got %+v", s)
+	}
+
+	var foundInstance bool
+	for _, a := range col.traces[0].ResourceSpans[0].Resource.Attributes {
+		if a.Key == "service.instance.id" && a.Value.StringValue != nil && *a.Value.StringValue == "instance-uuid" {
+			foundInstance = true
+		}
+	}
+	if !foundInstance {
+		t.Errorf("expected service.instance.id resource attribute")
+	}
+
+	m := col.metrics[0].ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+	if m.Sum == nil || !m.Sum.IsMonotonic || m.Sum.DataPoints[0].AsDouble != 2 {
+		t.Errorf("unexpected metric: %+v", m)
+	}
+}
+
+func TestStartWithoutTrace(t *testing.T) {
+	ctx, s := Start(context.Background(), "orphan", SpanKindInternal)
+	if s != nil || ctx != context.Background() {
+		t.Fatalf("expected no span outside of a trace")
+	}
+	// These should all be safe no-ops.
+	s.SetAttribute("k", "v")
+	s.RecordError(errors.New("ignored"))
+	s.End()
+}
@@ -0,0 +1,205 @@
+package otlp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SpanKind values as defined by the OTLP protocol.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+const statusCodeError = 2
+
+type spanCtxKey struct{}
+
+// trace collects the spans of a single trace so they may be exported together when the root span
+// ends.
+type trace struct {
+	exporter *Exporter
+	ctx      context.Context
+	id       [16]byte
+	lock     sync.Mutex
+	spans    []*Span
+	done     bool
+}
+
+// Span is a single timed operation within a trace. A nil *Span is valid and ignores all calls,
+// so instrumented code need not check whether tracing is enabled.
+type Span struct {
+	trace    *trace
+	id       [8]byte
+	parentID [8]byte
+	root     bool
+	name     string
+	kind     SpanKind
+	start    time.Time
+	end      time.Time
+	lock     sync.Mutex
+	attrs    map[string]interface{}
+	errMsg   string
+}
+
+// StartTrace begins a new trace with a root span. All spans started from the returned ctx are
+// exported together when the root span ends.
+func (e *Exporter) StartTrace(ctx context.Context, name string) (context.Context, *Span) {
+	if e == nil || e.cfg.TracesEndpoint == "" {
+		return ctx, nil
+	}
+	t := &trace{exporter: e, ctx: ctx}
+	rand.Read(t.id[:])
+	s := t.newSpan(name, SpanKindInternal, [8]byte{})
+	s.root = true
+	return context.WithValue(ctx, spanCtxKey{}, s), s
+}
+
+// Start begins a child span of the span stored in ctx. If ctx holds no span, the ctx is returned
+// unmodified with a nil span.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent, _ := ctx.Value(spanCtxKey{}).(*Span)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.trace.newSpan(name, kind, parent.id)
+	return context.WithValue(ctx, spanCtxKey{}, s), s
+}
+
+func (t *trace) newSpan(name string, kind SpanKind, parentID [8]byte) *Span {
+	s := &Span{
+		trace:    t,
+		parentID: parentID,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+		attrs:    make(map[string]interface{}),
+	}
+	rand.Read(s.id[:])
+	return s
+}
+
+// SetAttribute records a key-value pair on the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attrs[key] = value
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.errMsg = err.Error()
+}
+
+// End completes the span. Ending the root span exports the trace; spans which end after their
+// root are discarded.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if !s.end.IsZero() {
+		s.lock.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.lock.Unlock()
+
+	t := s.trace
+	t.lock.Lock()
+	if t.done {
+		t.lock.Unlock()
+		return
+	}
+	t.spans = append(t.spans, s)
+	if !s.root {
+		t.lock.Unlock()
+		return
+	}
+	t.done = true
+	spans := t.spans
+	t.lock.Unlock()
+	t.exporter.exportSpans(t.ctx, t.id, spans)
+}
+
+func (e *Exporter) exportSpans(ctx context.Context, traceID [16]byte, spans []*Span) {
+	encoded := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.toJSON(traceID))
+	}
+	payload := tracesPayload{
+		ResourceSpans: []resourceSpans{{
+			Resource: e.resource(),
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName},
+				Spans: encoded,
+			}},
+		}},
+	}
+	e.exportAsync(ctx, e.cfg.TracesEndpoint, e.cfg.TracesHeaders, payload)
+}
+
+func (s *Span) toJSON(traceID [16]byte) spanJSON {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	out := spanJSON{
+		TraceID:           hex.EncodeToString(traceID[:]),
+		SpanID:            hex.EncodeToString(s.id[:]),
+		Name:              s.name,
+		Kind:              int(s.kind),
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(s.end),
+		Attributes:        newAttributes(s.attrs),
+	}
+	if !s.root {
+		out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.errMsg != "" {
+		out.Status = &statusJSON{Code: statusCodeError, Message: s.errMsg}
+	}
+	return out
+}
+
+type tracesPayload struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type spanJSON struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            *statusJSON `json:"status,omitempty"`
+}
+
+type statusJSON struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,68 @@
+package storer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+// maxArchiveLine limits the size of a single result in an archive.
+const maxArchiveLine = 16 << 20
+
+// ArchiveWriter writes check results in the archive format: one JSON object per line. Archives are
+// written by the file storer and `analyze export`, and read by `analyze import`.
+type ArchiveWriter struct {
+	enc *json.Encoder
+}
+
+// NewArchiveWriter returns a writer which appends results to w.
+func NewArchiveWriter(w io.Writer) *ArchiveWriter {
+	return &ArchiveWriter{enc: json.NewEncoder(w)}
+}
+
+// Write appends a result.
+func (a *ArchiveWriter) Write(result check.CheckResults) error {
+	return a.enc.Encode(result)
+}
+
+// ReadArchive calls f for each result in an archive, which may be gzipped. Results of the same
+// instance share an Instance.
+func ReadArchive(r io.Reader, f func(result check.CheckResults) error) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("opening gzip archive: %w", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+	instances := make(map[string]*check.Instance)
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(nil, maxArchiveLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var result check.CheckResults
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if i := result.Instance; i != nil {
+			if shared, ok := instances[i.UUID]; ok {
+				result.Instance = shared
+			} else {
+				instances[i.UUID] = i
+			}
+		}
+		if err := f(result); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
 	"github.com/rs/zerolog/log"
 )
 
@@ -12,6 +13,9 @@ const (
 	// dyingBreathExpiration defines a grace period after the ctx has been canceled, when async
 	// saves will be attempted before returning.
 	dyingBreathExpiration = 1 * time.Second
+	// spoolReplayInterval is how often spooled results are replayed, in addition to after each
+	// successful save.
+	spoolReplayInterval = time.Minute
 )
 
 var (
@@ -29,60 +33,250 @@ var (
 	}
 )
 
+// StorerOption describes optional arguments for storers.
+type StorerOption func(*storerOptions)
+
+type storerOptions struct {
+	spoolDir      string
+	spoolMaxBytes int64
+	queueDepth    int
+	overflow      OverflowPolicy
+	workers       int
+	webhook       WebhookConfig
+}
+
+// WithSpool appends results which fail to save to spool files in dir, and replays them at startup,
+// periodically and whenever a save succeeds. The oldest results are dropped once the spool exceeds
+// maxBytes.
+func WithSpool(dir string, maxBytes int64) StorerOption {
+	return func(o *storerOptions) {
+		o.spoolDir = dir
+		o.spoolMaxBytes = maxBytes
+	}
+}
+
+// WithQueue bounds the number of async saves and queries waiting for a worker. The policy decides
+// what happens when the queue is full.
+func WithQueue(depth int, policy OverflowPolicy) StorerOption {
+	return func(o *storerOptions) {
+		o.queueDepth = depth
+		o.overflow = policy
+	}
+}
+
+// WithWorkers sets the number of workers performing async saves and queries.
+func WithWorkers(n int) StorerOption {
+	return func(o *storerOptions) {
+		o.workers = n
+	}
+}
+
+// saveFunc saves a batch of check results, in a single transaction where possible.
+type saveFunc func(ctx context.Context, results []check.CheckResults) error
+
 // commonStorer provides foundational bits used by all storer implementations.
 type commonStorer struct {
 	done      chan struct{}
 	closeOnce sync.Once
-	wg        sync.WaitGroup
+	// wg tracks async work which hasn't completed.
+	wg sync.WaitGroup
+
+	// save is provided by the embedding storer.
+	save      saveFunc
+	spool     *spool
+	replaying sync.Mutex
+	// replayLoop starts periodic replays of the spool, and replayLoopDone is closed when they stop.
+	replayLoop     sync.Once
+	replayLoopDone chan struct{}
+
+	queueLock  sync.Mutex
+	notEmpty   *sync.Cond
+	notFull    *sync.Cond
+	queue      []*asyncJob
+	queueDepth int
+	overflow   OverflowPolicy
+	running    int
+	inFlight   int
+	dropped    int
+	closed     bool
 }
 
-func (c *commonStorer) init() {
+func (c *commonStorer) init(save saveFunc, opts ...StorerOption) error {
 	c.done = make(chan struct{})
+	c.save = save
+	o := storerOptions{
+		queueDepth: DefaultQueueDepth,
+		workers:    DefaultQueueWorkers,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.spoolDir != "" {
+		var err error
+		if c.spool, err = openSpool(o.spoolDir, o.spoolMaxBytes); err != nil {
+			return err
+		}
+	}
+	c.initQueue(o.queueDepth, o.overflow, o.workers)
+	return nil
+}
+
+// close makes pending async work do a final attempt, and waits for it to complete.
+func (c *commonStorer) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.queueLock.Lock()
+		c.closed = true
+		c.notEmpty.Broadcast()
+		c.notFull.Broadcast()
+		c.queueLock.Unlock()
+	})
+	// Stop the replay loop first so it can't start a replay while waiting for async work.
+	c.replayLoop.Do(func() {})
+	if c.replayLoopDone != nil {
+		<-c.replayLoopDone
+	}
+	c.wg.Wait()
+	if c.spool != nil {
+		c.spool.close()
+	}
 }
 
-// AsyncQueryRetry saves check results asynchronously with retries on failure.
+// AsyncSaveCheckResults saves check results asynchronously with retries on failure. Each failed
+// attempt is recorded as an error on the results. Results waiting in the queue are saved together
+// in batches. If all attempts fail, the results are spooled when a spool is configured.
+func (c *commonStorer) AsyncSaveCheckResults(ctx context.Context, result check.CheckResults, attemptSchedule []time.Duration) {
+	c.enqueue(&asyncJob{ctx: ctx, schedule: attemptSchedule, result: &result})
+}
+
+// AsyncQueryRetry runs a database action asynchronously with retries on failure.
 func (c *commonStorer) AsyncQueryRetry(
 	ctx context.Context,
 	attemptSchedule []time.Duration,
 	f func(ctx context.Context, attempt int) error,
 ) {
+	c.enqueue(&asyncJob{ctx: ctx, schedule: attemptSchedule, f: f})
+}
+
+// spoolResult appends results which couldn't be saved to the spool, if one is configured.
+func (c *commonStorer) spoolResult(ctx context.Context, result check.CheckResults) {
+	if c.spool == nil {
+		return
+	}
+	if err := c.spool.Append(result); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("spooling check results")
+	}
+}
+
+// replaySpool starts replaying spooled results in the background, unless a replay is already
+// running.
+func (c *commonStorer) replaySpool(ctx context.Context) {
+	if c.spool == nil || c.spool.Stats().Pending == 0 || !c.replaying.TryLock() {
+		return
+	}
 	c.wg.Add(1)
-	ll := log.Ctx(ctx)
 	go func() {
 		defer c.wg.Done()
-		var err error
-		for i, delay := range attemptSchedule {
-			var dyingBreath bool
-			attempt := i + 1
-			if delay > 0 {
-				t := time.NewTimer(delay)
+		defer c.replaying.Unlock()
+		// Stop replaying on shutdown; anything left is replayed by the next instance.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		n, err := c.spool.Replay(ctx, func(ctx context.Context, result check.CheckResults) error {
+			return c.save(ctx, []check.CheckResults{result})
+		})
+		ll := log.Ctx(ctx).With().Int("replayed", n).Int("pending", c.spool.Stats().Pending).Logger()
+		if err != nil && ctx.Err() == nil {
+			ll.Warn().Err(err).Msg("replaying spooled check results")
+		} else if n > 0 {
+			ll.Info().Msg("replayed spooled check results")
+		}
+	}()
+}
+
+// startSpoolReplays replays spooled results left by a previous run, and then periodically until
+// the storer is closed so they are replayed even if no new results are saved. It is started by the
+// first async job, whose ctx provides the logger.
+func (c *commonStorer) startSpoolReplays(ctx context.Context) {
+	if c.spool == nil {
+		return
+	}
+	c.replayLoop.Do(func() {
+		ctx := log.Ctx(ctx).WithContext(context.Background())
+		c.replaySpool(ctx)
+		c.replayLoopDone = make(chan struct{})
+		go func() {
+			defer close(c.replayLoopDone)
+			t := time.NewTicker(spoolReplayInterval)
+			defer t.Stop()
+			for {
 				select {
-				case <-ctx.Done():
-					dyingBreath = true
-				case <-t.C:
 				case <-c.done:
-					dyingBreath = true
+					return
+				case <-t.C:
+					c.replaySpool(ctx)
 				}
 			}
-			if dyingBreath || ctx.Err() != nil {
-				// We're shutting down, but haven't successfully saved yet. Make a hail mary
-				// attempt with a fresh (but short-lived) ctx.
-				var cancel func()
-				ctx, cancel = context.WithTimeout(context.Background(), dyingBreathExpiration)
-				defer cancel()
-			}
-			if err = f(ctx, attempt); err == nil {
-				return
-			}
-			if attempt < len(attemptSchedule) {
-				ll.Warn().Err(err).Int("attempt", attempt).Msg("performing async database action")
-			}
-			if dyingBreath || ctx.Err() != nil {
-				return
+		}()
+	})
+}
+
+// SpoolStats reports the state of the spool, if one is configured.
+func (c *commonStorer) SpoolStats() (SpoolStats, bool) {
+	if c.spool == nil {
+		return SpoolStats{}, false
+	}
+	return c.spool.Stats(), true
+}
+
+// retry runs f on the attempt schedule until it succeeds, returning the last error.
+func retry(
+	ctx context.Context,
+	done <-chan struct{},
+	attemptSchedule []time.Duration,
+	f func(ctx context.Context, attempt int) error,
+) error {
+	ll := log.Ctx(ctx)
+	var err error
+	for i, delay := range attemptSchedule {
+		var dyingBreath bool
+		attempt := i + 1
+		if delay > 0 {
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				dyingBreath = true
+			case <-t.C:
+			case <-done:
+				dyingBreath = true
 			}
+			t.Stop()
 		}
-		ll.Error().Err(err).
-			Int("attempt", len(attemptSchedule)).
-			Msg("final attempt: performing async database action")
-	}()
+		if dyingBreath || ctx.Err() != nil {
+			// We're shutting down, but haven't successfully saved yet. Make a hail mary
+			// attempt with a fresh (but short-lived) ctx.
+			var cancel func()
+			ctx, cancel = context.WithTimeout(context.Background(), dyingBreathExpiration)
+			defer cancel()
+		}
+		if err = f(ctx, attempt); err == nil {
+			return nil
+		}
+		if attempt < len(attemptSchedule) {
+			ll.Warn().Err(err).Int("attempt", attempt).Msg("performing async database action")
+		}
+		if dyingBreath || ctx.Err() != nil {
+			return err
+		}
+	}
+	ll.Error().Err(err).
+		Int("attempt", len(attemptSchedule)).
+		Msg("final attempt: performing async database action")
+	return err
 }
@@ -19,7 +19,9 @@ func TestAsyncQueryRetry(t *testing.T) {
 
 	m := NewMockStorer(ctrl)
 	c := &commonStorer{}
-	c.init()
+	if err := c.init(saveEach(m.SaveCheckResults)); err != nil {
+		t.Fatal(err)
+	}
 
 	m.EXPECT().SaveCheckResults(gomock.Any(), check.CheckResults{}).Return(errors.New("failed to save"))
 	m.EXPECT().SaveCheckResults(gomock.Any(), check.CheckResults{
@@ -50,3 +52,15 @@ func TestAsyncQueryRetry(t *testing.T) {
 
 	c.wg.Wait()
 }
+
+// saveEach adapts a single result save, such as a mock's, to save batches.
+func saveEach(save func(ctx context.Context, result check.CheckResults) error) saveFunc {
+	return func(ctx context.Context, results []check.CheckResults) error {
+		for _, r := range results {
+			if err := save(ctx, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// lockTimeout bounds how long an instance waits for an advisory lock held by another.
+const lockTimeout = 60 * time.Second
+
+// dialect describes the differences between the SQL databases supported by the storers.
+type dialect struct {
+	name        string
+	placeholder sq.PlaceholderFormat
+	// createMigrationsTable creates the schema_migrations table.
+	createMigrationsTable string
+	// hasTable counts the tables with the name given as its single argument.
+	hasTable string
+	// transactional is set if DDL can be rolled back, allowing each migration to be applied
+	// atomically.
+	transactional bool
+	// lock acquires a named advisory lock, which is held by the connection until unlocked. It is
+	// nil if the database doesn't need one.
+	lock   func(ctx context.Context, conn *sql.Conn, name string) error
+	unlock func(ctx context.Context, conn *sql.Conn, name string) error
+	// beginLocked, if set, starts a transaction which blocks other writers. It is used instead of
+	// an advisory lock: the status is read and all migrations applied within it.
+	beginLocked string
+}
+
+var (
+	mysqlDialect = dialect{
+		name:        "mysql",
+		placeholder: sq.Question,
+		createMigrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP(6) NOT NULL,
+			PRIMARY KEY(version)
+		)`,
+		hasTable: `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`,
+		lock: func(ctx context.Context, conn *sql.Conn, name string) error {
+			var acquired sql.NullInt64
+			err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, name, int(lockTimeout.Seconds())).
+				Scan(&acquired)
+			if err != nil {
+				return err
+			}
+			if acquired.Int64 != 1 {
+				return errors.New("timed out waiting for migration lock")
+			}
+			return nil
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn, name string) error {
+			_, err := conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, name)
+			return err
+		},
+	}
+	postgresDialect = dialect{
+		name:        "postgres",
+		placeholder: sq.Dollar,
+		createMigrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMPTZ(6) NOT NULL
+		)`,
+		hasTable:      `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`,
+		transactional: true,
+		lock: func(ctx context.Context, conn *sql.Conn, name string) error {
+			ctx, cancel := context.WithTimeout(ctx, lockTimeout)
+			defer cancel()
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, name)
+			return err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn, name string) error {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, name)
+			return err
+		},
+	}
+	// SQLite has no advisory locks, but an immediate transaction takes the database's write lock
+	// before the status is read, so concurrent migrators wait for each other.
+	sqliteDialect = dialect{
+		name:        "sqlite",
+		placeholder: sq.Question,
+		createMigrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)`,
+		hasTable:      `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		transactional: true,
+		beginLocked:   `BEGIN IMMEDIATE`,
+	}
+)
@@ -0,0 +1,427 @@
+package storer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultFileMaxSize is the size at which the file storer rotates its file.
+	DefaultFileMaxSize = 64 << 20
+	// DefaultFileMaxAge is the age at which the file storer rotates its file.
+	DefaultFileMaxAge = 24 * time.Hour
+	// segmentTimeFormat names rotated segments so they sort chronologically.
+	segmentTimeFormat = "20060102T150405.000000000Z"
+)
+
+// fileStorer appends results to a file in the archive format. The file is rotated into segments
+// named after the time of rotation, ex. `results-20221101T000000.000000000Z.jsonl.gz`.
+type fileStorer struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+	maxFiles int
+	now      func() time.Time
+
+	// lock guards the active file and rotation.
+	lock     sync.Mutex
+	f        *os.File
+	size     int64
+	openedAt time.Time
+	// maintenance serializes compressing and pruning rotated segments, which runs in the background
+	// so saves don't wait on it.
+	maintenance sync.Mutex
+	maintaining sync.WaitGroup
+	commonStorer
+}
+
+// NewFileStorer creates a storer which appends results to a file as JSON lines. The uri takes the
+// form `file:///path/to/results.jsonl` or `file:relative/results.jsonl`, with optional parameters:
+//   - max_size: rotate once the file reaches this many bytes (default 64MiB)
+//   - max_age: rotate once the file is this old (default 24h, 0 disables)
+//   - gzip: compress rotated segments
+//   - max_files: delete the oldest segments beyond this many (default 0 keeps all)
+func NewFileStorer(uri string, opts ...StorerOption) (Storer, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parsing file uri: %w", err)
+	}
+	path := u.Path
+	if u.Opaque != "" {
+		path = u.Opaque
+	}
+	if path == "" {
+		return nil, fmt.Errorf("file uri %q is missing a path", uri)
+	}
+	s := &fileStorer{
+		path:    path,
+		maxSize: DefaultFileMaxSize,
+		maxAge:  DefaultFileMaxAge,
+		now:     time.Now,
+	}
+	q := u.Query()
+	if v := q.Get("max_size"); v != "" {
+		if s.maxSize, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("parsing max_size: %w", err)
+		}
+	}
+	if v := q.Get("max_age"); v != "" {
+		if s.maxAge, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("parsing max_age: %w", err)
+		}
+	}
+	if v := q.Get("gzip"); v != "" {
+		if s.compress, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("parsing gzip: %w", err)
+		}
+	}
+	if v := q.Get("max_files"); v != "" {
+		if s.maxFiles, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("parsing max_files: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating directory: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	if err := s.commonStorer.init(s.saveCheckResults, opts...); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the active file for appending. It must be called with the lock held.
+func (s *fileStorer) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", s.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size, s.openedAt = f, info.Size(), s.now()
+	if s.size > 0 {
+		// Age an existing file from its first result, so restarts don't postpone rotation.
+		s.openedAt = info.ModTime()
+		if first, err := firstResultTS(s.path); err == nil {
+			s.openedAt = first
+		}
+	}
+	return nil
+}
+
+func firstResultTS(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return time.Time{}, err
+	}
+	var result struct{ TS time.Time }
+	err = json.Unmarshal(line, &result)
+	return result.TS, err
+}
+
+// SaveCheckResults saves check results.
+func (s *fileStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return s.saveCheckResults(ctx, []check.CheckResults{result})
+}
+
+// saveCheckResults appends a batch of check results with a single write.
+func (s *fileStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) error {
+	var buf strings.Builder
+	w := NewArchiveWriter(&buf)
+	for _, r := range results {
+		if err := w.Write(r); err != nil {
+			return err
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.size > 0 && (s.size+int64(buf.Len()) > s.maxSize || s.maxAge > 0 && s.now().Sub(s.openedAt) >= s.maxAge) {
+		if err := s.rotate(ctx); err != nil {
+			return fmt.Errorf("rotating %s: %w", s.path, err)
+		}
+	}
+	n, err := io.WriteString(s.f, buf.String())
+	s.size += int64(n)
+	return err
+}
+
+// rotate moves the active file to a new segment and opens a fresh file, then compresses and prunes
+// segments in the background. It must be called with the lock held.
+func (s *fileStorer) rotate(ctx context.Context) error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(s.path)
+	segment := strings.TrimSuffix(s.path, ext) + "-" + s.now().UTC().Format(segmentTimeFormat) + ext
+	if err := os.Rename(s.path, segment); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	if !s.compress && s.maxFiles <= 0 {
+		return nil
+	}
+	s.maintaining.Add(1)
+	go func() {
+		defer s.maintaining.Done()
+		if err := s.maintain(segment); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("segment", segment).Msg("maintaining rotated segments")
+		}
+	}()
+	return nil
+}
+
+// maintain compresses a newly rotated segment and deletes the oldest segments beyond max_files.
+func (s *fileStorer) maintain(segment string) error {
+	s.maintenance.Lock()
+	defer s.maintenance.Unlock()
+	if s.compress {
+		if err := gzipFile(segment); err != nil {
+			return fmt.Errorf("compressing %s: %w", segment, err)
+		}
+	}
+	if s.maxFiles > 0 {
+		segments, err := s.segments()
+		if err != nil {
+			return err
+		}
+		for len(segments) > s.maxFiles {
+			if err := os.Remove(segments[0]); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			segments = segments[1:]
+		}
+	}
+	return nil
+}
+
+// segments lists the rotated segments, oldest first.
+func (s *fileStorer) segments() ([]string, error) {
+	ext := filepath.Ext(s.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(s.path, ext) + "-*" + ext + "*")
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(matches))
+	for _, m := range matches {
+		found[m] = true
+	}
+	var out []string
+	for _, m := range matches {
+		// Skip partially compressed segments, and segments whose compressed copy is complete but
+		// which haven't been removed yet.
+		if !strings.HasSuffix(m, ".tmp") && !found[m+".gz"] {
+			out = append(out, m)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// gzipFile replaces a file with a gzipped copy.
+func gzipFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close shuts down any async savers, waits for segment maintenance, and closes the file.
+func (s *fileStorer) Close() error {
+	s.commonStorer.close()
+	s.maintaining.Wait()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.f.Close()
+}
+
+// UpdateInstance is a no-op, as each result includes its instance.
+func (s *fileStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
+	return nil
+}
+
+// AnalyzeLongestGapPerApp reports the longest interval between consecutive checks of each app, by
+// scanning the active file and all segments.
+func (s *fileStorer) AnalyzeLongestGapPerApp(
+	ctx context.Context,
+	start time.Time,
+	end time.Time,
+	apps []string,
+	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
+) error {
+	wanted := make(map[string]bool, len(apps))
+	for _, a := range apps {
+		wanted[a] = true
+	}
+	timestamps := make(map[string][]time.Time)
+	err := s.scan(ctx, func(r check.CheckResults) error {
+		if r.Instance == nil || r.Instance.AppID == "" || r.TS.Before(start) || r.TS.After(end) {
+			return nil
+		}
+		if len(apps) > 0 && !wanted[r.Instance.AppID] {
+			return nil
+		}
+		timestamps[r.Instance.AppID] = append(timestamps[r.Instance.AppID], r.TS.UTC())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	reportLongestGaps(timestamps, output)
+	return nil
+}
+
+// reportLongestGaps outputs the longest interval between consecutive timestamps of each app,
+// ordered by app ID. The timestamps are sorted in place.
+func reportLongestGaps(
+	timestamps map[string][]time.Time,
+	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
+) {
+	appIDs := make([]string, 0, len(timestamps))
+	for appID := range timestamps {
+		appIDs = append(appIDs, appID)
+	}
+	sort.Strings(appIDs)
+	for _, appID := range appIDs {
+		ts := timestamps[appID]
+		sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
+		var maxInterval time.Duration
+		var maxIntervalTS time.Time
+		for i := 1; i < len(ts); i++ {
+			if interval := ts[i].Sub(ts[i-1]); interval > maxInterval {
+				maxInterval, maxIntervalTS = interval, ts[i]
+			}
+		}
+		if maxInterval > 0 {
+			output(appID, maxInterval, maxIntervalTS)
+		}
+	}
+}
+
+// scan reads every stored result, oldest segment first. The lock is only held to snapshot the
+// segments and the active file, so saves continue during the scan; results saved after the snapshot
+// aren't read.
+func (s *fileStorer) scan(ctx context.Context, f func(check.CheckResults) error) error {
+	s.lock.Lock()
+	segments, err := s.segments()
+	if err != nil {
+		s.lock.Unlock()
+		return err
+	}
+	// The open active file keeps its contents readable if it's rotated during the scan.
+	active, err := os.Open(s.path)
+	size := s.size
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	defer active.Close()
+
+	for _, path := range segments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := scanArchiveFile(path, f); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := ReadArchive(io.NewSectionReader(active, 0, size), f); err != nil {
+		return fmt.Errorf("reading %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// scanArchiveFile reads an archive file, which may be a segment that was compressed or pruned since
+// it was listed.
+func scanArchiveFile(path string, f func(check.CheckResults) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) && !strings.HasSuffix(path, ".gz") {
+		file, err = os.Open(path + ".gz")
+	}
+	if os.IsNotExist(err) {
+		// Pruned beyond max_files.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ReadArchive(file, f)
+}
+
+// QueryResults is unsupported; the archive can only be scanned in full.
+func (s *fileStorer) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	return ResultsPage{}, ErrUnsupported
+}
+
+// QueryResultStats is unsupported; the archive can only be scanned in full.
+func (s *fileStorer) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	return ResultStats{}, ErrUnsupported
+}
+
+// ListInstances is unsupported; the archive can only be scanned in full.
+func (s *fileStorer) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	return nil, ErrUnsupported
+}
+
+// QueryMeasurements is unsupported; the archive can only be scanned in full.
+func (s *fileStorer) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	return nil, ErrUnsupported
+}
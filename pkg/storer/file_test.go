@@ -0,0 +1,109 @@
+package storer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestFileStorer(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := New(ctx, "file://"+filepath.Join(dir, "results.jsonl")+"?max_age=1h&gzip=true&max_files=2", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	fs := s.(*fileStorer)
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	fs.now = func() time.Time { return now }
+	fs.openedAt = start
+
+	a := &check.Instance{UUID: "a", AppID: "app"}
+	b := &check.Instance{UUID: "b", AppID: "other"}
+	// Each hour's results are rotated into a segment, and only the 2 newest segments are kept.
+	for i, offset := range []time.Duration{0, time.Hour, 2 * time.Hour, 2*time.Hour + 10*time.Minute, 3 * time.Hour} {
+		now = start.Add(offset)
+		for _, instance := range []*check.Instance{a, b} {
+			if err := s.SaveCheckResults(ctx, check.CheckResults{Instance: instance, TS: now}); err != nil {
+				t.Fatalf("saving result %d: %v", i, err)
+			}
+		}
+	}
+	// Segments are compressed and pruned in the background.
+	fs.maintaining.Wait()
+	segments, err := fs.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 || !strings.HasSuffix(segments[0], ".jsonl.gz") {
+		t.Fatalf("expected 2 gzipped segments, got %v", segments)
+	}
+
+	gaps := make(map[string]time.Duration)
+	err = s.AnalyzeLongestGapPerApp(ctx, start, start.Add(4*time.Hour), []string{"app"}, func(appID string, interval time.Duration, maxIntervalTS time.Time) {
+		gaps[appID] = interval
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first segment was deleted, so the retained results start at 1h.
+	if len(gaps) != 1 || gaps["app"] != time.Hour {
+		t.Errorf("expected a gap of 1h for app, got %v", gaps)
+	}
+
+	var results int
+	for _, path := range append(segments, fs.path) {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ReadArchive(f, func(r check.CheckResults) error {
+			results++
+			if r.Instance == nil {
+				t.Errorf("unexpected instance %+v", r.Instance)
+			}
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if results != 8 {
+		t.Errorf("expected 8 retained results, got %d", results)
+	}
+}
+
+func TestFileStorerSavesDuringScan(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, "file://"+filepath.Join(t.TempDir(), "results.jsonl"), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	fs := s.(*fileStorer)
+	instance := &check.Instance{UUID: "a", AppID: "app"}
+	if err := s.SaveCheckResults(ctx, check.CheckResults{Instance: instance, TS: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	// Saving from the scan callback would deadlock if the scan held the lock, and results saved after
+	// the scan started aren't read.
+	var scanned int
+	err = fs.scan(ctx, func(check.CheckResults) error {
+		scanned++
+		return s.SaveCheckResults(ctx, check.CheckResults{Instance: instance, TS: time.Now()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scanned != 1 {
+		t.Errorf("expected 1 scanned result, got %d", scanned)
+	}
+}
@@ -0,0 +1,159 @@
+package storer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+const defaultInfluxTimeout = 10 * time.Second
+
+// influxStorer writes metrics derived from results to InfluxDB using the line protocol.
+type influxStorer struct {
+	url    string
+	token  string
+	client *http.Client
+	commonStorer
+}
+
+// NewInfluxStorer creates a storer which writes each measurement and each check's success or
+// failure as points. The uri is the write endpoint with an `influx+` prefix, ex.
+// `influx+https://:token@influx.example.com/api/v2/write?org=o&bucket=b`; the password, if any, is
+// sent as the API token, and the query is passed through to InfluxDB.
+func NewInfluxStorer(uri string, opts ...StorerOption) (Storer, error) {
+	u, err := url.Parse(strings.TrimPrefix(uri, "influx+"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing influx uri: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported influx uri scheme %q", u.Scheme)
+	}
+	s := &influxStorer{client: &http.Client{Timeout: defaultInfluxTimeout}}
+	if u.User != nil {
+		s.token, _ = u.User.Password()
+		u.User = nil
+	}
+	s.url = u.String()
+	if err := s.commonStorer.init(s.saveCheckResults, opts...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveCheckResults saves check results.
+func (s *influxStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return s.saveCheckResults(ctx, []check.CheckResults{result})
+}
+
+// saveCheckResults writes the points of all results in one request.
+func (s *influxStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) error {
+	var body bytes.Buffer
+	for _, r := range results {
+		for _, p := range metricPoints(r) {
+			writeInfluxLine(&body, p)
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("content-type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// writeInfluxLine writes a point, ex. `self_check_measurement,check=database_duration value=0.25 <ns>`.
+// Counters are written as integers.
+func writeInfluxLine(b *bytes.Buffer, p metricPoint) {
+	b.WriteString(influxNameEscaper.Replace("self_check_" + p.name))
+	for _, t := range p.tags {
+		// InfluxDB rejects empty tag values.
+		if t.value == "" {
+			continue
+		}
+		b.WriteByte(',')
+		b.WriteString(influxTagEscaper.Replace(t.key))
+		b.WriteByte('=')
+		b.WriteString(influxTagEscaper.Replace(t.value))
+	}
+	b.WriteString(" value=")
+	if p.counter {
+		b.WriteString(strconv.FormatInt(int64(p.value), 10))
+		b.WriteByte('i')
+	} else {
+		b.WriteString(strconv.FormatFloat(p.value, 'f', -1, 64))
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(p.ts.UnixNano(), 10))
+	b.WriteByte('\n')
+}
+
+var (
+	influxNameEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxTagEscaper  = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// Close waits briefly for async saves to complete.
+func (s *influxStorer) Close() error {
+	s.commonStorer.close()
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// UpdateInstance is a no-op, as each result includes its instance.
+func (s *influxStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
+	return nil
+}
+
+// AnalyzeLongestGapPerApp is unsupported because metrics can't be read back.
+func (s *influxStorer) AnalyzeLongestGapPerApp(
+	ctx context.Context,
+	start time.Time,
+	end time.Time,
+	apps []string,
+	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
+) error {
+	return ErrUnsupported
+}
+
+// QueryResults is unsupported because metrics can't be read back.
+func (s *influxStorer) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	return ResultsPage{}, ErrUnsupported
+}
+
+// QueryResultStats is unsupported because metrics can't be read back.
+func (s *influxStorer) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	return ResultStats{}, ErrUnsupported
+}
+
+// ListInstances is unsupported because metrics can't be read back.
+func (s *influxStorer) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	return nil, ErrUnsupported
+}
+
+// QueryMeasurements is unsupported because metrics can't be read back.
+func (s *influxStorer) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	return nil, ErrUnsupported
+}
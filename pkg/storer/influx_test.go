@@ -0,0 +1,52 @@
+package storer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestInfluxStorer(t *testing.T) {
+	ctx := context.Background()
+	rec := &webhookReceiver{t: t, status: http.StatusNoContent}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	uri := "influx+" + strings.Replace(srv.URL, "://", "://:secret@", 1) + "/api/v2/write?org=o&bucket=b"
+	s, err := New(ctx, uri, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := time.Unix(1667260800, 0)
+	instance := &check.Instance{AppID: "app", Labels: map[string]string{"team": "a b"}}
+	err = s.(*influxStorer).saveCheckResults(ctx, []check.CheckResults{
+		{Instance: instance, TS: ts, Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.25}}},
+		{Instance: instance, TS: ts.Add(time.Minute), Errors: []check.CheckError{{Check: "database", Error: "refused"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(rec.reqs))
+	}
+	req := rec.reqs[0]
+	if req.URL.Path != "/api/v2/write" || req.URL.Query().Get("bucket") != "b" {
+		t.Errorf("unexpected url %s", req.URL)
+	}
+	if got := req.Header.Get("authorization"); got != "Token secret" {
+		t.Errorf("unexpected authorization header %q", got)
+	}
+	expected := "self_check_measurement,check=database_duration,app_id=app,team=a\\ b value=0.25 1667260800000000000\n" +
+		"self_check_success,check=database,app_id=app,team=a\\ b value=1i 1667260800000000000\n" +
+		"self_check_failure,check=database,app_id=app,team=a\\ b value=1i 1667260860000000000\n"
+	if rec.bodies[0] != expected {
+		t.Errorf("unexpected body:\n%s", rec.bodies[0])
+	}
+}
@@ -12,7 +12,11 @@ type Storer interface {
 	// SaveCheckResults saves check results.
 	SaveCheckResults(ctx context.Context, result check.CheckResults) (err error)
 
-	// AsyncQueryRetry saves check results asynchronously with retries on failure.
+	// AsyncSaveCheckResults saves check results asynchronously with retries on failure. Results
+	// which can't be saved are spooled if the storer was created with WithSpool.
+	AsyncSaveCheckResults(ctx context.Context, result check.CheckResults, attemptSchedule []time.Duration)
+
+	// AsyncQueryRetry runs a database action asynchronously with retries on failure.
 	AsyncQueryRetry(
 		ctx context.Context,
 		attemptSchedule []time.Duration,
@@ -25,4 +29,27 @@ type Storer interface {
 	Close() error
 
 	AnalyzeLongestGapPerApp(ctx context.Context, start, end time.Time, apps []string, output func(appID string, interval time.Duration, maxIntervalTS time.Time)) error
+
+	// QueryResults returns stored check results matching the filter, newest first.
+	QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error)
+
+	// ListInstances returns stored instances matching the filter, oldest first.
+	ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error)
+
+	// QueryMeasurements returns a series per instance and measurement of the check results
+	// matching the filter. The Checks filter also limits the measurements to those of the named
+	// checks, as in check.CheckResults.FilterChecks. Results aren't paged, so queries matching more
+	// than MaxMeasurementPoints points fail with ErrTooManyMeasurements.
+	QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error)
+
+	// QueryResultStats summarizes stored check results matching the filter. The Checks filter only
+	// selects cycles; it doesn't change how they are counted.
+	QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error)
+}
+
+// ResultsPage is a page of check results.
+type ResultsPage struct {
+	Results []check.CheckResults
+	// NextCursor fetches the following page. It is empty if there are no more results.
+	NextCursor string `json:",omitempty"`
 }
@@ -1,44 +1,210 @@
 package storer
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog"
 )
 
+// LogFormat selects how the LogStorer writes results.
+type LogFormat string
+
+const (
+	// LogFormatJSON writes indented JSON spanning multiple lines.
+	LogFormatJSON LogFormat = "json"
+	// LogFormatJSONL writes one compact JSON object per line.
+	LogFormatJSONL LogFormat = "jsonl"
+	// LogFormatLogfmt writes one line of logfmt key=value pairs per result.
+	LogFormatLogfmt LogFormat = "logfmt"
+	// LogFormatZerolog writes each result as a log event, using the logger from the ctx.
+	LogFormatZerolog LogFormat = "zerolog"
+)
+
+// ParseLogFormat parses a LogFormat. An empty string selects LogFormatJSON.
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch f := LogFormat(strings.ToLower(s)); f {
+	case "":
+		return LogFormatJSON, nil
+	case LogFormatJSON, LogFormatJSONL, LogFormatLogfmt, LogFormatZerolog:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q", s)
+	}
+}
+
 // LogStorer writes to logs instead of storer.
 type LogStorer struct {
-	w io.Writer
+	w      io.Writer
+	format LogFormat
+	// writeLock keeps results written by concurrent saves from interleaving.
+	writeLock sync.Mutex
 	commonStorer
 }
 
-// NewLogStorer returns a thin storer which logs JSON output to the provided writer.
-func NewLogStorer(w io.Writer) Storer {
+// LogStorerOption describes optional arguments for the LogStorer.
+type LogStorerOption func(*LogStorer)
+
+// WithLogFormat sets the output format. The default is LogFormatJSON.
+func WithLogFormat(format LogFormat) LogStorerOption {
+	return func(l *LogStorer) {
+		l.format = format
+	}
+}
+
+// NewLogStorer returns a thin storer which logs output to the provided writer.
+func NewLogStorer(w io.Writer, opts ...LogStorerOption) Storer {
 	l := &LogStorer{
-		w: w,
+		w:      w,
+		format: LogFormatJSON,
+	}
+	for _, opt := range opts {
+		opt(l)
 	}
-	l.commonStorer.init()
+	// Without options, init can't fail.
+	_ = l.commonStorer.init(l.saveCheckResults)
 	return l
 }
 
 // SaveCheckResults saves check results.
 func (l *LogStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) (err error) {
-	j := json.NewEncoder(l.w)
-	j.SetIndent("  ", "  ")
-	return j.Encode(result)
+	var buf bytes.Buffer
+	switch l.format {
+	case LogFormatJSONL:
+		err = json.NewEncoder(&buf).Encode(result)
+	case LogFormatLogfmt:
+		writeLogfmt(&buf, result)
+	case LogFormatZerolog:
+		logResult(ctx, &buf, result)
+	default:
+		j := json.NewEncoder(&buf)
+		j.SetIndent("  ", "  ")
+		err = j.Encode(result)
+	}
+	if err != nil {
+		return err
+	}
+	l.writeLock.Lock()
+	defer l.writeLock.Unlock()
+	_, err = l.w.Write(buf.Bytes())
+	return err
+}
+
+// writeLogfmt writes the result as a single logfmt line. Labels, errors and measurements are
+// flattened into prefixed keys, ex. `error.database="timeout"`. Keys can't be quoted, so spaces,
+// `=` and `"` in them are replaced with `_`.
+func writeLogfmt(buf *bytes.Buffer, result check.CheckResults) {
+	pair := func(k, v string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtKey(k))
+		buf.WriteByte('=')
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString(v)
+	}
+	pair("ts", result.TS.UTC().Format(time.RFC3339Nano))
+	if result.ID != "" {
+		pair("id", result.ID)
+	}
+	if i := result.Instance; i != nil {
+		pair("instance_uuid", i.UUID)
+		pair("app_id", i.AppID)
+		pair("hostname", i.Hostname)
+		if i.PublicIPv4 != "" {
+			pair("public_ipv4", i.PublicIPv4)
+		}
+		for _, k := range sortedLabelKeys(i.Labels) {
+			pair("label."+k, i.Labels[k])
+		}
+	}
+	pair("errors", strconv.Itoa(len(result.Errors)))
+	for _, e := range result.Errors {
+		pair("error."+e.Check, e.Error)
+	}
+	for _, m := range result.Measurements {
+		pair("measurement."+m.Check, strconv.FormatFloat(m.Value, 'g', -1, 64))
+	}
+	buf.WriteByte('\n')
 }
 
-// AsyncSaveCheckResults saves check results asynchronously with retries on failure.
-func (l *LogStorer) AsyncSaveCheckResults(ctx context.Context, result check.CheckResults, attemptSchedule []time.Duration) {
-	go l.SaveCheckResults(ctx, result)
+func logfmtKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// logResult writes the result as a log event. The ctx's logger is used so the event carries its
+// fields, but it is written to buf.
+func logResult(ctx context.Context, buf *bytes.Buffer, result check.CheckResults) {
+	ll := zerolog.Ctx(ctx)
+	var logger zerolog.Logger
+	if ll.GetLevel() == zerolog.Disabled {
+		logger = zerolog.New(buf).With().Timestamp().Logger()
+	} else {
+		logger = ll.Output(buf)
+	}
+	errs, measurements := zerolog.Dict(), zerolog.Dict()
+	for _, e := range result.Errors {
+		errs = errs.Str(e.Check, e.Error)
+	}
+	for _, m := range result.Measurements {
+		measurements = measurements.Float64(m.Check, m.Value)
+	}
+	ev := logger.Info().
+		Str("result_id", result.ID).
+		Time("ts", result.TS)
+	if i := result.Instance; i != nil {
+		ev = ev.Str("instance_uuid", i.UUID).
+			Str("app_id", i.AppID).
+			Str("hostname", i.Hostname).
+			Str("public_ipv4", i.PublicIPv4).
+			Interface("labels", i.Labels)
+	}
+	ev.Int("error_count", len(result.Errors)).
+		Dict("errors", errs).
+		Dict("measurements", measurements).
+		Msg("check results")
+}
+
+// sortedLabelKeys orders labels so output is stable.
+func sortedLabelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// saveCheckResults writes a batch of check results.
+func (l *LogStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) error {
+	for _, result := range results {
+		if err := l.SaveCheckResults(ctx, result); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Close triggers any asynchronous saves to immediately make a final attempt, waits briefly
 // for their completion, and closes database connections..
 func (l *LogStorer) Close() error {
+	l.commonStorer.close()
 	return nil
 }
 
@@ -53,6 +219,26 @@ func (l *LogStorer) AnalyzeLongestGapPerApp(
 	return nil
 }
 
+// QueryResults is unsupported because logs can't be read back.
+func (l *LogStorer) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	return ResultsPage{}, ErrUnsupported
+}
+
+// QueryResultStats is unsupported because logs can't be read back.
+func (l *LogStorer) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	return ResultStats{}, ErrUnsupported
+}
+
+// ListInstances is unsupported because logs can't be read back.
+func (l *LogStorer) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	return nil, ErrUnsupported
+}
+
+// QueryMeasurements is unsupported because logs can't be read back.
+func (l *LogStorer) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	return nil, ErrUnsupported
+}
+
 func (l *LogStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
 	return nil
 }
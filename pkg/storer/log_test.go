@@ -0,0 +1,96 @@
+package storer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog"
+)
+
+func TestLogStorerFormats(t *testing.T) {
+	result := check.CheckResults{
+		ID:           "id",
+		Instance:     &check.Instance{UUID: "a", AppID: "app", Hostname: "host", Labels: map[string]string{"region": "nyc"}},
+		TS:           time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC),
+		Errors:       []check.CheckError{{Check: "database", Error: "dial tcp: i/o timeout"}},
+		Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.25}},
+	}
+	for _, tc := range []struct {
+		format LogFormat
+		check  func(t *testing.T, out string)
+	}{
+		{LogFormatJSONL, func(t *testing.T, out string) {
+			var got check.CheckResults
+			if strings.Count(out, "\n") != 1 {
+				t.Errorf("expected a single line, got %q", out)
+			}
+			if err := json.Unmarshal([]byte(out), &got); err != nil || got.ID != "id" {
+				t.Errorf("expected the result to round trip, got %+v, %v", got, err)
+			}
+		}},
+		{LogFormatLogfmt, func(t *testing.T, out string) {
+			want := `ts=2022-11-01T00:00:00Z id=id instance_uuid=a app_id=app hostname=host label.region=nyc errors=1 error.database="dial tcp: i/o timeout" measurement.database_duration=0.25` + "\n"
+			if out != want {
+				t.Errorf("expected %q, got %q", want, out)
+			}
+		}},
+		{LogFormatZerolog, func(t *testing.T, out string) {
+			var got struct {
+				Component    string
+				InstanceUUID string `json:"instance_uuid"`
+				Errors       map[string]string
+				Measurements map[string]float64
+			}
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Component != "test" || got.InstanceUUID != "a" || got.Errors["database"] == "" || got.Measurements["database_duration"] != 0.25 {
+				t.Errorf("expected a log event with the ctx and result fields, got %s", out)
+			}
+		}},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			ctx := zerolog.New(nil).With().Str("Component", "test").Logger().WithContext(context.Background())
+			s := NewLogStorer(&buf, WithLogFormat(tc.format))
+			defer s.Close()
+			if err := s.SaveCheckResults(ctx, result); err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, buf.String())
+		})
+	}
+}
+
+func TestLogfmtKeys(t *testing.T) {
+	var buf bytes.Buffer
+	writeLogfmt(&buf, check.CheckResults{
+		TS:       time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC),
+		Instance: &check.Instance{UUID: "a", Labels: map[string]string{"team name": "x", `a="b"`: "y"}},
+		Errors:   []check.CheckError{{Check: "http get", Error: "timeout"}},
+	})
+	want := `ts=2022-11-01T00:00:00Z instance_uuid=a app_id="" hostname="" label.a__b_=y label.team_name=x errors=1 error.http_get=timeout` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestNewLogStorerFormat(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, "stderr?format=logfmt", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if f := s.(*LogStorer).format; f != LogFormatLogfmt {
+		t.Errorf("expected logfmt, got %q", f)
+	}
+	if _, err := New(ctx, "stdout?format=xml", "", false); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
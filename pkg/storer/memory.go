@@ -0,0 +1,342 @@
+package storer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+// memoryStorer keeps results in memory, for tests and local runs. Everything is lost on exit.
+type memoryStorer struct {
+	lock sync.Mutex
+	// instances are copies of the saved instances, by UUID.
+	instances map[string]*check.Instance
+	results   []memoryResult
+	// saved holds the IDs of saved results, so retried saves are skipped.
+	saved  map[string]bool
+	nextID int64
+	commonStorer
+}
+
+// memoryResult is a saved result. The result's Instance is nil; it is looked up by UUID.
+type memoryResult struct {
+	id           int64
+	instanceUUID string
+	result       check.CheckResults
+}
+
+// NewMemoryStorer creates a storer which keeps results in memory.
+func NewMemoryStorer(opts ...StorerOption) (Storer, error) {
+	s := &memoryStorer{
+		instances: make(map[string]*check.Instance),
+		saved:     make(map[string]bool),
+	}
+	if err := s.commonStorer.init(s.saveCheckResults, opts...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveCheckResults saves check results.
+func (s *memoryStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return s.saveCheckResults(ctx, []check.CheckResults{result})
+}
+
+// saveCheckResults saves a batch of check results. Results which were already saved are skipped.
+func (s *memoryStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, r := range results {
+		if r.ID != "" {
+			if s.saved[r.ID] {
+				continue
+			}
+			s.saved[r.ID] = true
+		}
+		saved := memoryResult{result: r}
+		if r.Instance != nil {
+			saved.instanceUUID = r.Instance.UUID
+			if _, ok := s.instances[saved.instanceUUID]; !ok {
+				s.instances[saved.instanceUUID] = r.Instance.Copy()
+			}
+		}
+		s.nextID++
+		saved.id = s.nextID
+		saved.result.Instance = nil
+		saved.result.Errors = append([]check.CheckError(nil), r.Errors...)
+		saved.result.Measurements = append([]check.CheckMeasurement(nil), r.Measurements...)
+		s.results = append(s.results, saved)
+	}
+	return nil
+}
+
+// UpdateInstance saves a copy of the instance.
+func (s *memoryStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
+	c := instance.Copy()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.instances[c.UUID] = c
+	return nil
+}
+
+// Close shuts down any async savers.
+func (s *memoryStorer) Close() error {
+	s.commonStorer.close()
+	return nil
+}
+
+// matches reports whether a saved result matches the filter. It must be called with the lock held.
+func (s *memoryStorer) matches(filter ResultsFilter, r memoryResult) bool {
+	instance := s.instances[r.instanceUUID]
+	if instance == nil {
+		instance = &check.Instance{}
+	}
+	if len(filter.AppIDs) > 0 && !contains(filter.AppIDs, instance.AppID) {
+		return false
+	}
+	if len(filter.InstanceUUIDs) > 0 && !contains(filter.InstanceUUIDs, r.instanceUUID) {
+		return false
+	}
+	if contains(filter.ExcludeInstanceUUIDs, r.instanceUUID) {
+		return false
+	}
+	if !hasLabels(instance, filter.Labels) {
+		return false
+	}
+	if filter.ErrorsOnly && len(r.result.Errors) == 0 {
+		return false
+	}
+	if !filter.Start.IsZero() && r.result.TS.Before(filter.Start) {
+		return false
+	}
+	if !filter.End.IsZero() && r.result.TS.After(filter.End) {
+		return false
+	}
+	if len(filter.Checks) > 0 {
+		// A check either fails and records an error, or succeeds and records its duration.
+		for _, c := range filter.Checks {
+			for _, e := range r.result.Errors {
+				if e.Check == c {
+					return true
+				}
+			}
+			for _, m := range r.result.Measurements {
+				if m.Check == c+"_duration" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLabels(instance *check.Instance, labels map[string]string) bool {
+	for k, v := range labels {
+		if actual, ok := instance.Labels[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryResults returns stored check results matching the filter, newest first.
+func (s *memoryStorer) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	after, err := parseCursor(page.Cursor)
+	if err != nil {
+		return ResultsPage{}, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var matched []memoryResult
+	for _, r := range s.results {
+		if !s.matches(filter, r) {
+			continue
+		}
+		ts := r.result.TS.UTC()
+		if after != nil && !(ts.Before(after.ts) || ts.Equal(after.ts) && r.id < after.id) {
+			continue
+		}
+		matched = append(matched, r)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].result.TS.Equal(matched[j].result.TS) {
+			return matched[i].result.TS.After(matched[j].result.TS)
+		}
+		return matched[i].id > matched[j].id
+	})
+
+	var out ResultsPage
+	limit := page.limit()
+	if len(matched) > limit {
+		matched = matched[:limit]
+		last := matched[limit-1]
+		out.NextCursor = cursor{ts: last.result.TS.UTC(), id: last.id}.String()
+	}
+	instances := make(map[string]*check.Instance)
+	for _, r := range matched {
+		res := r.result
+		res.TS = res.TS.UTC()
+		if instance, ok := s.instances[r.instanceUUID]; ok {
+			if instances[r.instanceUUID] == nil {
+				instances[r.instanceUUID] = instance.Copy()
+			}
+			res.Instance = instances[r.instanceUUID]
+		}
+		res.Errors = append([]check.CheckError(nil), res.Errors...)
+		res.Measurements = append([]check.CheckMeasurement(nil), res.Measurements...)
+		out.Results = append(out.Results, res.FilterChecks(filter.Checks))
+	}
+	return out, nil
+}
+
+// QueryResultStats summarizes stored check results matching the filter.
+func (s *memoryStorer) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var out ResultStats
+	for _, r := range s.results {
+		if !s.matches(filter, r) {
+			continue
+		}
+		out.Cycles++
+		if len(r.result.Errors) > 0 {
+			out.CyclesWithErrors++
+		}
+		if r.result.TS.After(out.LastCheck) {
+			out.LastCheck = r.result.TS.UTC()
+		}
+	}
+	return out, nil
+}
+
+// ListInstances returns stored instances matching the filter, oldest first.
+func (s *memoryStorer) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var out []*check.Instance
+	for _, instance := range s.instances {
+		if len(filter.AppIDs) > 0 && !contains(filter.AppIDs, instance.AppID) {
+			continue
+		}
+		if len(filter.UUIDs) > 0 && !contains(filter.UUIDs, instance.UUID) {
+			continue
+		}
+		if !hasLabels(instance, filter.Labels) {
+			continue
+		}
+		if !filter.Start.IsZero() && !instance.StoppedAt.IsZero() && instance.StoppedAt.Before(filter.Start) {
+			continue
+		}
+		if !filter.End.IsZero() && instance.StartedAt.After(filter.End) {
+			continue
+		}
+		out = append(out, instance.Copy())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].StartedAt.Equal(out[j].StartedAt) {
+			return out[i].StartedAt.Before(out[j].StartedAt)
+		}
+		return out[i].UUID < out[j].UUID
+	})
+	return out, nil
+}
+
+// QueryMeasurements returns a series per instance and measurement of the check results matching the
+// filter, ordered by instance UUID and measurement.
+func (s *memoryStorer) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	type seriesKey struct{ uuid, measurement string }
+	series := make(map[seriesKey]*MeasurementSeries)
+	var keys []seriesKey
+	var points int
+	for _, r := range s.results {
+		if !s.matches(filter, r) {
+			continue
+		}
+		for _, m := range r.result.Measurements {
+			if !measurementSelected(filter.Checks, m.Check) {
+				continue
+			}
+			if points++; points > maxMeasurementPoints {
+				return nil, ErrTooManyMeasurements
+			}
+			key := seriesKey{r.instanceUUID, m.Check}
+			ms, ok := series[key]
+			if !ok {
+				ms = &MeasurementSeries{Measurement: m.Check, InstanceUUID: r.instanceUUID}
+				if instance, ok := s.instances[r.instanceUUID]; ok {
+					ms.AppID = instance.AppID
+				}
+				series[key] = ms
+				keys = append(keys, key)
+			}
+			ms.Points = append(ms.Points, MeasurementPoint{TS: r.result.TS.UTC(), Value: m.Value})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].uuid != keys[j].uuid {
+			return keys[i].uuid < keys[j].uuid
+		}
+		return keys[i].measurement < keys[j].measurement
+	})
+	out := make([]MeasurementSeries, 0, len(keys))
+	for _, key := range keys {
+		ms := series[key]
+		sort.SliceStable(ms.Points, func(i, j int) bool { return ms.Points[i].TS.Before(ms.Points[j].TS) })
+		out = append(out, *ms)
+	}
+	return out, nil
+}
+
+// measurementSelected reports whether a measurement belongs to any of the checks, or checks is
+// empty.
+func measurementSelected(checks []string, measurement string) bool {
+	if len(checks) == 0 {
+		return true
+	}
+	for _, c := range checks {
+		if check.MeasurementOf(measurement, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnalyzeLongestGapPerApp reports the longest interval between consecutive checks of each app.
+func (s *memoryStorer) AnalyzeLongestGapPerApp(
+	ctx context.Context,
+	start time.Time,
+	end time.Time,
+	apps []string,
+	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
+) error {
+	s.lock.Lock()
+	timestamps := make(map[string][]time.Time)
+	for _, r := range s.results {
+		instance, ok := s.instances[r.instanceUUID]
+		if !ok || instance.AppID == "" || r.result.TS.Before(start) || r.result.TS.After(end) {
+			continue
+		}
+		if len(apps) > 0 && !contains(apps, instance.AppID) {
+			continue
+		}
+		timestamps[instance.AppID] = append(timestamps[instance.AppID], r.result.TS.UTC())
+	}
+	s.lock.Unlock()
+	reportLongestGaps(timestamps, output)
+	return nil
+}
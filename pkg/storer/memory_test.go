@@ -0,0 +1,35 @@
+package storer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestMemoryStorerIdempotentSave(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, "memory:", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	instance := &check.Instance{UUID: "a", AppID: "app", StartedAt: time.Now()}
+	r := check.CheckResults{ID: "5f1c3e56-6a35-4c0b-9f0e-1d2f0b7c1a11", Instance: instance, TS: time.Now()}
+	for i := 0; i < 2; i++ {
+		if err := s.SaveCheckResults(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stored results don't change when the caller's instance does.
+	instance.AppID = "other"
+	page, err := s.QueryResults(ctx, ResultsFilter{AppIDs: []string{"app"}}, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != r.ID {
+		t.Errorf("expected the result to be stored once, got %+v", page.Results)
+	}
+}
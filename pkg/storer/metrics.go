@@ -0,0 +1,66 @@
+package storer
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+// metricPoint is a metric derived from check results, for the storers which push metrics.
+type metricPoint struct {
+	// name is relative to the storer's prefix, ex. `measurement`.
+	name    string
+	tags    []metricTag
+	value   float64
+	counter bool
+	ts      time.Time
+}
+
+type metricTag struct {
+	key, value string
+	// label is set on tags from the instance's labels, whose keys may match the other tags.
+	label bool
+}
+
+// metricPoints converts results to a gauge per measurement, tagged with its check, and a success or
+// failure counter per check. Checks which succeeded are identified by their duration measurements.
+// Failed saves recorded by the queue aren't checks, so they're skipped. Every point is tagged with
+// the instance's app ID, hostname and labels.
+func metricPoints(result check.CheckResults) []metricPoint {
+	var tags []metricTag
+	if result.Instance != nil {
+		result.Instance.Lock()
+		tags = append(tags, metricTag{"app_id", result.Instance.AppID, false}, metricTag{"hostname", result.Instance.Hostname, false})
+		keys := make([]string, 0, len(result.Instance.Labels))
+		for k := range result.Instance.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			tags = append(tags, metricTag{k, result.Instance.Labels[k], true})
+		}
+		result.Instance.Unlock()
+	}
+	withCheck := func(name string) []metricTag {
+		return append([]metricTag{{"check", name, false}}, tags...)
+	}
+
+	points := make([]metricPoint, 0, len(result.Measurements)*2+len(result.Errors))
+	for _, m := range result.Measurements {
+		points = append(points, metricPoint{name: "measurement", tags: withCheck(m.Check), value: m.Value, ts: result.TS})
+	}
+	for _, m := range result.Measurements {
+		if name := strings.TrimSuffix(m.Check, "_duration"); name != m.Check {
+			points = append(points, metricPoint{name: "success", tags: withCheck(name), value: 1, counter: true, ts: result.TS})
+		}
+	}
+	for _, e := range result.Errors {
+		if strings.HasPrefix(e.Check, saveAttemptPrefix) {
+			continue
+		}
+		points = append(points, metricPoint{name: "failure", tags: withCheck(e.Check), value: 1, counter: true, ts: result.TS})
+	}
+	return points
+}
@@ -0,0 +1,267 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/rs/zerolog/log"
+)
+
+// migrationLockName identifies the advisory lock held while migrating.
+const migrationLockName = "self_check_schema_migrations"
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+// Migration is a versioned change to the database schema.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus describes whether a migration has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migratable is implemented by storers whose schema is managed by migrations.
+type Migratable interface {
+	Migrator() *Migrator
+}
+
+// Migrator applies the embedded migrations for a database dialect.
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+func newMigrator(db *sql.DB, d dialect) *Migrator {
+	migrations, err := loadMigrations(d.name)
+	if err != nil {
+		// The migrations are embedded, so this can only happen if they were malformed at build time.
+		panic(err)
+	}
+	return &Migrator{db: db, dialect: d, migrations: migrations}
+}
+
+// loadMigrations reads the migrations for a dialect, ordered by version. Migration files are named
+// `<version>_<name>.sql`.
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	seen := make(map[int]string)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		versionS, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>.sql", e.Name())
+		}
+		version, err := strconv.Atoi(versionS)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: parsing version: %w", e.Name(), err)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migration %s: version %d is also used by %s", e.Name(), version, other)
+		}
+		seen[version] = e.Name()
+		b, err := migrationsFS.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Migration{Version: version, Name: name, SQL: string(b)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Version < out[j].Version
+	})
+	return out, nil
+}
+
+// Status lists all known migrations and whether they have been applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return m.status(ctx, conn)
+}
+
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]MigrationStatus, error) {
+	var tables int
+	if err := conn.QueryRowContext(ctx, m.dialect.hasTable, "schema_migrations").Scan(&tables); err != nil {
+		return nil, fmt.Errorf("checking for schema_migrations: %w", err)
+	}
+	out := make([]MigrationStatus, 0, len(m.migrations))
+	if tables == 0 {
+		for _, mig := range m.migrations {
+			out = append(out, MigrationStatus{Migration: mig})
+		}
+		return out, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("querying schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt nullTime
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("reading schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt.Time
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+
+	for _, mig := range m.migrations {
+		at, ok := applied[mig.Version]
+		out = append(out, MigrationStatus{Migration: mig, Applied: ok, AppliedAt: at})
+	}
+	return out, nil
+}
+
+// Up applies any pending migrations in order, holding a lock so concurrent instances don't race. If
+// dryRun is set, the pending migrations are returned without being applied.
+func (m *Migrator) Up(ctx context.Context, dryRun bool) (applied []Migration, err error) {
+	// Advisory locks are held by a connection, so all work must happen on the same one.
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if m.dialect.lock != nil {
+		if err := m.dialect.lock(ctx, conn, migrationLockName); err != nil {
+			return nil, fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer func() {
+			if err := m.dialect.unlock(context.Background(), conn, migrationLockName); err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msg("releasing migration lock")
+			}
+		}()
+	}
+	if m.dialect.beginLocked != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.beginLocked); err != nil {
+			return nil, fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer func() {
+			if err == nil {
+				if _, err = conn.ExecContext(ctx, "COMMIT"); err == nil {
+					return
+				}
+				err = fmt.Errorf("committing migrations: %w", err)
+			}
+			if _, rbErr := conn.ExecContext(context.Background(), "ROLLBACK"); rbErr != nil {
+				log.Ctx(ctx).Warn().Err(rbErr).Msg("rolling back migrations")
+			}
+			// Every migration was applied in the rolled back transaction.
+			applied = nil
+		}()
+	}
+
+	status, err := m.status(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range status {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	if dryRun || len(pending) == 0 {
+		return pending, nil
+	}
+	if _, err := conn.ExecContext(ctx, m.dialect.createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	for i, mig := range pending {
+		if err := m.apply(ctx, conn, mig); err != nil {
+			return pending[:i], fmt.Errorf("applying migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		log.Ctx(ctx).Info().Int("version", mig.Version).Str("name", mig.Name).Msg("applied migration")
+	}
+	return pending, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) (err error) {
+	type execer interface {
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	}
+	var ex execer = conn
+	// Migrations are already in a transaction if the dialect locks with one.
+	if m.dialect.transactional && m.dialect.beginLocked == "" {
+		var tx *sql.Tx
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+		}()
+		ex = tx
+	}
+	for _, stmt := range splitStatements(mig.SQL) {
+		if _, err = ex.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	query, args, err := sq.Insert("schema_migrations").
+		Columns("version", "name", "applied_at").
+		Values(mig.Version, mig.Name, time.Now().UTC()).
+		PlaceholderFormat(m.dialect.placeholder).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = ex.ExecContext(ctx, query, args...)
+	return err
+}
+
+// splitStatements splits a migration into statements, which must each end with a semicolon at the
+// end of a line. Lines starting with `--` are comments.
+func splitStatements(script string) []string {
+	var out []string
+	var b strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			out = append(out, strings.TrimSuffix(strings.TrimSpace(b.String()), ";"))
+			b.Reset()
+		}
+	}
+	if s := strings.TrimSpace(b.String()); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
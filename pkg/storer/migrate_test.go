@@ -0,0 +1,120 @@
+package storer
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, d := range []dialect{mysqlDialect, postgresDialect, sqliteDialect} {
+		migrations, err := loadMigrations(d.name)
+		if err != nil {
+			t.Fatalf("%s: %v", d.name, err)
+		}
+		if len(migrations) == 0 || migrations[0].Version != 1 {
+			t.Errorf("%s: expected migrations starting at version 1, got %+v", d.name, migrations)
+		}
+		for i := 1; i < len(migrations); i++ {
+			if migrations[i].Version <= migrations[i-1].Version {
+				t.Errorf("%s: migrations out of order: %d after %d", d.name, migrations[i].Version, migrations[i-1].Version)
+			}
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("-- comment\nCREATE TABLE a (\n\tid INT\n);\n\nCREATE INDEX b ON a (id);\nSELECT 1")
+	want := []string{"CREATE TABLE a (\n\tid INT\n)", "CREATE INDEX b ON a (id)", "SELECT 1"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statement %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestMigratorUp(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteStorer(ctx, "sqlite:"+filepath.Join(t.TempDir(), "self-check.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	m := s.(Migratable).Migrator()
+
+	pending, err := m.Up(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(m.migrations) {
+		t.Errorf("expected all %d migrations to be pending, got %d", len(m.migrations), len(pending))
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.Applied {
+			t.Errorf("expected dry run not to apply %d_%s", s.Version, s.Name)
+		}
+	}
+
+	if _, err := m.Up(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := m.Up(ctx, false); err != nil || len(applied) != 0 {
+		t.Errorf("expected no migrations to be reapplied, got %v, %v", applied, err)
+	}
+	status, err = m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Errorf("expected %d_%s to be applied, got %+v", s.Version, s.Name, s)
+		}
+	}
+}
+
+func TestMigratorUpConcurrent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "self-check.db")
+	var migrators []*Migrator
+	for i := 0; i < 3; i++ {
+		s, err := NewSQLiteStorer(ctx, "sqlite:"+path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		migrators = append(migrators, s.(Migratable).Migrator())
+	}
+
+	// Each migration is applied by exactly one migrator; the others see it applied once they get
+	// the lock.
+	var wg sync.WaitGroup
+	applied := make([]int, len(migrators))
+	for i, m := range migrators {
+		i, m := i, m
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := m.Up(ctx, false)
+			if err != nil {
+				t.Error(err)
+			}
+			applied[i] = len(done)
+		}()
+	}
+	wg.Wait()
+	var total int
+	for _, n := range applied {
+		total += n
+	}
+	if total != len(migrators[0].migrations) {
+		t.Errorf("expected each migration to be applied once, got %v", applied)
+	}
+}
@@ -0,0 +1,49 @@
+-- The initial schema. Tables may already exist if they were created before migrations were tracked.
+CREATE TABLE IF NOT EXISTS instances (
+	id INT NOT NULL AUTO_INCREMENT,
+	uuid CHAR(36) NOT NULL,
+	hostname VARCHAR(64) NOT NULL,
+	app_id CHAR(36) NULL,
+	public_ipv4 CHAR(15) NULL,
+	started_at TIMESTAMP(6) NOT NULL,
+	stopped_at TIMESTAMP(6) NULL,
+	KEY app_id (app_id),
+	KEY public_ipv4 (public_ipv4),
+	KEY hostname (hostname),
+	KEY started_at (started_at),
+	KEY stopped_at (stopped_at),
+	UNIQUE KEY uuid (uuid),
+	PRIMARY KEY(id)
+);
+
+CREATE TABLE IF NOT EXISTS checks (
+	id INT NOT NULL AUTO_INCREMENT,
+	instance_id INT NOT NULL,
+	ts TIMESTAMP(6) NOT NULL,
+	KEY app_id_ts (instance_id, ts),
+	PRIMARY KEY(id)
+);
+
+CREATE TABLE IF NOT EXISTS check_errors (
+	check_id INT NOT NULL,
+	check_name VARCHAR(64) NOT NULL,
+	error VARCHAR(512) NOT NULL,
+	KEY check_name (check_name),
+	PRIMARY KEY(check_id, check_name)
+);
+
+CREATE TABLE IF NOT EXISTS check_measurements (
+	check_id INT NOT NULL,
+	measurement VARCHAR(64) NOT NULL,
+	value DOUBLE NOT NULL,
+	KEY measurement (measurement),
+	PRIMARY KEY(check_id, measurement)
+);
+
+CREATE TABLE IF NOT EXISTS instance_labels (
+	instance_id INT NOT NULL,
+	k VARCHAR(64) NOT NULL,
+	v VARCHAR(64) NOT NULL,
+	KEY kv (k, v),
+	PRIMARY KEY(instance_id, k)
+);
@@ -0,0 +1,62 @@
+-- Aggregates of raw check results which are retained after the raw rows are deleted.
+CREATE TABLE IF NOT EXISTS measurement_rollups (
+	resolution VARCHAR(8) NOT NULL,
+	app_id VARCHAR(36) NOT NULL,
+	bucket_start TIMESTAMP(6) NOT NULL,
+	measurement VARCHAR(64) NOT NULL,
+	samples INT NOT NULL,
+	min_value DOUBLE NOT NULL,
+	max_value DOUBLE NOT NULL,
+	avg_value DOUBLE NOT NULL,
+	p50 DOUBLE NOT NULL,
+	p90 DOUBLE NOT NULL,
+	p99 DOUBLE NOT NULL,
+	KEY bucket_start (bucket_start),
+	PRIMARY KEY(resolution, app_id, bucket_start, measurement)
+);
+
+CREATE TABLE IF NOT EXISTS error_rollups (
+	resolution VARCHAR(8) NOT NULL,
+	app_id VARCHAR(36) NOT NULL,
+	bucket_start TIMESTAMP(6) NOT NULL,
+	check_name VARCHAR(64) NOT NULL,
+	error_count INT NOT NULL,
+	KEY bucket_start (bucket_start),
+	PRIMARY KEY(resolution, app_id, bucket_start, check_name)
+);
+
+-- The longest interval between checks within each bucket. Intervals spanning buckets are derived
+-- from first_ts and last_ts of adjacent buckets.
+CREATE TABLE IF NOT EXISTS gap_rollups (
+	resolution VARCHAR(8) NOT NULL,
+	app_id VARCHAR(36) NOT NULL,
+	bucket_start TIMESTAMP(6) NOT NULL,
+	cycles INT NOT NULL,
+	first_ts TIMESTAMP(6) NOT NULL,
+	last_ts TIMESTAMP(6) NOT NULL,
+	max_gap_ms BIGINT NOT NULL,
+	max_gap_ts TIMESTAMP(6) NOT NULL,
+	KEY bucket_start (bucket_start),
+	PRIMARY KEY(resolution, app_id, bucket_start)
+);
+
+-- Days which have been rolled up, so that their raw rows can be deleted across several runs. Only
+-- checks with ids up to max_check_id were included; later ones arrived late and must be merged.
+CREATE TABLE IF NOT EXISTS rollup_days (
+	day TIMESTAMP(6) NOT NULL,
+	rolled_up_at TIMESTAMP(6) NOT NULL,
+	max_check_id BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY(day)
+);
+
+-- MySQL has no CREATE INDEX IF NOT EXISTS, and DDL isn't transactional, so check for the index
+-- in case an earlier attempt at this migration failed after creating it.
+SET @create_ts_index = IF(
+	(SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = 'checks' AND index_name = 'ts') = 0,
+	'CREATE INDEX ts ON checks (ts)',
+	'DO 0'
+);
+PREPARE create_ts_index FROM @create_ts_index;
+EXECUTE create_ts_index;
+DEALLOCATE PREPARE create_ts_index;
@@ -0,0 +1,12 @@
+-- Results carry a client generated UUID so retried saves don't insert duplicates. Older rows have
+-- no UUID. The column and its unique key are added by a single ALTER, which is skipped if an
+-- earlier attempt at this migration already applied it.
+SET @add_uuid = IF(
+	(SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = 'checks' AND column_name = 'uuid') = 0,
+	'ALTER TABLE checks ADD COLUMN uuid CHAR(36) NULL, ADD UNIQUE KEY uuid (uuid)',
+	'DO 0'
+);
+PREPARE add_uuid FROM @add_uuid;
+EXECUTE add_uuid;
+DEALLOCATE PREPARE add_uuid;
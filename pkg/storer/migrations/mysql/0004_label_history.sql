@@ -0,0 +1,12 @@
+-- Records each change to an instance's labels. A NULL old_v means the label was added, and a NULL
+-- new_v that it was removed.
+CREATE TABLE IF NOT EXISTS instance_label_history (
+	id INT NOT NULL AUTO_INCREMENT,
+	instance_id INT NOT NULL,
+	k VARCHAR(64) NOT NULL,
+	old_v VARCHAR(64) NULL,
+	new_v VARCHAR(64) NULL,
+	changed_at TIMESTAMP(6) NOT NULL,
+	KEY instance_id_changed_at (instance_id, changed_at),
+	PRIMARY KEY(id)
+);
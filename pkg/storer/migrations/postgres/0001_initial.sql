@@ -0,0 +1,46 @@
+-- The initial schema. Tables may already exist if they were created before migrations were tracked.
+CREATE TABLE IF NOT EXISTS instances (
+	id SERIAL PRIMARY KEY,
+	uuid CHAR(36) NOT NULL UNIQUE,
+	hostname VARCHAR(64) NOT NULL,
+	app_id VARCHAR(36) NULL,
+	public_ipv4 VARCHAR(15) NULL,
+	started_at TIMESTAMPTZ(6) NOT NULL,
+	stopped_at TIMESTAMPTZ(6) NULL
+);
+CREATE INDEX IF NOT EXISTS instances_app_id ON instances (app_id);
+CREATE INDEX IF NOT EXISTS instances_public_ipv4 ON instances (public_ipv4);
+CREATE INDEX IF NOT EXISTS instances_hostname ON instances (hostname);
+CREATE INDEX IF NOT EXISTS instances_started_at ON instances (started_at);
+CREATE INDEX IF NOT EXISTS instances_stopped_at ON instances (stopped_at);
+
+CREATE TABLE IF NOT EXISTS checks (
+	id SERIAL PRIMARY KEY,
+	instance_id INT NOT NULL,
+	ts TIMESTAMPTZ(6) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS checks_instance_id_ts ON checks (instance_id, ts);
+
+CREATE TABLE IF NOT EXISTS check_errors (
+	check_id INT NOT NULL,
+	check_name VARCHAR(64) NOT NULL,
+	error VARCHAR(512) NOT NULL,
+	PRIMARY KEY (check_id, check_name)
+);
+CREATE INDEX IF NOT EXISTS check_errors_check_name ON check_errors (check_name);
+
+CREATE TABLE IF NOT EXISTS check_measurements (
+	check_id INT NOT NULL,
+	measurement VARCHAR(64) NOT NULL,
+	value DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (check_id, measurement)
+);
+CREATE INDEX IF NOT EXISTS check_measurements_measurement ON check_measurements (measurement);
+
+CREATE TABLE IF NOT EXISTS instance_labels (
+	instance_id INT NOT NULL,
+	k VARCHAR(64) NOT NULL,
+	v VARCHAR(64) NOT NULL,
+	PRIMARY KEY (instance_id, k)
+);
+CREATE INDEX IF NOT EXISTS instance_labels_kv ON instance_labels (k, v);
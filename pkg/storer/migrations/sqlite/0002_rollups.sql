@@ -0,0 +1,52 @@
+-- Aggregates of raw check results which are retained after the raw rows are deleted.
+CREATE TABLE IF NOT EXISTS measurement_rollups (
+	resolution VARCHAR(8) NOT NULL,
+	app_id VARCHAR(36) NOT NULL,
+	bucket_start TIMESTAMP NOT NULL,
+	measurement VARCHAR(64) NOT NULL,
+	samples INTEGER NOT NULL,
+	min_value DOUBLE NOT NULL,
+	max_value DOUBLE NOT NULL,
+	avg_value DOUBLE NOT NULL,
+	p50 DOUBLE NOT NULL,
+	p90 DOUBLE NOT NULL,
+	p99 DOUBLE NOT NULL,
+	PRIMARY KEY (resolution, app_id, bucket_start, measurement)
+);
+CREATE INDEX IF NOT EXISTS measurement_rollups_bucket_start ON measurement_rollups (bucket_start);
+
+CREATE TABLE IF NOT EXISTS error_rollups (
+	resolution VARCHAR(8) NOT NULL,
+	app_id VARCHAR(36) NOT NULL,
+	bucket_start TIMESTAMP NOT NULL,
+	check_name VARCHAR(64) NOT NULL,
+	error_count INTEGER NOT NULL,
+	PRIMARY KEY (resolution, app_id, bucket_start, check_name)
+);
+CREATE INDEX IF NOT EXISTS error_rollups_bucket_start ON error_rollups (bucket_start);
+
+-- The longest interval between checks within each bucket. Intervals spanning buckets are derived
+-- from first_ts and last_ts of adjacent buckets.
+CREATE TABLE IF NOT EXISTS gap_rollups (
+	resolution VARCHAR(8) NOT NULL,
+	app_id VARCHAR(36) NOT NULL,
+	bucket_start TIMESTAMP NOT NULL,
+	cycles INTEGER NOT NULL,
+	first_ts TIMESTAMP NOT NULL,
+	last_ts TIMESTAMP NOT NULL,
+	max_gap_ms BIGINT NOT NULL,
+	max_gap_ts TIMESTAMP NOT NULL,
+	PRIMARY KEY (resolution, app_id, bucket_start)
+);
+CREATE INDEX IF NOT EXISTS gap_rollups_bucket_start ON gap_rollups (bucket_start);
+
+-- Days which have been rolled up, so that their raw rows can be deleted across several runs. Only
+-- checks with ids up to max_check_id were included; later ones arrived late and must be merged.
+CREATE TABLE IF NOT EXISTS rollup_days (
+	day TIMESTAMP NOT NULL,
+	rolled_up_at TIMESTAMP NOT NULL,
+	max_check_id INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (day)
+);
+
+CREATE INDEX IF NOT EXISTS checks_ts ON checks (ts);
@@ -0,0 +1,4 @@
+-- Results carry a client generated UUID so retried saves don't insert duplicates. Older rows have
+-- no UUID.
+ALTER TABLE checks ADD COLUMN uuid CHAR(36) NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS checks_uuid ON checks (uuid);
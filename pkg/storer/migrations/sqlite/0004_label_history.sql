@@ -0,0 +1,11 @@
+-- Records each change to an instance's labels. A NULL old_v means the label was added, and a NULL
+-- new_v that it was removed.
+CREATE TABLE IF NOT EXISTS instance_label_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	instance_id INTEGER NOT NULL,
+	k VARCHAR(64) NOT NULL,
+	old_v VARCHAR(64) NULL,
+	new_v VARCHAR(64) NULL,
+	changed_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS instance_label_history_instance_id_changed_at ON instance_label_history (instance_id, changed_at);
@@ -62,6 +62,18 @@ func (mr *MockStorerMockRecorder) AsyncQueryRetry(ctx, attemptSchedule, f interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AsyncQueryRetry", reflect.TypeOf((*MockStorer)(nil).AsyncQueryRetry), ctx, attemptSchedule, f)
 }
 
+// AsyncSaveCheckResults mocks base method.
+func (m *MockStorer) AsyncSaveCheckResults(ctx context.Context, result check.CheckResults, attemptSchedule []time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "AsyncSaveCheckResults", ctx, result, attemptSchedule)
+}
+
+// AsyncSaveCheckResults indicates an expected call of AsyncSaveCheckResults.
+func (mr *MockStorerMockRecorder) AsyncSaveCheckResults(ctx, result, attemptSchedule interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AsyncSaveCheckResults", reflect.TypeOf((*MockStorer)(nil).AsyncSaveCheckResults), ctx, result, attemptSchedule)
+}
+
 // Close mocks base method.
 func (m *MockStorer) Close() error {
 	m.ctrl.T.Helper()
@@ -76,6 +88,66 @@ func (mr *MockStorerMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStorer)(nil).Close))
 }
 
+// ListInstances mocks base method.
+func (m *MockStorer) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListInstances", ctx, filter)
+	ret0, _ := ret[0].([]*check.Instance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListInstances indicates an expected call of ListInstances.
+func (mr *MockStorerMockRecorder) ListInstances(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListInstances", reflect.TypeOf((*MockStorer)(nil).ListInstances), ctx, filter)
+}
+
+// QueryMeasurements mocks base method.
+func (m *MockStorer) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryMeasurements", ctx, filter)
+	ret0, _ := ret[0].([]MeasurementSeries)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryMeasurements indicates an expected call of QueryMeasurements.
+func (mr *MockStorerMockRecorder) QueryMeasurements(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryMeasurements", reflect.TypeOf((*MockStorer)(nil).QueryMeasurements), ctx, filter)
+}
+
+// QueryResultStats mocks base method.
+func (m *MockStorer) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryResultStats", ctx, filter)
+	ret0, _ := ret[0].(ResultStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryResultStats indicates an expected call of QueryResultStats.
+func (mr *MockStorerMockRecorder) QueryResultStats(ctx, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryResultStats", reflect.TypeOf((*MockStorer)(nil).QueryResultStats), ctx, filter)
+}
+
+// QueryResults mocks base method.
+func (m *MockStorer) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryResults", ctx, filter, page)
+	ret0, _ := ret[0].(ResultsPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryResults indicates an expected call of QueryResults.
+func (mr *MockStorerMockRecorder) QueryResults(ctx, filter, page interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryResults", reflect.TypeOf((*MockStorer)(nil).QueryResults), ctx, filter, page)
+}
+
 // SaveCheckResults mocks base method.
 func (m *MockStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
 	m.ctrl.T.Helper()
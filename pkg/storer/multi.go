@@ -0,0 +1,198 @@
+package storer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog/log"
+)
+
+// multiStorer writes results to several backends. Each backend has its own queue and spool, so a
+// failing backend doesn't hold up the others. Reads go to the primary, the first backend.
+type multiStorer struct {
+	backends []Storer
+	// names identify backends in logs and errors without leaking credentials.
+	names []string
+}
+
+// NewMultiStorer creates a storer which writes to each of the uris, reading from the first. The
+// spool directory, if any, gets a subdirectory per backend. The CA cert is used by every MySQL and
+// PostgreSQL backend, so they must share a CA; a PostgreSQL backend with a different CA can set
+// `sslrootcert` in its uri instead.
+func NewMultiStorer(ctx context.Context, uris []string, cert string, createTables bool, opts ...StorerOption) (Storer, error) {
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("no storer uris")
+	}
+	m := &multiStorer{}
+	for i, uri := range uris {
+		name := strconv.Itoa(i) + ":" + backendName(uri)
+		s, err := New(ctx, uri, cert, createTables, append(opts[:len(opts):len(opts)], withSpoolSubdir(strconv.Itoa(i)))...)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("creating storer %s: %w", name, err)
+		}
+		m.backends = append(m.backends, s)
+		m.names = append(m.names, name)
+	}
+	return m, nil
+}
+
+// backendName returns the scheme of a uri, or the log destination.
+func backendName(uri string) string {
+	name, _, _ := strings.Cut(uri, ":")
+	name, _, _ = strings.Cut(name, "?")
+	return strings.ToLower(name)
+}
+
+func withSpoolSubdir(dir string) StorerOption {
+	return func(o *storerOptions) {
+		if o.spoolDir != "" {
+			o.spoolDir = filepath.Join(o.spoolDir, dir)
+		}
+	}
+}
+
+// Primary returns the storer used for reads: the primary backend of a multi storer, otherwise s.
+// Use it to check for optional interfaces such as Migratable and Retainer.
+func Primary(s Storer) Storer {
+	if m, ok := s.(*multiStorer); ok {
+		return m.backends[0]
+	}
+	return s
+}
+
+// each runs f on every backend, returning an error if any failed.
+func (m *multiStorer) each(ctx context.Context, action string, f func(Storer) error) error {
+	var failed []string
+	var first error
+	for i, s := range m.backends {
+		if err := f(s); err != nil {
+			log.Ctx(ctx).Err(err).Str("storer", m.names[i]).Msg(action)
+			failed = append(failed, m.names[i])
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("%s failed for storers %s: %w", action, strings.Join(failed, ", "), first)
+	}
+	return nil
+}
+
+// SaveCheckResults saves check results to every backend.
+func (m *multiStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return m.each(ctx, "saving check results", func(s Storer) error {
+		return s.SaveCheckResults(ctx, result)
+	})
+}
+
+// AsyncSaveCheckResults queues the results on every backend, each retrying independently.
+func (m *multiStorer) AsyncSaveCheckResults(ctx context.Context, result check.CheckResults, attemptSchedule []time.Duration) {
+	for _, s := range m.backends {
+		r := result
+		// Each backend records its failed attempts in Errors, so they mustn't share the slice.
+		r.Errors = append([]check.CheckError(nil), result.Errors...)
+		s.AsyncSaveCheckResults(ctx, r, attemptSchedule)
+	}
+}
+
+// AsyncQueryRetry runs the action on the primary's queue.
+func (m *multiStorer) AsyncQueryRetry(
+	ctx context.Context,
+	attemptSchedule []time.Duration,
+	f func(ctx context.Context, attempt int) error,
+) {
+	m.backends[0].AsyncQueryRetry(ctx, attemptSchedule, f)
+}
+
+// UpdateInstance updates the instance in every backend.
+func (m *multiStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
+	return m.each(ctx, "updating instance", func(s Storer) error {
+		return s.UpdateInstance(ctx, instance)
+	})
+}
+
+// Close closes every backend.
+func (m *multiStorer) Close() error {
+	return m.each(context.Background(), "closing storer", func(s Storer) error {
+		return s.Close()
+	})
+}
+
+// AnalyzeLongestGapPerApp analyzes the primary's results.
+func (m *multiStorer) AnalyzeLongestGapPerApp(
+	ctx context.Context,
+	start time.Time,
+	end time.Time,
+	apps []string,
+	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
+) error {
+	return m.backends[0].AnalyzeLongestGapPerApp(ctx, start, end, apps, output)
+}
+
+// QueryResults queries the primary's results.
+func (m *multiStorer) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	return m.backends[0].QueryResults(ctx, filter, page)
+}
+
+// ListInstances lists the primary's instances.
+func (m *multiStorer) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	return m.backends[0].ListInstances(ctx, filter)
+}
+
+// QueryMeasurements queries the primary's measurements.
+func (m *multiStorer) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	return m.backends[0].QueryMeasurements(ctx, filter)
+}
+
+// QueryResultStats summarizes the primary's results.
+func (m *multiStorer) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	return m.backends[0].QueryResultStats(ctx, filter)
+}
+
+// QueueStats sums the queue stats of every backend. Policy is the primary's.
+func (m *multiStorer) QueueStats() QueueStats {
+	var stats QueueStats
+	for _, s := range m.backends {
+		q, ok := s.(Queuer)
+		if !ok {
+			continue
+		}
+		qs := q.QueueStats()
+		if stats.Policy == "" {
+			stats.Policy = qs.Policy
+		}
+		stats.Depth += qs.Depth
+		stats.Capacity += qs.Capacity
+		stats.InFlight += qs.InFlight
+		stats.Dropped += qs.Dropped
+	}
+	return stats
+}
+
+// SpoolStats sums the spool stats of every backend with a spool.
+func (m *multiStorer) SpoolStats() (SpoolStats, bool) {
+	var stats SpoolStats
+	var any bool
+	for _, s := range m.backends {
+		sp, ok := s.(Spooler)
+		if !ok {
+			continue
+		}
+		ss, ok := sp.SpoolStats()
+		if !ok {
+			continue
+		}
+		any = true
+		stats.Pending += ss.Pending
+		stats.Bytes += ss.Bytes
+		stats.Dropped += ss.Dropped
+	}
+	return stats, any
+}
@@ -0,0 +1,103 @@
+package storer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestMultiStorer(t *testing.T) {
+	ctx := context.Background()
+	failing := &webhookReceiver{t: t, status: http.StatusInternalServerError}
+	srv := httptest.NewServer(failing)
+	defer srv.Close()
+	dir := t.TempDir()
+
+	uris := "sqlite:" + filepath.Join(dir, "self-check.db") + " " + srv.URL + " file:" + filepath.Join(dir, "results.jsonl")
+	s, err := New(ctx, uris, "", true, WithSpool(filepath.Join(dir, "spool"), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := Primary(s).(*sqliteStorer); !ok {
+		t.Errorf("expected the sqlite storer to be primary, got %T", Primary(s))
+	}
+
+	instance := &check.Instance{UUID: "a", AppID: "app", StartedAt: time.Now()}
+	if err := s.UpdateInstance(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+	// The webhook failure is reported, but doesn't stop the other backends saving the result.
+	err = s.SaveCheckResults(ctx, check.CheckResults{ID: "1", Instance: instance, TS: time.Now()})
+	if err == nil || !strings.Contains(err.Error(), "1:http") {
+		t.Fatalf("expected the webhook to fail, got %v", err)
+	}
+	page, err := s.QueryResults(ctx, ResultsFilter{}, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 {
+		t.Errorf("expected 1 result in the primary, got %d", len(page.Results))
+	}
+	var archived int
+	if err := scanArchiveFile(filepath.Join(dir, "results.jsonl"), func(check.CheckResults) error {
+		archived++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if archived != 1 {
+		t.Errorf("expected 1 result in the file, got %d", archived)
+	}
+
+	if _, ok := s.(Spooler).SpoolStats(); !ok {
+		t.Error("expected spool stats")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "spool", "1")); err != nil {
+		t.Errorf("expected a spool directory per backend: %v", err)
+	}
+	if stats := s.(Queuer).QueueStats(); stats.Capacity != 3*DefaultQueueDepth {
+		t.Errorf("expected a queue per backend, got capacity %d", stats.Capacity)
+	}
+}
+
+func TestMultiStorerInstanceIDs(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db")
+	// Give the instance a different id in each database.
+	other, err := NewSQLiteStorer(ctx, "sqlite:"+b, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.UpdateInstance(ctx, &check.Instance{UUID: "other", StartedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+
+	s, err := New(ctx, "sqlite:"+a+" sqlite:"+b, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	instance := &check.Instance{UUID: "a", AppID: "app", StartedAt: time.Now()}
+	if err := s.SaveCheckResults(ctx, check.CheckResults{ID: "1", Instance: instance, TS: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	for i, backend := range s.(*multiStorer).backends {
+		page, err := backend.QueryResults(ctx, ResultsFilter{AppIDs: []string{"app"}}, Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Results) != 1 || page.Results[0].Instance.UUID != "a" {
+			t.Errorf("expected the result of instance a in backend %d, got %+v", i, page.Results)
+		}
+	}
+}
@@ -9,8 +9,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/digitalocean/apps-self-check/pkg/types/check"
 
@@ -32,11 +30,12 @@ var mysqlConfigID = 1
 type mysqlStorer struct {
 	db *sql.DB
 	commonStorer
-	instanceLock sync.Mutex
+	sqlReader
+	instances instanceIDs
 }
 
 // NewMySQLStorer creates a new storer driver for a MySQL backend.
-func NewMySQLStorer(ctx context.Context, uri, cert string, createTables bool) (Storer, error) {
+func NewMySQLStorer(ctx context.Context, uri, cert string, createTables bool, opts ...StorerOption) (Storer, error) {
 	u, err := dburl.Parse(uri)
 	if err != nil {
 		return nil, err
@@ -54,6 +53,8 @@ func NewMySQLStorer(ctx context.Context, uri, cert string, createTables bool) (S
 		q.Add("tls", tlsMode)
 	}
 	q.Add("parseTime", "true")
+	// Idempotent saves rely on rows affected being zero for duplicates.
+	q.Del("clientFoundRows")
 	if !q.Has("timeout") {
 		q.Add("timeout", defaultConnectTimeout)
 	}
@@ -77,9 +78,10 @@ func NewMySQLStorer(ctx context.Context, uri, cert string, createTables bool) (S
 		return nil, err
 	}
 	m := &mysqlStorer{
-		db: db,
+		db:        db,
+		sqlReader: sqlReader{db: db, dialect: mysqlDialect},
 	}
-	if err := m.init(ctx, createTables); err != nil {
+	if err := m.init(ctx, createTables, opts); err != nil {
 		m.Close()
 		return nil, err
 	}
@@ -87,9 +89,16 @@ func NewMySQLStorer(ctx context.Context, uri, cert string, createTables bool) (S
 }
 
 // SaveCheckResults saves check results.
-func (m *mysqlStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) (err error) {
-	if err := m.ensureInstance(ctx, result.Instance); err != nil {
-		return fmt.Errorf("ensuring instance: %w", err)
+func (m *mysqlStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return m.saveCheckResults(ctx, []check.CheckResults{result})
+}
+
+// saveCheckResults saves a batch of check results in a single transaction. Results which were
+// already saved are skipped.
+func (m *mysqlStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) (err error) {
+	instanceIDs, err := m.instances.ensure(ctx, results, m.insertInstance)
+	if err != nil {
+		return err
 	}
 	tx, err := m.db.BeginTx(ctx, &sql.TxOptions{
 		Isolation: sql.LevelDefault,
@@ -105,48 +114,37 @@ func (m *mysqlStorer) SaveCheckResults(ctx context.Context, result check.CheckRe
 		}
 	}()
 
-	r, err := sq.Insert("checks").Columns("instance_id", "ts").
-		Values(result.Instance.DatabaseID, result.TS).RunWith(tx).ExecContext(ctx)
-	if err != nil {
-		return fmt.Errorf("storing check result: %w", err)
-	}
-	id, err := r.LastInsertId()
-	if err != nil {
-		return err
-	}
-
-	if len(result.Errors) > 0 {
-		q := sq.Insert("check_errors").Columns("check_id", "check_name", "error")
-		for _, e := range result.Errors {
-			errStr := e.Error
-			if len(errStr) > 512 { // Truncate to fit column.
-				errStr = errStr[:512]
-			}
-			q = q.Values(id, e.Check, errStr)
+	for i, result := range results {
+		// Results saved by an earlier attempt whose acknowledgement was lost are left as they are.
+		// The update changes nothing, so no rows are affected and their details aren't saved again.
+		var r sql.Result
+		r, err = sq.Insert("checks").Columns("uuid", "instance_id", "ts").
+			Values(resultID(result), instanceIDs[i], result.TS).
+			Suffix("ON DUPLICATE KEY UPDATE id = id").RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf("storing check result: %w", err)
 		}
-		if _, err = q.RunWith(tx).ExecContext(ctx); err != nil {
-			return fmt.Errorf("storing errors: %w", err)
+		var inserted int64
+		if inserted, err = r.RowsAffected(); err != nil {
+			return err
 		}
-	}
-	if len(result.Measurements) > 0 {
-		q := sq.Insert("check_measurements").Columns("check_id", "measurement", "value")
-		for _, e := range result.Measurements {
-			q = q.Values(id, e.Check, e.Value)
+		if inserted == 0 {
+			continue
+		}
+		var id int64
+		if id, err = r.LastInsertId(); err != nil {
+			return err
 		}
-		if _, err = q.RunWith(tx).ExecContext(ctx); err != nil {
-			return fmt.Errorf("storing measurements: %w", err)
+		if err = saveCheckDetails(ctx, tx, sq.Question, id, result); err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
 // Close shuts down the database handle and any async savers.
 func (m *mysqlStorer) Close() error {
-	m.closeOnce.Do(func() {
-		close(m.done)
-	})
-	m.wg.Wait()
+	m.commonStorer.close()
 	return m.db.Close()
 }
 
@@ -172,243 +170,92 @@ func RegisterMySQLCertificate(cert string) (string, error) {
 	return mysqlConfigName, nil
 }
 
-func (m *mysqlStorer) init(ctx context.Context, createTables bool) error {
-	m.commonStorer.init()
+func (m *mysqlStorer) init(ctx context.Context, createTables bool, opts []StorerOption) error {
+	if err := m.commonStorer.init(m.saveCheckResults, opts...); err != nil {
+		return err
+	}
 	if !createTables {
 		return nil
 	}
+	_, err := m.Migrator().Up(ctx, false)
+	return err
+}
 
-	exists, err := m.tableExists(ctx, "instance")
-	if err != nil {
-		return err
-	}
-	if !exists {
-		// We keep the "IF NOT EXISTS" because there may be other instances creating these tables.
-		_, err := m.db.ExecContext(ctx, `
-			CREATE TABLE IF NOT EXISTS instances (
-				id INT NOT NULL AUTO_INCREMENT,
-				uuid CHAR(36) NOT NULL,
-				hostname VARCHAR(64) NOT NULL,
-				app_id CHAR(36) NULL,
-				public_ipv4 CHAR(15) NULL,
-				started_at TIMESTAMP(6) NOT NULL,
-				stopped_at TIMESTAMP(6) NULL,
-				KEY app_id (app_id),
-				KEY public_ipv4 (public_ipv4),
-				KEY hostname (hostname),
-				KEY started_at (started_at),
-				KEY stopped_at (stopped_at),
-				UNIQUE KEY uuid (uuid),
-				PRIMARY KEY(id)
-			)
-		`)
-		if err != nil {
-			return err
-		}
-	}
+// Migrator manages the database schema.
+func (m *mysqlStorer) Migrator() *Migrator {
+	return newMigrator(m.db, m.dialect)
+}
 
-	exists, err = m.tableExists(ctx, "checks")
+func (m *mysqlStorer) updateInstance(ctx context.Context, instance *check.Instance) (id int64, err error) {
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("starting transaction: %w", err)
 	}
-	if !exists {
-		// We keep the "IF NOT EXISTS" because there may be other instances creating these tables.
-		_, err := m.db.ExecContext(ctx, `
-			CREATE TABLE IF NOT EXISTS checks (
-				id INT NOT NULL AUTO_INCREMENT,
-				instance_id INT NOT NULL,
-				ts TIMESTAMP(6) NOT NULL,
-				KEY app_id_ts (instance_id, ts),
-				PRIMARY KEY(id)
-			)
-		`)
+	defer func() {
 		if err != nil {
-			return err
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
 		}
-	}
+	}()
 
-	exists, err = m.tableExists(ctx, "check_errors")
+	r, err := instanceInsert(instance).Suffix(`
+	ON DUPLICATE KEY UPDATE
+	id = LAST_INSERT_ID(id),
+	uuid = VALUES(uuid),
+	app_id = VALUES(app_id),
+	hostname = VALUES(hostname),
+	public_ipv4 = VALUES(public_ipv4),
+	started_at = VALUES(started_at),
+	stopped_at = VALUES(stopped_at)
+	`).RunWith(tx).ExecContext(ctx)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("updating instance record: %w", err)
 	}
-	if !exists {
-		_, err = m.db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS check_errors (
-			check_id INT NOT NULL,
-			check_name VARCHAR(64) NOT NULL,
-			error VARCHAR(512) NOT NULL,
-			KEY check_name (check_name),
-			PRIMARY KEY(check_id, check_name)
-		)
-	`)
-		if err != nil {
-			return err
-		}
+	if id, err = r.LastInsertId(); err != nil {
+		return 0, fmt.Errorf("reading instance id: %w", err)
 	}
-
-	exists, err = m.tableExists(ctx, "check_measurements")
+	err = syncLabels(ctx, tx, sq.Question, `ON DUPLICATE KEY UPDATE v = VALUES(v)`, id, instanceLabels(instance))
 	if err != nil {
-		return err
-	}
-	if !exists {
-		_, err = m.db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS check_measurements (
-			check_id INT NOT NULL,
-			measurement VARCHAR(64) NOT NULL,
-			value DOUBLE NOT NULL,
-			KEY measurement (measurement),
-			PRIMARY KEY(check_id, measurement)
-		)
-`)
-		if err != nil {
-			return err
-		}
+		return 0, err
 	}
+	return id, nil
+}
 
-	exists, err = m.tableExists(ctx, "check_labels")
+// insertInstance inserts an instance with its labels if it isn't stored yet, leaving a stored
+// instance unchanged. It returns the instance's database id.
+func (m *mysqlStorer) insertInstance(ctx context.Context, instance *check.Instance) (id int64, err error) {
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("starting transaction: %w", err)
 	}
-	if !exists {
-		_, err = m.db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS instance_labels (
-			instance_id INT NOT NULL,
-			k VARCHAR(64) NOT NULL,
-			v VARCHAR(64) NOT NULL,
-			KEY kv (k, v),
-			PRIMARY KEY(instance_id, k)
-		)
-	`)
+	defer func() {
 		if err != nil {
-			return err
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
 		}
-	}
-	return nil
-}
+	}()
 
-func (m *mysqlStorer) AnalyzeLongestGapPerApp(
-	ctx context.Context,
-	start time.Time,
-	end time.Time,
-	apps []string,
-	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
-) error {
-	cond := sq.And{sq.GtOrEq{"ts": start}, sq.LtOrEq{"ts": end}}
-	if len(apps) > 0 {
-		cond = append(cond, sq.Eq{"instances.app_id": apps})
-	}
-	q := sq.Select("instances.app_id", "ts").
-		InnerJoin("instances on instances.id = checks.instance_id").
-		From("checks").
-		Where(cond).
-		OrderBy("instances.app_id", "ts ASC")
-	rows, err := q.RunWith(m.db).QueryContext(ctx)
+	r, err := instanceInsert(instance).Suffix("ON DUPLICATE KEY UPDATE id = id").RunWith(tx).ExecContext(ctx)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("inserting instance record: %w", err)
 	}
-	defer rows.Close()
-	var maxInterval time.Duration = -1
-	var maxIntervalTS, lastTS time.Time
-	var lastAppID string
-	for rows.Next() {
-		var appID string
-		var ts time.Time
-		if err := rows.Scan(&appID, &ts); err != nil {
-			return err
-		}
-		if appID != lastAppID {
-			if lastAppID != "" && maxInterval > 0 {
-				output(lastAppID, maxInterval, maxIntervalTS)
-			}
-			lastAppID = appID
-			lastTS = ts
-			maxIntervalTS = ts
-			maxInterval = -1
-			continue
-		}
-		interval := ts.Sub(lastTS)
-		if interval > maxInterval {
-			maxInterval = interval
-			maxIntervalTS = ts
-		}
-		lastTS = ts
+	if n, err := r.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("reading inserted instances: %w", err)
+	} else if n == 0 {
+		return selectInstanceID(ctx, tx, sq.Question, instance.UUID)
 	}
-	if lastAppID != "" && maxInterval > 0 {
-		output(lastAppID, maxInterval, maxIntervalTS)
+	if id, err = r.LastInsertId(); err != nil {
+		return 0, fmt.Errorf("reading instance id: %w", err)
 	}
-	return rows.Err()
-}
-
-func (m *mysqlStorer) tableExists(ctx context.Context, table string) (bool, error) {
-	err := m.db.QueryRowContext(ctx, `SELECT 1 FROM `+table).Err()
-	if mErr, ok := err.(*mysql.MySQLError); ok {
-		if mErr.Number == 1146 {
-			return false, nil
-		}
-	}
-	return true, nil
-}
-
-func (m *mysqlStorer) ensureInstance(ctx context.Context, instance *check.Instance) error {
-	m.instanceLock.Lock()
-	defer m.instanceLock.Unlock()
-	if instance.DatabaseID != 0 {
-		// If the database ID exists, we don't need to insert the instance. Updates to the instance
-		// are handled via UpdateInstance.
-		return nil
-	}
-	return m.updateInstance(ctx, instance)
-}
-
-func (m *mysqlStorer) updateInstance(ctx context.Context, instance *check.Instance) error {
-	r, err := sq.Insert("instances").Columns(
-		"uuid",
-		"app_id",
-		"hostname",
-		"public_ipv4",
-		"started_at",
-		"stopped_at",
-	).Values(
-		instance.UUID,
-		sql.NullString{Valid: instance.AppID != "", String: instance.AppID},
-		instance.Hostname,
-		sql.NullString{Valid: instance.PublicIPv4 != "", String: instance.PublicIPv4},
-		instance.StartedAt,
-		sql.NullTime{Valid: !instance.StoppedAt.IsZero(), Time: instance.StoppedAt},
-	).Suffix(`
-	ON DUPLICATE KEY UPDATE
-	id = LAST_INSERT_ID(id),
-	uuid = VALUES(uuid),
-	app_id = VALUES(app_id),
-	hostname = VALUES(hostname),
-	public_ipv4 = VALUES(public_ipv4),
-	started_at = VALUES(started_at),
-	stopped_at = VALUES(stopped_at)
-	`).RunWith(m.db).ExecContext(ctx)
+	err = syncLabels(ctx, tx, sq.Question, `ON DUPLICATE KEY UPDATE v = VALUES(v)`, id, instanceLabels(instance))
 	if err != nil {
-		return fmt.Errorf("updating instance record: %w", err)
+		return 0, err
 	}
-	id, err := r.LastInsertId()
-	if err != nil {
-		return fmt.Errorf("reading instance id: %w", err)
-	}
-	instance.DatabaseID = id
-	if len(instance.Labels) > 0 {
-		// We won't cleanup removed labels.
-		q := sq.Insert("instance_labels").Columns("instance_id", "k", "v")
-		for k, v := range instance.Labels {
-			q = q.Values(id, k, v)
-		}
-		q = q.Suffix(`ON DUPLICATE KEY UPDATE v = VALUES(v)`)
-		if _, err = q.RunWith(m.db).ExecContext(ctx); err != nil {
-			return fmt.Errorf("storing instance labels: %w", err)
-		}
-	}
-	return nil
+	return id, nil
 }
 
 func (m *mysqlStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
-	m.instanceLock.Lock()
-	defer m.instanceLock.Unlock()
-	return m.updateInstance(ctx, instance)
+	return m.instances.update(ctx, instance, m.updateInstance)
 }
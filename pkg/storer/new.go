@@ -3,20 +3,60 @@ package storer
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"strings"
 )
 
-// New creates a storer based on the `uri` string.
-func New(ctx context.Context, uri, cert string, createTables bool) (Storer, error) {
+// New creates a storer based on the `uri` string. Options are ignored by the log storers, whose
+// saves can't fail. Several space separated uris create a storer which writes to all of them and
+// reads from the first, see NewMultiStorer. A `file:` uri creates a file storer; SQLite databases
+// take a `sqlite:` prefix, ex. `sqlite:file:self-check.db`.
+func New(ctx context.Context, uri, cert string, createTables bool, opts ...StorerOption) (Storer, error) {
+	if uris := strings.Fields(uri); len(uris) > 1 {
+		return NewMultiStorer(ctx, uris, cert, createTables, opts...)
+	}
+	uri = strings.TrimSpace(uri)
+	// The log storers accept a format, ex. `stdout?format=jsonl`.
+	logDest, logQuery, _ := strings.Cut(uri, "?")
 	switch {
-	case uri == "", strings.EqualFold(uri, "stdout"):
-		return NewLogStorer(os.Stdout), nil
-	case strings.EqualFold(uri, "stderr"):
-		return NewLogStorer(os.Stderr), nil
+	case logDest == "", strings.EqualFold(logDest, "stdout"):
+		return newLogStorerURI(os.Stdout, logQuery)
+	case strings.EqualFold(logDest, "stderr"):
+		return newLogStorerURI(os.Stderr, logQuery)
 	case strings.HasPrefix(uri, "mysql:"):
-		return NewMySQLStorer(ctx, uri, cert, createTables)
+		return NewMySQLStorer(ctx, uri, cert, createTables, opts...)
+	case strings.HasPrefix(uri, "postgres:"), strings.HasPrefix(uri, "postgresql:"):
+		return NewPostgresStorer(ctx, uri, cert, createTables, opts...)
+	case strings.HasPrefix(uri, "sqlite:"):
+		return NewSQLiteStorer(ctx, uri, createTables, opts...)
+	case strings.HasPrefix(uri, "memory:"):
+		return NewMemoryStorer(opts...)
+	case strings.HasPrefix(uri, "file:"):
+		return NewFileStorer(uri, opts...)
+	case strings.HasPrefix(uri, "statsd:"):
+		return NewStatsDStorer(uri, opts...)
+	case strings.HasPrefix(uri, "remote_write+"):
+		return NewRemoteWriteStorer(uri, opts...)
+	case strings.HasPrefix(uri, "influx+"):
+		return NewInfluxStorer(uri, opts...)
+	case strings.HasPrefix(uri, "https:"), strings.HasPrefix(uri, "http:"):
+		return NewWebhookStorer(uri, opts...)
 	default:
 		return nil, errors.New("unsupported storer uri format")
 	}
 }
+
+func newLogStorerURI(w io.Writer, rawQuery string) (Storer, error) {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("parsing log storer uri parameters: %w", err)
+	}
+	format, err := ParseLogFormat(q.Get("format"))
+	if err != nil {
+		return nil, err
+	}
+	return NewLogStorer(w, WithLogFormat(format)), nil
+}
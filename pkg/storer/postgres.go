@@ -0,0 +1,164 @@
+package storer
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+
+	sq "github.com/Masterminds/squirrel"
+	_ "github.com/lib/pq"
+)
+
+const defaultPostgresConnectTimeout = "10"
+
+type postgresStorer struct {
+	db *sql.DB
+	commonStorer
+	sqlReader
+	instances instanceIDs
+	// caFile is a temporary copy of an inline CA certificate, removed on Close.
+	caFile string
+}
+
+// NewPostgresStorer creates a new storer driver for a PostgreSQL backend.
+func NewPostgresStorer(ctx context.Context, uri, cert string, createTables bool, opts ...StorerOption) (Storer, error) {
+	connStr, caFile, err := postgresConnString(uri, cert)
+	if err != nil {
+		return nil, err
+	}
+	p := &postgresStorer{caFile: caFile}
+	p.db, err = sql.Open("postgres", connStr)
+	if err != nil {
+		p.removeCAFile()
+		return nil, err
+	}
+	p.sqlReader = sqlReader{db: p.db, dialect: postgresDialect}
+	// Open() only inits the config & pool, do a Ping() to establish/validate a connection.
+	if err := p.db.PingContext(ctx); err != nil {
+		p.db.Close()
+		p.removeCAFile()
+		return nil, err
+	}
+	if err := p.init(ctx, createTables, opts); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// postgresConnString prepares the connection uri for lib/pq. The cert may be a PEM encoded CA
+// certificate or the absolute path to one, and is ignored if the uri sets `sslrootcert`. Inline
+// certificates are written to a temporary file, which is returned so it can be removed later.
+func postgresConnString(uri, cert string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	u.Scheme = "postgres"
+	q := u.Query()
+	if !q.Has("connect_timeout") {
+		q.Set("connect_timeout", defaultPostgresConnectTimeout)
+	}
+	var caFile string
+	if cert != "" && !q.Has("sslrootcert") {
+		caFile = cert
+		if !strings.HasPrefix(cert, "/") {
+			if caFile, err = writePostgresCertificate(cert); err != nil {
+				return "", "", fmt.Errorf("loading TLS cert: %w", err)
+			}
+		}
+		q.Set("sslrootcert", caFile)
+		if mode := q.Get("sslmode"); mode == "" || mode == "require" {
+			// Verify the server against the provided CA, as the MySQL storer does.
+			q.Set("sslmode", "verify-full")
+		}
+		if caFile == cert {
+			caFile = ""
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), caFile, nil
+}
+
+func writePostgresCertificate(cert string) (string, error) {
+	if ok := x509.NewCertPool().AppendCertsFromPEM([]byte(cert)); !ok {
+		return "", errors.New("appending certificate to pool")
+	}
+	f, err := ioutil.TempFile("", "self-check-postgres-ca-*.pem")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(cert); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// SaveCheckResults saves check results.
+func (p *postgresStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return p.saveCheckResults(ctx, []check.CheckResults{result})
+}
+
+// saveCheckResults saves a batch of check results in a single transaction.
+func (p *postgresStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) error {
+	instanceIDs, err := p.instances.ensure(ctx, results, p.insertInstance)
+	if err != nil {
+		return err
+	}
+	return insertCheckResultsReturning(ctx, p.db, sq.Dollar, results, instanceIDs)
+}
+
+// Close shuts down the database handle and any async savers.
+func (p *postgresStorer) Close() error {
+	p.commonStorer.close()
+	defer p.removeCAFile()
+	return p.db.Close()
+}
+
+func (p *postgresStorer) removeCAFile() {
+	if p.caFile != "" {
+		os.Remove(p.caFile)
+	}
+}
+
+func (p *postgresStorer) init(ctx context.Context, createTables bool, opts []StorerOption) error {
+	if err := p.commonStorer.init(p.saveCheckResults, opts...); err != nil {
+		return err
+	}
+	if !createTables {
+		return nil
+	}
+	_, err := p.Migrator().Up(ctx, false)
+	return err
+}
+
+// Migrator manages the database schema.
+func (p *postgresStorer) Migrator() *Migrator {
+	return newMigrator(p.db, p.dialect)
+}
+
+func (p *postgresStorer) updateInstance(ctx context.Context, instance *check.Instance) (int64, error) {
+	return upsertInstanceReturning(ctx, p.db, sq.Dollar, instance)
+}
+
+func (p *postgresStorer) insertInstance(ctx context.Context, instance *check.Instance) (int64, error) {
+	return insertInstanceReturning(ctx, p.db, sq.Dollar, instance)
+}
+
+func (p *postgresStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
+	return p.instances.update(ctx, instance, p.updateInstance)
+}
@@ -0,0 +1,133 @@
+package storer
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPageLimit is used when a Page doesn't specify a limit.
+	DefaultPageLimit = 100
+	// MaxPageLimit caps the number of results returned in a single page.
+	MaxPageLimit = 1000
+	// MaxMeasurementPoints caps the number of points returned by a measurements query, which isn't
+	// paged.
+	MaxMeasurementPoints = 100000
+)
+
+var (
+	// ErrUnsupported is returned by storers which cannot perform the requested operation.
+	ErrUnsupported = errors.New("operation not supported by this storer")
+	// ErrInvalidCursor is returned for a page cursor which wasn't returned by a query.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrTooManyMeasurements is returned for a measurements query matching more than
+	// MaxMeasurementPoints points. The query should be narrowed, ex. with a shorter time range.
+	ErrTooManyMeasurements = fmt.Errorf("query matches more than %d measurement points", MaxMeasurementPoints)
+)
+
+// maxMeasurementPoints is the enforced MaxMeasurementPoints, which tests lower.
+var maxMeasurementPoints = MaxMeasurementPoints
+
+// ResultsFilter selects stored check results. Empty fields match everything.
+type ResultsFilter struct {
+	AppIDs               []string
+	InstanceUUIDs        []string
+	ExcludeInstanceUUIDs []string
+	// Checks limits results to cycles which ran any of the named checks. Errors and measurements
+	// of other checks are omitted.
+	Checks []string
+	Start  time.Time
+	End    time.Time
+	// ErrorsOnly limits results to cycles which recorded at least one error.
+	ErrorsOnly bool
+	// Labels limits results to instances with all of the labels.
+	Labels map[string]string
+}
+
+// InstanceFilter selects stored instances. Empty fields match everything.
+type InstanceFilter struct {
+	AppIDs []string
+	UUIDs  []string
+	// Labels limits instances to those with all of the labels.
+	Labels map[string]string
+	// Start and End limit instances to those running at any time between them.
+	Start time.Time
+	End   time.Time
+}
+
+// MeasurementSeries is the values of a measurement reported by an instance, oldest first.
+type MeasurementSeries struct {
+	Measurement  string
+	InstanceUUID string
+	AppID        string `json:",omitempty"`
+	Points       []MeasurementPoint
+}
+
+// MeasurementPoint is a measurement value reported by a check cycle.
+type MeasurementPoint struct {
+	TS    time.Time
+	Value float64
+}
+
+// ResultStats summarizes the check results matching a filter.
+type ResultStats struct {
+	Cycles           int
+	CyclesWithErrors int
+	LastCheck        time.Time
+}
+
+// Page selects a window of results, newest first.
+type Page struct {
+	Limit int
+	// Cursor is the NextCursor from a previous ResultsPage, or empty for the first page.
+	Cursor string
+}
+
+// limit returns the effective page size.
+func (p Page) limit() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultPageLimit
+	case p.Limit > MaxPageLimit:
+		return MaxPageLimit
+	}
+	return p.Limit
+}
+
+// cursor identifies the position of the last result on a page. Results are ordered by timestamp
+// and then by id to break ties.
+type cursor struct {
+	ts time.Time
+	id int64
+}
+
+func (c cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.ts.UnixNano(), 10) + ":" + strconv.FormatInt(c.id, 10)))
+}
+
+func parseCursor(s string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	tsS, idS, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	ts, err := strconv.ParseInt(tsS, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	id, err := strconv.ParseInt(idS, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return &cursor{ts: time.Unix(0, ts).UTC(), id: id}, nil
+}
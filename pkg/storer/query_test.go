@@ -0,0 +1,178 @@
+package storer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/google/uuid"
+)
+
+func TestStorerQueries(t *testing.T) {
+	tcs := []struct {
+		name string
+		new  func(t *testing.T) (Storer, error)
+	}{
+		{
+			name: "memory",
+			new: func(t *testing.T) (Storer, error) {
+				return NewMemoryStorer()
+			},
+		},
+		{
+			name: "sqlite",
+			new: func(t *testing.T) (Storer, error) {
+				return NewSQLiteStorer(context.Background(), "sqlite:"+filepath.Join(t.TempDir(), "self-check.db"), true)
+			},
+		},
+		{
+			name: "postgres",
+			new: func(t *testing.T) (Storer, error) {
+				return newPostgresTestStorer(t), nil
+			},
+		},
+		{
+			name: "mysql",
+			new: func(t *testing.T) (Storer, error) {
+				return newMySQLTestStorer(t), nil
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := tc.new(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			testStorerQueries(t, s)
+		})
+	}
+}
+
+// testStorerQueries checks that a storer filters and groups stored results consistently. Instance
+// UUIDs and app IDs are unique to the run, and queries are limited to them, so the test can run
+// against a shared database.
+func testStorerQueries(t *testing.T, s Storer) {
+	ctx := context.Background()
+	run := uuid.NewString() + "-"
+	app1, app2 := run+"app1", run+"app2"
+	apps := []string{app1, app2}
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	a := &check.Instance{UUID: run + "a", AppID: app1, StartedAt: start, Labels: map[string]string{"region": "nyc", "tier": "web"}}
+	b := &check.Instance{UUID: run + "b", AppID: app1, StartedAt: start.Add(time.Minute), Labels: map[string]string{"region": "ams"}}
+	c := &check.Instance{UUID: run + "c", AppID: app2, StartedAt: start.Add(2 * time.Minute)}
+	for i, r := range []check.CheckResults{
+		{Instance: a, TS: start, Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.1}, {Check: "dns_duration", Value: 0.01}}},
+		{Instance: a, TS: start.Add(time.Minute), Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.2}}, Errors: []check.CheckError{{Check: "dns", Error: "nxdomain"}}},
+		{Instance: b, TS: start.Add(time.Minute), Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.3}}},
+		{Instance: c, TS: start.Add(2 * time.Minute), Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.4}}},
+	} {
+		if err := s.SaveCheckResults(ctx, r); err != nil {
+			t.Fatalf("saving result %d: %v", i, err)
+		}
+	}
+	a.StoppedAt = start.Add(90 * time.Second)
+	if err := s.UpdateInstance(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	instanceTcs := []struct {
+		name   string
+		filter InstanceFilter
+		expect []string
+	}{
+		{name: "all", filter: InstanceFilter{AppIDs: apps}, expect: []string{"a", "b", "c"}},
+		{name: "app", filter: InstanceFilter{AppIDs: []string{app1}}, expect: []string{"a", "b"}},
+		{name: "uuid", filter: InstanceFilter{UUIDs: []string{c.UUID}}, expect: []string{"c"}},
+		{name: "labels", filter: InstanceFilter{AppIDs: apps, Labels: map[string]string{"region": "nyc", "tier": "web"}}, expect: []string{"a"}},
+		{name: "label mismatch", filter: InstanceFilter{AppIDs: apps, Labels: map[string]string{"region": "nyc", "tier": "db"}}},
+		{name: "running after stop", filter: InstanceFilter{AppIDs: apps, Start: start.Add(2 * time.Minute)}, expect: []string{"b", "c"}},
+		{name: "started before", filter: InstanceFilter{AppIDs: apps, End: start.Add(30 * time.Second)}, expect: []string{"a"}},
+	}
+	for _, tc := range instanceTcs {
+		instances, err := s.ListInstances(ctx, tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, instance := range instances {
+			got = append(got, strings.TrimPrefix(instance.UUID, run))
+		}
+		if !equalStrings(got, tc.expect) {
+			t.Errorf("%s: expected instances %v, got %v", tc.name, tc.expect, got)
+		}
+	}
+
+	page, err := s.QueryResults(ctx, ResultsFilter{AppIDs: apps, Labels: map[string]string{"region": "nyc"}, Checks: []string{"dns"}}, Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || !page.Results[0].TS.Equal(start.Add(time.Minute)) || page.NextCursor == "" {
+		t.Fatalf("expected the newest dns result of a with a cursor, got %+v", page)
+	}
+	if r := page.Results[0]; len(r.Errors) != 1 || len(r.Measurements) != 0 || r.Instance.Labels["tier"] != "web" {
+		t.Errorf("expected only the dns error, got %+v", r)
+	}
+	page, err = s.QueryResults(ctx, ResultsFilter{AppIDs: apps, Labels: map[string]string{"region": "nyc"}, Checks: []string{"dns"}}, Page{Limit: 1, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || !page.Results[0].TS.Equal(start) || page.NextCursor != "" {
+		t.Fatalf("expected the oldest dns result of a on the last page, got %+v", page)
+	}
+	page, err = s.QueryResults(ctx, ResultsFilter{AppIDs: []string{app1}, ErrorsOnly: true}, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].Instance.UUID != a.UUID {
+		t.Errorf("expected a single result with errors, got %+v", page.Results)
+	}
+
+	series, err := s.QueryMeasurements(ctx, ResultsFilter{AppIDs: []string{app1}, Checks: []string{"database"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 2 {
+		t.Fatalf("expected a database series for a and b, got %+v", series)
+	}
+	if got := series[0]; got.InstanceUUID != a.UUID || got.AppID != app1 || got.Measurement != "database_duration" ||
+		len(got.Points) != 2 || got.Points[0].Value != 0.1 || !got.Points[1].TS.Equal(start.Add(time.Minute)) {
+		t.Errorf("unexpected series for a: %+v", got)
+	}
+	if got := series[1]; got.InstanceUUID != b.UUID || len(got.Points) != 1 || got.Points[0].Value != 0.3 {
+		t.Errorf("unexpected series for b: %+v", got)
+	}
+	series, err = s.QueryMeasurements(ctx, ResultsFilter{InstanceUUIDs: []string{a.UUID}, End: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 2 || series[0].Measurement != "database_duration" || series[1].Measurement != "dns_duration" {
+		t.Errorf("expected both measurements of a's first result, got %+v", series)
+	}
+
+	defer func(max int) { maxMeasurementPoints = max }(maxMeasurementPoints)
+	maxMeasurementPoints = 3
+	if _, err := s.QueryMeasurements(ctx, ResultsFilter{AppIDs: []string{app1}, Checks: []string{"database"}}); err != nil {
+		t.Errorf("expected 3 points to be within the limit, got %v", err)
+	}
+	if _, err := s.QueryMeasurements(ctx, ResultsFilter{AppIDs: apps}); !errors.Is(err, ErrTooManyMeasurements) {
+		t.Errorf("expected too many measurements, got %v", err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,280 @@
+package storer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultQueueDepth is the number of async jobs which may wait for a worker.
+	DefaultQueueDepth = 1000
+	// DefaultQueueWorkers is the number of workers performing async jobs.
+	DefaultQueueWorkers = 4
+	// maxSaveBatch limits the number of results saved together.
+	maxSaveBatch = 100
+	// saveAttemptPrefix names the errors recording failed attempts to save results.
+	saveAttemptPrefix = "result_save_attempt_"
+)
+
+// OverflowPolicy decides what happens to async jobs when the queue is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the job which has waited longest to make room.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the job being added.
+	DropNewest
+	// Block waits for room in the queue, or until the job's ctx is cancelled.
+	Block
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Block:
+		return "block"
+	default:
+		return "OverflowPolicy(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// ParseOverflowPolicy parses `drop-oldest`, `drop-newest` or `block`.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for _, p := range []OverflowPolicy{DropOldest, DropNewest, Block} {
+		if strings.EqualFold(strings.ReplaceAll(s, "_", "-"), p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// QueueStats describes the async job queue. Saves of check results count once per result.
+type QueueStats struct {
+	Depth    int
+	Capacity int
+	Policy   string
+	InFlight int
+	// Dropped counts jobs discarded because the queue was full. Dropped results are spooled if a
+	// spool is configured.
+	Dropped int
+}
+
+// Queuer is implemented by storers which perform async work from a bounded queue.
+type Queuer interface {
+	QueueStats() QueueStats
+}
+
+// asyncJob is a database action or check results to save.
+type asyncJob struct {
+	ctx      context.Context
+	schedule []time.Duration
+	f        func(ctx context.Context, attempt int) error
+	result   *check.CheckResults
+	// attempts is the number of failed attempts to save result.
+	attempts int
+}
+
+func (c *commonStorer) initQueue(depth int, policy OverflowPolicy, workers int) {
+	if depth <= 0 {
+		depth = DefaultQueueDepth
+	}
+	if workers <= 0 {
+		workers = DefaultQueueWorkers
+	}
+	c.notEmpty = sync.NewCond(&c.queueLock)
+	c.notFull = sync.NewCond(&c.queueLock)
+	c.queueDepth = depth
+	c.overflow = policy
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+	for i := 0; i < workers; i++ {
+		c.startWorker()
+	}
+}
+
+// startWorker must be called with the queue lock held.
+func (c *commonStorer) startWorker() {
+	c.running++
+	go c.work()
+}
+
+// enqueue adds a job to the queue, applying the overflow policy if it is full.
+func (c *commonStorer) enqueue(j *asyncJob) {
+	c.wg.Add(1)
+	c.startSpoolReplays(j.ctx)
+	var dropped []*asyncJob
+	defer func() {
+		for _, d := range dropped {
+			log.Ctx(d.ctx).Warn().Str("policy", c.overflow.String()).Msg("async queue full, dropping job")
+			c.finish(d, false, false)
+		}
+	}()
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+	if c.closed && c.running == 0 {
+		// The workers exit once the queue drains after shutdown; start one for late arrivals.
+		c.startWorker()
+	}
+	var stopWatching func()
+	defer func() {
+		if stopWatching != nil {
+			stopWatching()
+		}
+	}()
+	for len(c.queue) >= c.queueDepth {
+		switch c.overflow {
+		case DropNewest:
+			c.dropped++
+			dropped = append(dropped, j)
+			return
+		case Block:
+			if j.ctx.Err() != nil {
+				c.dropped++
+				dropped = append(dropped, j)
+				return
+			}
+			if stopWatching == nil {
+				stopWatching = c.wakeOnDone(j.ctx)
+			}
+			c.notFull.Wait()
+		default:
+			c.dropped++
+			dropped = append(dropped, c.queue[0])
+			c.queue = c.queue[1:]
+		}
+	}
+	c.queue = append(c.queue, j)
+	c.notEmpty.Signal()
+}
+
+// wakeOnDone wakes enqueuers blocked on a full queue when the ctx is cancelled.
+func (c *commonStorer) wakeOnDone(ctx context.Context) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.queueLock.Lock()
+			c.notFull.Broadcast()
+			c.queueLock.Unlock()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// work runs queued jobs until the storer is closed and the queue is empty.
+func (c *commonStorer) work() {
+	for {
+		c.queueLock.Lock()
+		for len(c.queue) == 0 && !c.closed {
+			c.notEmpty.Wait()
+		}
+		if len(c.queue) == 0 {
+			c.running--
+			c.queueLock.Unlock()
+			return
+		}
+		j := c.queue[0]
+		c.queue = c.queue[1:]
+		c.inFlight++
+		c.notFull.Signal()
+		c.queueLock.Unlock()
+
+		if j.result != nil {
+			c.saveBatch(j)
+		} else {
+			err := retry(j.ctx, c.done, j.schedule, j.f)
+			c.finish(j, true, err == nil)
+		}
+	}
+}
+
+// saveBatch saves the job's results, along with any other results waiting in the queue. The batch
+// follows the attempt schedule of its first job.
+func (c *commonStorer) saveBatch(j *asyncJob) {
+	batch := []*asyncJob{j}
+	err := retry(j.ctx, c.done, j.schedule, func(ctx context.Context, attempt int) error {
+		batch = append(batch, c.takeResults(maxSaveBatch-len(batch))...)
+		results := make([]check.CheckResults, 0, len(batch))
+		for _, b := range batch {
+			results = append(results, *b.result)
+		}
+		err := c.save(ctx, results)
+		if err != nil {
+			for _, b := range batch {
+				b.attempts++
+				b.result.Errors = append(b.result.Errors, check.CheckError{
+					Check: saveAttemptPrefix + strconv.Itoa(b.attempts),
+					Error: err.Error(),
+				})
+			}
+		}
+		return err
+	})
+	if err == nil {
+		c.replaySpool(j.ctx)
+	}
+	for _, b := range batch {
+		c.finish(b, true, err == nil)
+	}
+}
+
+// takeResults removes up to n result jobs from the queue.
+func (c *commonStorer) takeResults(n int) []*asyncJob {
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+	var out []*asyncJob
+	remaining := c.queue[:0]
+	for _, q := range c.queue {
+		if q.result != nil && len(out) < n {
+			out = append(out, q)
+		} else {
+			remaining = append(remaining, q)
+		}
+	}
+	for i := len(remaining); i < len(c.queue); i++ {
+		c.queue[i] = nil
+	}
+	c.queue = remaining
+	c.inFlight += len(out)
+	if len(out) > 0 {
+		c.notFull.Broadcast()
+	}
+	return out
+}
+
+// finish completes a job which was run or dropped. Results which weren't saved are spooled.
+func (c *commonStorer) finish(j *asyncJob, ran, ok bool) {
+	defer c.wg.Done()
+	if j.result != nil && !ok {
+		c.spoolResult(j.ctx, *j.result)
+	}
+	if ran {
+		c.queueLock.Lock()
+		c.inFlight--
+		c.queueLock.Unlock()
+	}
+}
+
+// QueueStats reports the state of the async job queue.
+func (c *commonStorer) QueueStats() QueueStats {
+	c.queueLock.Lock()
+	defer c.queueLock.Unlock()
+	return QueueStats{
+		Depth:    len(c.queue),
+		Capacity: c.queueDepth,
+		Policy:   c.overflow.String(),
+		InFlight: c.inFlight,
+		Dropped:  c.dropped,
+	}
+}
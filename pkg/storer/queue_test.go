@@ -0,0 +1,114 @@
+package storer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+// batchRecorder is a saveFunc which records each batch.
+type batchRecorder struct {
+	sync.Mutex
+	batches [][]time.Time
+}
+
+func (b *batchRecorder) save(ctx context.Context, results []check.CheckResults) error {
+	b.Lock()
+	defer b.Unlock()
+	var batch []time.Time
+	for _, r := range results {
+		batch = append(batch, r.TS)
+	}
+	b.batches = append(b.batches, batch)
+	return nil
+}
+
+// newBlockedQueue returns a storer with a single worker, which is busy until release is called.
+func newBlockedQueue(t *testing.T, b *batchRecorder, opts ...StorerOption) (c *commonStorer, release func()) {
+	c = &commonStorer{}
+	if err := c.init(b.save, append(opts, WithWorkers(1))...); err != nil {
+		t.Fatal(err)
+	}
+	started, unblock := make(chan struct{}), make(chan struct{})
+	c.AsyncQueryRetry(context.Background(), []time.Duration{0}, func(ctx context.Context, attempt int) error {
+		close(started)
+		<-unblock
+		return nil
+	})
+	<-started
+	return c, func() { close(unblock) }
+}
+
+func TestQueueOverflow(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		policy OverflowPolicy
+		want   time.Time
+	}{
+		{DropOldest, start.Add(2 * time.Second)},
+		{DropNewest, start},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			b := &batchRecorder{}
+			c, release := newBlockedQueue(t, b, WithQueue(1, tc.policy))
+			for i := 0; i < 3; i++ {
+				c.AsyncSaveCheckResults(ctx, check.CheckResults{TS: start.Add(time.Duration(i) * time.Second)}, []time.Duration{0})
+			}
+			if want := (QueueStats{Depth: 1, Capacity: 1, Policy: tc.policy.String(), InFlight: 1, Dropped: 2}); c.QueueStats() != want {
+				t.Errorf("expected %+v, got %+v", want, c.QueueStats())
+			}
+			release()
+			c.close()
+			if len(b.batches) != 1 || len(b.batches[0]) != 1 || !b.batches[0][0].Equal(tc.want) {
+				t.Errorf("expected only %s to be saved, got %v", tc.want, b.batches)
+			}
+		})
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	b := &batchRecorder{}
+	c, release := newBlockedQueue(t, b, WithQueue(1, Block))
+	c.AsyncSaveCheckResults(context.Background(), check.CheckResults{}, []time.Duration{0})
+
+	// A full queue blocks until the ctx is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	enqueued := make(chan struct{})
+	go func() {
+		c.AsyncSaveCheckResults(ctx, check.CheckResults{}, []time.Duration{0})
+		close(enqueued)
+	}()
+	select {
+	case <-enqueued:
+		t.Fatal("expected enqueueing to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	<-enqueued
+	if stats := c.QueueStats(); stats.Dropped != 1 {
+		t.Errorf("expected the cancelled save to be dropped, got %+v", stats)
+	}
+	release()
+	c.close()
+}
+
+func TestQueueBatchesResults(t *testing.T) {
+	ctx := context.Background()
+	b := &batchRecorder{}
+	c, release := newBlockedQueue(t, b)
+	for i := 0; i < 3; i++ {
+		c.AsyncSaveCheckResults(ctx, check.CheckResults{}, []time.Duration{0})
+	}
+	release()
+	c.close()
+	if len(b.batches) != 1 || len(b.batches[0]) != 3 {
+		t.Errorf("expected the waiting results to be saved in one batch, got %v", b.batches)
+	}
+	if stats := c.QueueStats(); stats.Depth != 0 || stats.InFlight != 0 {
+		t.Errorf("expected the queue to be idle, got %+v", stats)
+	}
+}
@@ -0,0 +1,148 @@
+package storer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/golang/snappy"
+)
+
+// decodeProto splits a protobuf message into its fields. Varint and fixed64 values are returned as
+// 8 little-endian bytes.
+func decodeProto(t *testing.T, b []byte) (fields []int, values [][]byte) {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		var v []byte
+		switch key & 7 {
+		case 0:
+			x, n := binary.Uvarint(b)
+			v = make([]byte, 8)
+			binary.LittleEndian.PutUint64(v, x)
+			b = b[n:]
+		case 1:
+			v, b = b[:8], b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			v, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, int(key>>3))
+		values = append(values, v)
+	}
+	return fields, values
+}
+
+// decodeWriteRequest formats each series as `labels... value@ts`.
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	var out []string
+	_, series := decodeProto(t, b)
+	for _, s := range series {
+		var labels, samples []string
+		fields, values := decodeProto(t, s)
+		for i, v := range values {
+			_, parts := decodeProto(t, v)
+			if fields[i] == 1 {
+				labels = append(labels, string(parts[0])+"="+string(parts[1]))
+				continue
+			}
+			value := math.Float64frombits(binary.LittleEndian.Uint64(parts[0]))
+			samples = append(samples, fmt.Sprintf("%g@%d", value, binary.LittleEndian.Uint64(parts[1])))
+		}
+		out = append(out, strings.Join(labels, ",")+" "+strings.Join(samples, " "))
+	}
+	return out
+}
+
+func TestRemoteWriteStorer(t *testing.T) {
+	ctx := context.Background()
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("content-encoding") != "snappy" || r.Header.Get("x-scope-orgid") != "apps" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			t.Errorf("unexpected basic auth %s:%s", user, pass)
+		}
+		webhook := &webhookReceiver{t: t}
+		webhook.ServeHTTP(w, r)
+		select {
+		case bodies <- []byte(webhook.bodies[0]):
+		default:
+			t.Error("unexpected request")
+		}
+	}))
+	defer srv.Close()
+
+	uri := "remote_write+" + strings.Replace(srv.URL, "://", "://user:pass@", 1) + "/api/v1/push?tenant=apps"
+	s, err := New(ctx, uri, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := time.UnixMilli(1667260800000)
+	// Labels which sanitize to the same name, or match the builtin labels, must not produce
+	// duplicate names.
+	instance := &check.Instance{UUID: "u", AppID: "app", Labels: map[string]string{"team.name": "a", "team_name": "b", "instance": "x"}}
+	err = s.(*remoteWriteStorer).saveCheckResults(ctx, []check.CheckResults{
+		{Instance: instance, TS: ts.Add(time.Minute), Errors: []check.CheckError{{Check: "database", Error: "refused"}}},
+		{Instance: instance, TS: ts, Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.25}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body []byte
+	select {
+	case body = <-bodies:
+	default:
+		t.Fatal("expected a request")
+	}
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Results are grouped into series, with samples sorted by time.
+	expected := []string{
+		"__name__=self_check_up,app_id=app,check=database,instance=u,label_instance=x,label_team_name=a 1@1667260800000 0@1667260860000",
+		"__name__=self_check_measurement,app_id=app,check=database_duration,instance=u,label_instance=x,label_team_name=a 0.25@1667260800000",
+	}
+	if got := decodeWriteRequest(t, b); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected series:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestRemoteWriteStorerErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		status  int
+		wantErr bool
+	}{
+		{status: http.StatusBadRequest},
+		{status: http.StatusTooManyRequests, wantErr: true},
+		{status: http.StatusServiceUnavailable, wantErr: true},
+	} {
+		t.Run(http.StatusText(tc.status), func(t *testing.T) {
+			srv := httptest.NewServer(&webhookReceiver{t: t, status: tc.status})
+			defer srv.Close()
+			s, err := NewRemoteWriteStorer("remote_write+" + srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			err = s.SaveCheckResults(ctx, check.CheckResults{Measurements: []check.CheckMeasurement{{Check: "x", Value: 1}}})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
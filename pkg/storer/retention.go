@@ -0,0 +1,692 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	resolutionHour = "hour"
+	resolutionDay  = "day"
+
+	oneDay = 24 * time.Hour
+
+	// DefaultRetentionBatchSize is used when a RetentionPolicy doesn't specify a batch size.
+	DefaultRetentionBatchSize = 1000
+	// rollupInsertBatchSize limits the number of rows inserted per statement.
+	rollupInsertBatchSize = 500
+
+	retentionLockName = "self_check_retention"
+)
+
+// RetentionPolicy configures how long stored results are kept.
+type RetentionPolicy struct {
+	// RawAge is the age after which raw check results are rolled up and deleted. Results are
+	// processed a whole (UTC) day at a time, so they may be retained up to a day longer.
+	RawAge time.Duration
+	// HourlyAge and DailyAge are the ages after which rollups are deleted. Zero keeps them forever.
+	HourlyAge time.Duration
+	DailyAge  time.Duration
+	// BatchSize limits the number of checks deleted per statement.
+	BatchSize int
+}
+
+// RetentionResult summarizes a retention run.
+type RetentionResult struct {
+	DaysRolledUp   int
+	ChecksDeleted  int
+	RollupsDeleted int
+}
+
+// Retainer is implemented by storers which can roll up and delete old results.
+type Retainer interface {
+	ApplyRetention(ctx context.Context, policy RetentionPolicy, now time.Time) (RetentionResult, error)
+}
+
+// RunRetention applies the retention policy periodically until the ctx is cancelled.
+func RunRetention(ctx context.Context, r Retainer, policy RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res, err := r.ApplyRetention(ctx, policy, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Ctx(ctx).Err(err).Msg("applying retention policy")
+		} else if err == nil {
+			log.Ctx(ctx).Info().
+				Int("days_rolled_up", res.DaysRolledUp).
+				Int("checks_deleted", res.ChecksDeleted).
+				Int("rollups_deleted", res.RollupsDeleted).
+				Msg("applied retention policy")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ApplyRetention rolls up raw results older than the policy's RawAge into hourly and daily
+// aggregates, deletes them in batches, and then deletes expired rollups. A lock ensures only one
+// instance applies retention at a time.
+func (r *sqlReader) ApplyRetention(ctx context.Context, policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	var res RetentionResult
+	if policy.RawAge <= 0 {
+		return res, errors.New("retention policy requires a positive raw age")
+	}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = DefaultRetentionBatchSize
+	}
+	now = now.UTC()
+	err := r.withLock(ctx, retentionLockName, func() error {
+		cutoff := now.Add(-policy.RawAge).Truncate(oneDay)
+		var last time.Time
+		for {
+			var oldest nullTime
+			if err := r.builder().Select("MIN(ts)").From("checks").QueryRowContext(ctx).Scan(&oldest); err != nil {
+				return fmt.Errorf("finding oldest check: %w", err)
+			}
+			if oldest.Time.IsZero() || !oldest.Time.Before(cutoff) {
+				break
+			}
+			d := oldest.Time.Truncate(oneDay)
+			if d.Equal(last) {
+				return fmt.Errorf("checks from %s remain after deletion", d.Format("2006-01-02"))
+			}
+			last = d
+
+			rolledUp, maxID, err := r.rollupDay(ctx, d, now)
+			if err != nil {
+				return fmt.Errorf("rolling up %s: %w", d.Format("2006-01-02"), err)
+			}
+			if rolledUp {
+				res.DaysRolledUp++
+			}
+			deleted, err := r.deleteChecksBefore(ctx, d.Add(oneDay), maxID, policy.BatchSize)
+			res.ChecksDeleted += deleted
+			if err != nil {
+				return fmt.Errorf("deleting checks from %s: %w", d.Format("2006-01-02"), err)
+			}
+		}
+
+		for resolution, age := range map[string]time.Duration{
+			resolutionHour: policy.HourlyAge,
+			resolutionDay:  policy.DailyAge,
+		} {
+			if age <= 0 {
+				continue
+			}
+			for _, table := range []string{"measurement_rollups", "error_rollups", "gap_rollups"} {
+				result, err := r.builder().Delete(table).
+					Where(sq.Eq{"resolution": resolution}).
+					Where(sq.Lt{"bucket_start": now.Add(-age)}).
+					ExecContext(ctx)
+				if err != nil {
+					return fmt.Errorf("deleting expired %s: %w", table, err)
+				}
+				if n, err := result.RowsAffected(); err == nil {
+					res.RollupsDeleted += int(n)
+				}
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// withLock runs f while holding the named advisory lock.
+func (r *sqlReader) withLock(ctx context.Context, name string, f func() error) (err error) {
+	if r.dialect.lock == nil {
+		return f()
+	}
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := r.dialect.lock(ctx, conn, name); err != nil {
+		return fmt.Errorf("acquiring %s lock: %w", name, err)
+	}
+	defer func() {
+		if err := r.dialect.unlock(context.Background(), conn, name); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("lock", name).Msg("releasing lock")
+		}
+	}()
+	return f()
+}
+
+type rollupKey struct {
+	resolution string
+	appID      string
+	bucket     time.Time
+	name       string
+}
+
+// gapRollup summarizes the intervals between checks of an app within a bucket.
+type gapRollup struct {
+	cycles   int
+	first    time.Time
+	last     time.Time
+	maxGap   time.Duration
+	maxGapTS time.Time
+}
+
+func (g *gapRollup) add(ts time.Time) {
+	if g.cycles == 0 {
+		g.first, g.maxGapTS = ts, ts
+	} else if gap := ts.Sub(g.last); gap > g.maxGap {
+		g.maxGap, g.maxGapTS = gap, ts
+	}
+	g.last = ts
+	g.cycles++
+}
+
+// merge adds the checks summarized by o. The gap between the two is only known if they don't
+// overlap; otherwise the checks of one split the gaps of the other, so the larger of their longest
+// gaps is kept as an upper bound.
+func (g *gapRollup) merge(o *gapRollup) {
+	if o.cycles == 0 {
+		return
+	}
+	if g.cycles == 0 {
+		*g = *o
+		return
+	}
+	if o.maxGap > g.maxGap {
+		g.maxGap, g.maxGapTS = o.maxGap, o.maxGapTS
+	}
+	if gap := o.first.Sub(g.last); gap > g.maxGap {
+		g.maxGap, g.maxGapTS = gap, o.first
+	}
+	if gap := g.first.Sub(o.last); gap > g.maxGap {
+		g.maxGap, g.maxGapTS = gap, g.first
+	}
+	if o.first.Before(g.first) {
+		g.first = o.first
+	}
+	if o.last.After(g.last) {
+		g.last = o.last
+	}
+	g.cycles += o.cycles
+}
+
+// measurementRollup summarizes the values of a measurement within a bucket.
+type measurementRollup struct {
+	samples       int
+	min, max, sum float64
+	p50, p90, p99 float64
+}
+
+func newMeasurementRollup(values []float64) *measurementRollup {
+	sort.Float64s(values)
+	m := &measurementRollup{
+		samples: len(values),
+		min:     values[0],
+		max:     values[len(values)-1],
+		p50:     percentile(values, 0.5),
+		p90:     percentile(values, 0.9),
+		p99:     percentile(values, 0.99),
+	}
+	for _, v := range values {
+		m.sum += v
+	}
+	return m
+}
+
+// merge adds the values summarized by o. Percentiles can't be merged exactly, so they are
+// approximated by weighting each rollup's percentiles by its samples.
+func (m *measurementRollup) merge(o *measurementRollup) {
+	n := float64(m.samples + o.samples)
+	weighted := func(a, b float64) float64 {
+		return (a*float64(m.samples) + b*float64(o.samples)) / n
+	}
+	m.p50, m.p90, m.p99 = weighted(m.p50, o.p50), weighted(m.p90, o.p90), weighted(m.p99, o.p99)
+	m.min, m.max = math.Min(m.min, o.min), math.Max(m.max, o.max)
+	m.sum += o.sum
+	m.samples += o.samples
+}
+
+// dayRollups are the rollups of a day, at each resolution.
+type dayRollups struct {
+	measurements map[rollupKey]*measurementRollup
+	errors       map[rollupKey]int
+	gaps         map[rollupKey]*gapRollup
+}
+
+func newDayRollups() *dayRollups {
+	return &dayRollups{
+		measurements: make(map[rollupKey]*measurementRollup),
+		errors:       make(map[rollupKey]int),
+		gaps:         make(map[rollupKey]*gapRollup),
+	}
+}
+
+// merge adds the rollups in o.
+func (d *dayRollups) merge(o *dayRollups) {
+	for k, m := range o.measurements {
+		if existing, ok := d.measurements[k]; ok {
+			existing.merge(m)
+		} else {
+			d.measurements[k] = m
+		}
+	}
+	for k, n := range o.errors {
+		d.errors[k] += n
+	}
+	for k, g := range o.gaps {
+		if existing, ok := d.gaps[k]; ok {
+			existing.merge(g)
+		} else {
+			d.gaps[k] = g
+		}
+	}
+}
+
+// rollupDay aggregates the raw results of a day, returning the largest check id included: only
+// checks up to it may be deleted. Results which arrive after the day was rolled up (ex. replayed
+// from a spool) are merged into its existing rollups.
+func (r *sqlReader) rollupDay(ctx context.Context, d time.Time, now time.Time) (rolledUp bool, maxID int64, err error) {
+	var prevMaxID int64
+	err = r.builder().Select("max_check_id").From("rollup_days").Where(sq.Eq{"day": d}).
+		QueryRowContext(ctx).Scan(&prevMaxID)
+	done := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+	inDay := sq.And{sq.GtOrEq{"checks.ts": d}, sq.Lt{"checks.ts": d.Add(oneDay)}}
+	var latest sql.NullInt64
+	if err := r.builder().Select("MAX(checks.id)").From("checks").Where(inDay).
+		QueryRowContext(ctx).Scan(&latest); err != nil {
+		return false, 0, fmt.Errorf("finding latest check: %w", err)
+	}
+	if done && latest.Int64 <= prevMaxID {
+		// The remaining checks were rolled up, but not all deleted.
+		return false, prevMaxID, nil
+	}
+	maxID = latest.Int64
+
+	rollups, err := r.aggregateChecks(ctx, d, append(inDay, sq.Gt{"checks.id": prevMaxID}, sq.LtOrEq{"checks.id": maxID}))
+	if err != nil {
+		return false, 0, err
+	}
+	if done {
+		var late int
+		for k, g := range rollups.gaps {
+			if k.resolution == resolutionDay {
+				late += g.cycles
+			}
+		}
+		log.Ctx(ctx).Warn().Str("day", d.Format("2006-01-02")).Int("checks", late).
+			Msg("merging checks which arrived after their day was rolled up")
+		existing, err := r.loadDayRollups(ctx, d)
+		if err != nil {
+			return false, 0, err
+		}
+		existing.merge(rollups)
+		rollups = existing
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	ins := sq.StatementBuilder.PlaceholderFormat(r.dialect.placeholder).RunWith(tx)
+	if done {
+		// The merged rollups replace the day's existing ones.
+		for _, table := range []string{"measurement_rollups", "error_rollups", "gap_rollups"} {
+			_, err = ins.Delete(table).
+				Where(sq.GtOrEq{"bucket_start": d}).
+				Where(sq.Lt{"bucket_start": d.Add(oneDay)}).
+				ExecContext(ctx)
+			if err != nil {
+				return false, 0, fmt.Errorf("deleting %s: %w", table, err)
+			}
+		}
+	}
+
+	var values [][]interface{}
+	for _, k := range sortedRollupKeys(rollups.measurements) {
+		m := rollups.measurements[k]
+		values = append(values, []interface{}{
+			k.resolution, k.appID, k.bucket, k.name, m.samples, m.min, m.max, m.sum / float64(m.samples),
+			m.p50, m.p90, m.p99,
+		})
+	}
+	if err = insertBatches(ctx, ins.Insert("measurement_rollups").Columns(
+		"resolution", "app_id", "bucket_start", "measurement", "samples",
+		"min_value", "max_value", "avg_value", "p50", "p90", "p99",
+	), values); err != nil {
+		return false, 0, fmt.Errorf("storing measurement rollups: %w", err)
+	}
+
+	values = values[:0]
+	for _, k := range sortedRollupKeys(rollups.errors) {
+		values = append(values, []interface{}{k.resolution, k.appID, k.bucket, k.name, rollups.errors[k]})
+	}
+	if err = insertBatches(ctx, ins.Insert("error_rollups").Columns(
+		"resolution", "app_id", "bucket_start", "check_name", "error_count",
+	), values); err != nil {
+		return false, 0, fmt.Errorf("storing error rollups: %w", err)
+	}
+
+	values = values[:0]
+	for _, k := range sortedRollupKeys(rollups.gaps) {
+		g := rollups.gaps[k]
+		values = append(values, []interface{}{
+			k.resolution, k.appID, k.bucket, g.cycles, g.first, g.last, g.maxGap.Milliseconds(), g.maxGapTS,
+		})
+	}
+	if err = insertBatches(ctx, ins.Insert("gap_rollups").Columns(
+		"resolution", "app_id", "bucket_start", "cycles", "first_ts", "last_ts", "max_gap_ms", "max_gap_ts",
+	), values); err != nil {
+		return false, 0, fmt.Errorf("storing gap rollups: %w", err)
+	}
+
+	if done {
+		_, err = ins.Update("rollup_days").Set("rolled_up_at", now).Set("max_check_id", maxID).
+			Where(sq.Eq{"day": d}).ExecContext(ctx)
+	} else {
+		_, err = ins.Insert("rollup_days").Columns("day", "rolled_up_at", "max_check_id").
+			Values(d, now, maxID).ExecContext(ctx)
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("marking day rolled up: %w", err)
+	}
+	return true, maxID, nil
+}
+
+// aggregateChecks rolls up the checks of day d matching cond.
+func (r *sqlReader) aggregateChecks(ctx context.Context, d time.Time, cond sq.And) (*dayRollups, error) {
+	buckets := func(ts time.Time) map[string]time.Time {
+		return map[string]time.Time{
+			resolutionHour: ts.Truncate(time.Hour),
+			resolutionDay:  d,
+		}
+	}
+
+	measurements := make(map[rollupKey][]float64)
+	rows, err := r.builder().Select("COALESCE(instances.app_id, '')", "checks.ts", "check_measurements.measurement", "check_measurements.value").
+		From("check_measurements").
+		InnerJoin("checks ON checks.id = check_measurements.check_id").
+		InnerJoin("instances ON instances.id = checks.instance_id").
+		Where(cond).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying measurements: %w", err)
+	}
+	for rows.Next() {
+		var appID, name string
+		var ts time.Time
+		var v float64
+		if err := rows.Scan(&appID, &ts, &name, &v); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading measurements: %w", err)
+		}
+		for resolution, bucket := range buckets(ts.UTC()) {
+			k := rollupKey{resolution, appID, bucket, name}
+			measurements[k] = append(measurements[k], v)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading measurements: %w", err)
+	}
+	out := newDayRollups()
+	for k, vs := range measurements {
+		out.measurements[k] = newMeasurementRollup(vs)
+	}
+
+	rows, err = r.builder().Select("COALESCE(instances.app_id, '')", "checks.ts", "check_errors.check_name").
+		From("check_errors").
+		InnerJoin("checks ON checks.id = check_errors.check_id").
+		InnerJoin("instances ON instances.id = checks.instance_id").
+		Where(cond).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying errors: %w", err)
+	}
+	for rows.Next() {
+		var appID, name string
+		var ts time.Time
+		if err := rows.Scan(&appID, &ts, &name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading errors: %w", err)
+		}
+		for resolution, bucket := range buckets(ts.UTC()) {
+			out.errors[rollupKey{resolution, appID, bucket, name}]++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading errors: %w", err)
+	}
+
+	rows, err = r.builder().Select("COALESCE(instances.app_id, '')", "checks.ts").
+		From("checks").
+		InnerJoin("instances ON instances.id = checks.instance_id").
+		Where(cond).
+		OrderBy("checks.ts").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying checks: %w", err)
+	}
+	for rows.Next() {
+		var appID string
+		var ts time.Time
+		if err := rows.Scan(&appID, &ts); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading checks: %w", err)
+		}
+		for resolution, bucket := range buckets(ts.UTC()) {
+			k := rollupKey{resolution: resolution, appID: appID, bucket: bucket}
+			if out.gaps[k] == nil {
+				out.gaps[k] = &gapRollup{}
+			}
+			out.gaps[k].add(ts.UTC())
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading checks: %w", err)
+	}
+	return out, nil
+}
+
+// loadDayRollups reads the stored rollups of day d.
+func (r *sqlReader) loadDayRollups(ctx context.Context, d time.Time) (*dayRollups, error) {
+	inDay := sq.And{sq.GtOrEq{"bucket_start": d}, sq.Lt{"bucket_start": d.Add(oneDay)}}
+	out := newDayRollups()
+
+	rows, err := r.builder().Select("resolution", "app_id", "bucket_start", "measurement", "samples",
+		"min_value", "max_value", "avg_value", "p50", "p90", "p99").
+		From("measurement_rollups").Where(inDay).QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying measurement rollups: %w", err)
+	}
+	for rows.Next() {
+		var k rollupKey
+		var bucket nullTime
+		var avg float64
+		m := &measurementRollup{}
+		if err := rows.Scan(&k.resolution, &k.appID, &bucket, &k.name, &m.samples,
+			&m.min, &m.max, &avg, &m.p50, &m.p90, &m.p99); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading measurement rollups: %w", err)
+		}
+		k.bucket, m.sum = bucket.Time, avg*float64(m.samples)
+		out.measurements[k] = m
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading measurement rollups: %w", err)
+	}
+
+	rows, err = r.builder().Select("resolution", "app_id", "bucket_start", "check_name", "error_count").
+		From("error_rollups").Where(inDay).QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying error rollups: %w", err)
+	}
+	for rows.Next() {
+		var k rollupKey
+		var bucket nullTime
+		var n int
+		if err := rows.Scan(&k.resolution, &k.appID, &bucket, &k.name, &n); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading error rollups: %w", err)
+		}
+		k.bucket = bucket.Time
+		out.errors[k] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading error rollups: %w", err)
+	}
+
+	rows, err = r.builder().Select("resolution", "app_id", "bucket_start", "cycles", "first_ts", "last_ts",
+		"max_gap_ms", "max_gap_ts").
+		From("gap_rollups").Where(inDay).QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying gap rollups: %w", err)
+	}
+	for rows.Next() {
+		var k rollupKey
+		var bucket, first, last, maxGapTS nullTime
+		var maxGapMS int64
+		g := &gapRollup{}
+		if err := rows.Scan(&k.resolution, &k.appID, &bucket, &g.cycles, &first, &last, &maxGapMS, &maxGapTS); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading gap rollups: %w", err)
+		}
+		k.bucket, g.first, g.last, g.maxGapTS = bucket.Time, first.Time, last.Time, maxGapTS.Time
+		g.maxGap = time.Duration(maxGapMS) * time.Millisecond
+		out.gaps[k] = g
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading gap rollups: %w", err)
+	}
+	return out, nil
+}
+
+// deleteChecksBefore deletes checks older than ts with ids up to maxID, which have been rolled up,
+// along with their errors and measurements.
+func (r *sqlReader) deleteChecksBefore(ctx context.Context, ts time.Time, maxID int64, batchSize int) (int, error) {
+	var deleted int
+	for {
+		rows, err := r.builder().Select("id").From("checks").
+			Where(sq.Lt{"ts": ts}).
+			Where(sq.LtOrEq{"id": maxID}).
+			OrderBy("id").
+			Limit(uint64(batchSize)).
+			QueryContext(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return deleted, err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return deleted, err
+		}
+		if len(ids) == 0 {
+			return deleted, nil
+		}
+		if err := r.deleteChecks(ctx, ids); err != nil {
+			return deleted, err
+		}
+		deleted += len(ids)
+	}
+}
+
+func (r *sqlReader) deleteChecks(ctx context.Context, ids []int64) (err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	del := sq.StatementBuilder.PlaceholderFormat(r.dialect.placeholder).RunWith(tx)
+	if _, err = del.Delete("check_errors").Where(sq.Eq{"check_id": ids}).ExecContext(ctx); err != nil {
+		return err
+	}
+	if _, err = del.Delete("check_measurements").Where(sq.Eq{"check_id": ids}).ExecContext(ctx); err != nil {
+		return err
+	}
+	_, err = del.Delete("checks").Where(sq.Eq{"id": ids}).ExecContext(ctx)
+	return err
+}
+
+func insertBatches(ctx context.Context, q sq.InsertBuilder, values [][]interface{}) error {
+	for len(values) > 0 {
+		n := len(values)
+		if n > rollupInsertBatchSize {
+			n = rollupInsertBatchSize
+		}
+		batch := q
+		for _, v := range values[:n] {
+			batch = batch.Values(v...)
+		}
+		if _, err := batch.ExecContext(ctx); err != nil {
+			return err
+		}
+		values = values[n:]
+	}
+	return nil
+}
+
+// sortedRollupKeys orders rollups so they are inserted deterministically.
+func sortedRollupKeys[T any](m map[rollupKey]T) []rollupKey {
+	keys := make([]rollupKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch {
+		case a.resolution != b.resolution:
+			return a.resolution < b.resolution
+		case a.appID != b.appID:
+			return a.appID < b.appID
+		case !a.bucket.Equal(b.bucket):
+			return a.bucket.Before(b.bucket)
+		}
+		return a.name < b.name
+	})
+	return keys
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
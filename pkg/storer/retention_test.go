@@ -0,0 +1,174 @@
+package storer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteStorer(ctx, "sqlite:"+filepath.Join(t.TempDir(), "self-check.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(10 * oneDay)
+	instance := &check.Instance{UUID: "a", AppID: "app", StartedAt: start}
+	// Two days of old results, with a 2 hour gap on the first day and a 3 hour gap across days, and
+	// a recent result which must be kept.
+	for i, ts := range []time.Time{
+		start.Add(time.Hour),
+		start.Add(3 * time.Hour),
+		start.Add(21 * time.Hour),
+		start.Add(oneDay),
+		start.Add(9 * oneDay),
+	} {
+		r := check.CheckResults{
+			Instance:     instance,
+			TS:           ts,
+			Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: float64(i)}},
+		}
+		if i == 1 {
+			r.Errors = []check.CheckError{{Check: "database", Error: "timeout"}}
+		}
+		if err := s.SaveCheckResults(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var before []time.Duration
+	err = s.AnalyzeLongestGapPerApp(ctx, start, now, nil, func(appID string, interval time.Duration, ts time.Time) {
+		before = append(before, interval)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.(Retainer).ApplyRetention(ctx, RetentionPolicy{RawAge: 5 * oneDay, BatchSize: 1}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RetentionResult{DaysRolledUp: 2, ChecksDeleted: 4}); res != want {
+		t.Errorf("expected %+v, got %+v", want, res)
+	}
+	stats, err := s.QueryResultStats(ctx, ResultsFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Cycles != 1 {
+		t.Errorf("expected the recent check to be kept, got %d cycles", stats.Cycles)
+	}
+
+	r := s.(*sqliteStorer).sqlReader
+	var samples int
+	var maxValue float64
+	err = r.builder().Select("SUM(samples)", "MAX(max_value)").From("measurement_rollups").
+		Where("resolution = ? AND measurement = ?", resolutionDay, "database_duration").
+		QueryRowContext(ctx).Scan(&samples, &maxValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples != 4 || maxValue != 3 {
+		t.Errorf("expected 4 samples with a max of 3, got %d and %v", samples, maxValue)
+	}
+	var errorCount int
+	err = r.builder().Select("SUM(error_count)").From("error_rollups").
+		Where("resolution = ? AND check_name = ?", resolutionHour, "database").
+		QueryRowContext(ctx).Scan(&errorCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errorCount != 1 {
+		t.Errorf("expected 1 hourly database error, got %d", errorCount)
+	}
+
+	// The rolled up days must still be reflected in the analysis.
+	var after []time.Duration
+	err = s.AnalyzeLongestGapPerApp(ctx, start, now, nil, func(appID string, interval time.Duration, ts time.Time) {
+		after = append(after, interval)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 1 || len(after) != 1 || before[0] != after[0] {
+		t.Errorf("expected the same longest gap before and after retention, got %v and %v", before, after)
+	}
+
+	// Rerunning is a no-op, and expired rollups are deleted.
+	res, err = s.(Retainer).ApplyRetention(ctx, RetentionPolicy{RawAge: 5 * oneDay, HourlyAge: 5 * oneDay}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DaysRolledUp != 0 || res.ChecksDeleted != 0 || res.RollupsDeleted == 0 {
+		t.Errorf("expected only hourly rollups to be deleted, got %+v", res)
+	}
+}
+
+func TestApplyRetentionLateResults(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteStorer(ctx, "sqlite:"+filepath.Join(t.TempDir(), "self-check.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(10 * oneDay)
+	instance := &check.Instance{UUID: "a", AppID: "app", StartedAt: start}
+	save := func(ts time.Time, v float64) {
+		t.Helper()
+		err := s.SaveCheckResults(ctx, check.CheckResults{
+			Instance:     instance,
+			TS:           ts,
+			Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: v}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(start.Add(time.Hour), 1)
+	save(start.Add(5*time.Hour), 2)
+	policy := RetentionPolicy{RawAge: 5 * oneDay}
+	if _, err := s.(Retainer).ApplyRetention(ctx, policy, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// A result replayed after its day was rolled up is merged rather than deleted unseen.
+	save(start.Add(10*time.Hour), 5)
+	res, err := s.(Retainer).ApplyRetention(ctx, policy, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RetentionResult{DaysRolledUp: 1, ChecksDeleted: 1}); res != want {
+		t.Errorf("expected %+v, got %+v", want, res)
+	}
+
+	r := s.(*sqliteStorer).sqlReader
+	var samples, cycles int
+	var maxValue, avg float64
+	var maxGapMS int64
+	err = r.builder().Select("samples", "max_value", "avg_value").From("measurement_rollups").
+		Where("resolution = ? AND measurement = ?", resolutionDay, "database_duration").
+		QueryRowContext(ctx).Scan(&samples, &maxValue, &avg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samples != 3 || maxValue != 5 || avg != 8.0/3 {
+		t.Errorf("expected 3 samples with a max of 5 and avg of 8/3, got %d, %v and %v", samples, maxValue, avg)
+	}
+	err = r.builder().Select("cycles", "max_gap_ms").From("gap_rollups").
+		Where("resolution = ?", resolutionDay).
+		QueryRowContext(ctx).Scan(&cycles, &maxGapMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cycles != 3 || time.Duration(maxGapMS)*time.Millisecond != 5*time.Hour {
+		t.Errorf("expected 3 cycles with a longest gap of 5h, got %d and %dms", cycles, maxGapMS)
+	}
+}
@@ -0,0 +1,461 @@
+package storer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+const (
+	// DefaultSpoolMaxBytes is used when WithSpool is given no size cap.
+	DefaultSpoolMaxBytes = 64 << 20
+	// spoolSegments is the number of segments the size cap is split into. The oldest segment is
+	// dropped when the cap is exceeded.
+	spoolSegments       = 8
+	spoolSegmentPrefix  = "segment-"
+	spoolSegmentSuffix  = ".jsonl"
+	spoolCheckpointName = "checkpoint"
+	// spoolReplayBatch limits the number of entries read into memory per replay pass.
+	spoolReplayBatch = 100
+)
+
+// SpoolStats describes the results waiting in a spool.
+type SpoolStats struct {
+	Pending int
+	Bytes   int64
+	// Dropped counts results discarded to keep the spool under its size cap since startup.
+	Dropped int
+}
+
+// Spooler is implemented by storers which spool results that fail to save. ok is false if no spool
+// is configured.
+type Spooler interface {
+	SpoolStats() (stats SpoolStats, ok bool)
+}
+
+type spoolEntry struct {
+	Seq    uint64
+	Result check.CheckResults
+}
+
+// spoolSegment is a file of consecutive entries, named after the seq of its first entry.
+type spoolSegment struct {
+	name string
+	// first and last are the seqs of the first and last entries, or zero if there are none.
+	first, last uint64
+	size        int64
+}
+
+// spool is a directory of append-only segment files holding results waiting to be saved, one JSON
+// entry per line. Entries are numbered, and a checkpoint file records the last entry replayed, so
+// replays never rewrite segments. Segments are deleted once fully replayed, and the oldest is
+// dropped when the size cap is exceeded.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []*spoolSegment
+	// active is open for appending to the last segment.
+	active *os.File
+	seq    uint64
+	// acked is the seq of the last entry replayed.
+	acked   uint64
+	size    int64
+	dropped int
+	// readSegment and readOffset are where the next replay pass starts reading.
+	readSegment string
+	readOffset  int64
+}
+
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultSpoolMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating spool directory: %w", err)
+	}
+	s := &spool{dir: dir, maxBytes: maxBytes}
+	b, err := os.ReadFile(filepath.Join(dir, spoolCheckpointName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading spool checkpoint: %w", err)
+	} else if err == nil {
+		if s.acked, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return nil, fmt.Errorf("parsing spool checkpoint: %w", err)
+		}
+	}
+	s.seq = s.acked
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing spool directory: %w", err)
+	}
+	var names []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), spoolSegmentPrefix) && strings.HasSuffix(f.Name(), spoolSegmentSuffix) {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		seg, err := s.scanSegment(name, i == len(names)-1)
+		if err != nil {
+			return nil, err
+		}
+		if seg.last > s.seq {
+			s.seq = seg.last
+		}
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+	}
+	if err := s.removeReplayed(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// scanSegment reads the seqs of a segment's entries. Lines which can't be decoded are skipped. A
+// partial line at the end of the last segment (ex. a write interrupted by a crash) is truncated so
+// new entries start on a fresh line.
+func (s *spool) scanSegment(name string, last bool) (*spoolSegment, error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening spool segment: %w", err)
+	}
+	defer f.Close()
+	seg := &spoolSegment{name: name}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 && last {
+				if err := f.Truncate(seg.size); err != nil {
+					return nil, fmt.Errorf("truncating spool segment: %w", err)
+				}
+			} else {
+				seg.size += int64(len(line))
+			}
+			return seg, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading spool segment: %w", err)
+		}
+		seg.size += int64(len(line))
+		var header struct{ Seq uint64 }
+		if err := json.Unmarshal(line, &header); err != nil || header.Seq == 0 {
+			continue
+		}
+		if seg.first == 0 {
+			seg.first = header.Seq
+		}
+		seg.last = header.Seq
+	}
+}
+
+// pending counts the entries in a segment which haven't been replayed. Seqs are consecutive within
+// a segment.
+func (s *spool) pending(seg *spoolSegment) int {
+	if seg.last <= s.acked {
+		return 0
+	}
+	first := seg.first
+	if first <= s.acked {
+		first = s.acked + 1
+	}
+	return int(seg.last - first + 1)
+}
+
+// Append adds a result to the end of the spool, dropping the oldest segments if the size cap is
+// exceeded. The entry is synced to disk before Append returns.
+func (s *spool) Append(result check.CheckResults) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := json.Marshal(spoolEntry{Seq: s.seq + 1, Result: result})
+	if err != nil {
+		return fmt.Errorf("encoding spool entry: %w", err)
+	}
+	b = append(b, '\n')
+	size := int64(len(b))
+	if size > s.maxBytes {
+		s.dropped++
+		return fmt.Errorf("result of %d bytes exceeds the spool size cap", size)
+	}
+
+	if s.active == nil || s.segments[len(s.segments)-1].size > 0 &&
+		s.segments[len(s.segments)-1].size+size > s.maxBytes/spoolSegments {
+		if err := s.roll(s.seq + 1); err != nil {
+			return err
+		}
+	}
+	var dropped bool
+	for s.size+size > s.maxBytes && len(s.segments) > 1 {
+		seg := s.segments[0]
+		if err := os.Remove(filepath.Join(s.dir, seg.name)); err != nil {
+			return fmt.Errorf("dropping spool segment: %w", err)
+		}
+		s.dropped += s.pending(seg)
+		s.size -= seg.size
+		s.segments = s.segments[1:]
+		if s.readSegment == seg.name {
+			s.readSegment, s.readOffset = "", 0
+		}
+		dropped = true
+	}
+	if dropped {
+		if err := syncDir(s.dir); err != nil {
+			return err
+		}
+	}
+
+	seg := s.segments[len(s.segments)-1]
+	if _, err := s.active.Write(b); err != nil {
+		// Drop any partial write so the next entry starts on a fresh line.
+		_ = s.active.Truncate(seg.size)
+		return fmt.Errorf("appending to spool: %w", err)
+	}
+	s.seq++
+	if seg.first == 0 {
+		seg.first = s.seq
+	}
+	seg.last = s.seq
+	seg.size += size
+	s.size += size
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("syncing spool: %w", err)
+	}
+	return nil
+}
+
+// roll starts a new segment, whose first entry will have the given seq. It must be called with the
+// lock held.
+func (s *spool) roll(first uint64) error {
+	name := fmt.Sprintf("%s%020d%s", spoolSegmentPrefix, first, spoolSegmentSuffix)
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating spool segment: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+	if s.active != nil {
+		s.active.Close()
+	}
+	s.active = f
+	if n := len(s.segments); n == 0 || s.segments[n-1].name != name {
+		s.segments = append(s.segments, &spoolSegment{name: name})
+	} else if info, err := f.Stat(); err == nil {
+		// The last segment was reopened after a restart.
+		s.segments[n-1].size = info.Size()
+	}
+	return nil
+}
+
+// replayEntry is an entry read for replay, with the position following it.
+type replayEntry struct {
+	spoolEntry
+	segment string
+	offset  int64
+}
+
+// next reads up to n entries which haven't been replayed, starting where the last pass stopped.
+// Entries which can't be decoded are skipped, since they can never be saved.
+func (s *spool) next(n int) ([]replayEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start, offset := 0, int64(0)
+	for i, seg := range s.segments {
+		if seg.name == s.readSegment {
+			start, offset = i, s.readOffset
+		}
+	}
+	var out []replayEntry
+	for _, seg := range s.segments[start:] {
+		if seg.last > s.acked {
+			var err error
+			if out, err = s.readSegmentFrom(seg, offset, n, out); err != nil {
+				return nil, err
+			}
+		}
+		if len(out) >= n {
+			break
+		}
+		offset = 0
+	}
+	return out, nil
+}
+
+// readSegmentFrom appends entries read from the offset of a segment to out, until out holds n.
+func (s *spool) readSegmentFrom(seg *spoolSegment, offset int64, n int, out []replayEntry) ([]replayEntry, error) {
+	f, err := os.Open(filepath.Join(s.dir, seg.name))
+	if err != nil {
+		return nil, fmt.Errorf("opening spool segment: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(io.NewSectionReader(f, offset, seg.size-offset))
+	for len(out) < n {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading spool segment: %w", err)
+		}
+		offset += int64(len(line))
+		var e spoolEntry
+		if err := json.Unmarshal(line, &e); err != nil || e.Seq <= s.acked {
+			continue
+		}
+		out = append(out, replayEntry{spoolEntry: e, segment: seg.name, offset: offset})
+	}
+	return out, nil
+}
+
+// Replay saves spooled results in order, checkpointing them as they succeed. It stops at the first
+// failure, leaving the remaining results for a later replay. Results are saved without holding the
+// lock so new results can be spooled in the meantime.
+func (s *spool) Replay(ctx context.Context, save func(ctx context.Context, result check.CheckResults) error) (int, error) {
+	// Results from the same instance share an Instance so it is only upserted once per replay.
+	instances := make(map[string]*check.Instance)
+	var replayed int
+	for ctx.Err() == nil {
+		entries, err := s.next(spoolReplayBatch)
+		if err != nil || len(entries) == 0 {
+			return replayed, err
+		}
+		var saved *replayEntry
+		var saveErr error
+		for i := range entries {
+			e := &entries[i]
+			if e.Result.Instance != nil {
+				if instance, ok := instances[e.Result.Instance.UUID]; ok {
+					e.Result.Instance = instance
+				} else {
+					instances[e.Result.Instance.UUID] = e.Result.Instance
+				}
+			}
+			if saveErr = save(ctx, e.Result); saveErr != nil {
+				break
+			}
+			saved = e
+			replayed++
+		}
+		if saved != nil {
+			if err := s.ack(saved); err != nil {
+				return replayed, err
+			}
+		}
+		if saveErr != nil {
+			return replayed, saveErr
+		}
+	}
+	return replayed, ctx.Err()
+}
+
+// ack checkpoints the entries up to and including e as replayed, and deletes segments which have
+// been fully replayed.
+func (s *spool) ack(e *replayEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := writeFileSync(s.dir, spoolCheckpointName, []byte(strconv.FormatUint(e.Seq, 10)+"\n")); err != nil {
+		return fmt.Errorf("writing spool checkpoint: %w", err)
+	}
+	if e.Seq > s.acked {
+		s.acked = e.Seq
+	}
+	s.readSegment, s.readOffset = e.segment, e.offset
+	return s.removeReplayed()
+}
+
+// removeReplayed deletes the oldest segments while they have been fully replayed. It must be called
+// with the lock held.
+func (s *spool) removeReplayed() error {
+	var removed bool
+	for len(s.segments) > 0 && s.segments[0].last <= s.acked {
+		seg := s.segments[0]
+		if len(s.segments) == 1 && s.active != nil {
+			s.active.Close()
+			s.active = nil
+		}
+		if err := os.Remove(filepath.Join(s.dir, seg.name)); err != nil {
+			return fmt.Errorf("removing spool segment: %w", err)
+		}
+		s.size -= seg.size
+		s.segments = s.segments[1:]
+		if s.readSegment == seg.name {
+			s.readSegment, s.readOffset = "", 0
+		}
+		removed = true
+	}
+	if removed {
+		return syncDir(s.dir)
+	}
+	return nil
+}
+
+// close closes the active segment. Appending afterwards reopens it.
+func (s *spool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+}
+
+// Stats reports the current state of the spool.
+func (s *spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending int
+	for _, seg := range s.segments {
+		pending += s.pending(seg)
+	}
+	return SpoolStats{Pending: pending, Bytes: s.size, Dropped: s.dropped}
+}
+
+// writeFileSync atomically replaces a file in dir, syncing the file and the directory so the
+// replacement survives a crash.
+func writeFileSync(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs a directory, so files created, renamed or removed in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("opening spool directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("syncing spool directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package storer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	gomock "github.com/golang/mock/gomock"
+)
+
+func TestSpool(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := openSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	instance := &check.Instance{UUID: "a"}
+	for i := 0; i < 3; i++ {
+		if err := s.Append(check.CheckResults{Instance: instance, TS: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Entries survive a restart and are replayed in order, stopping at the first failure.
+	s, err = openSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.Stats(); stats.Pending != 3 || s.seq != 3 {
+		t.Fatalf("expected 3 pending entries after reopening, got %+v and seq %d", stats, s.seq)
+	}
+	var saved []time.Time
+	var instances []*check.Instance
+	n, err := s.Replay(ctx, func(ctx context.Context, result check.CheckResults) error {
+		if len(saved) == 2 {
+			return errors.New("database unavailable")
+		}
+		saved = append(saved, result.TS)
+		instances = append(instances, result.Instance)
+		return nil
+	})
+	if err == nil || n != 2 || s.Stats().Pending != 1 {
+		t.Fatalf("expected 2 results replayed and 1 pending, got %d, %+v, %v", n, s.Stats(), err)
+	}
+	if !saved[0].Equal(start) || !saved[1].Equal(start.Add(time.Second)) {
+		t.Errorf("expected results in order, got %v", saved)
+	}
+	if instances[0] != instances[1] || instances[0].UUID != "a" {
+		t.Error("expected replayed results to share an instance")
+	}
+	n, err = s.Replay(ctx, func(ctx context.Context, result check.CheckResults) error {
+		if !result.TS.Equal(start.Add(2 * time.Second)) {
+			t.Errorf("unexpected result %s", result.TS)
+		}
+		return nil
+	})
+	if err != nil || n != 1 || s.Stats().Pending != 0 {
+		t.Fatalf("expected the last result to be replayed, got %d, %+v, %v", n, s.Stats(), err)
+	}
+}
+
+func TestSpoolDropsOldest(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(check.CheckResults{}); err != nil {
+		t.Fatal(err)
+	}
+	// Cap the spool at two entries, so each entry gets its own segment.
+	s.maxBytes = 2 * s.size
+	for i := 0; i < 3; i++ {
+		if err := s.Append(check.CheckResults{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := s.next(spoolReplayBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.Stats(); stats.Pending != 2 || stats.Dropped != 2 || len(entries) != 2 || entries[0].Seq != 3 {
+		t.Errorf("expected the 2 newest entries to be kept, got %+v and %+v", stats, entries)
+	}
+}
+
+func TestSpoolCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := openSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if err := s.Append(check.CheckResults{TS: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fail := errors.New("database unavailable")
+	n, err := s.Replay(ctx, func(ctx context.Context, result check.CheckResults) error {
+		if result.TS.After(start) {
+			return fail
+		}
+		return nil
+	})
+	if err != fail || n != 1 {
+		t.Fatalf("expected 1 result replayed, got %d, %v", n, err)
+	}
+	s.close()
+
+	// Simulate a crash part way through appending an entry.
+	segments, err := filepath.Glob(filepath.Join(dir, spoolSegmentPrefix+"*"))
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("expected a single segment, got %v, %v", segments, err)
+	}
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"Seq":4,"Res`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// The checkpoint survives a restart, so replayed entries aren't saved again.
+	s, err = openSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := s.Stats(); stats.Pending != 2 || s.seq != 3 {
+		t.Fatalf("expected 2 pending entries after reopening, got %+v and seq %d", stats, s.seq)
+	}
+	if err := s.Append(check.CheckResults{TS: start.Add(3 * time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	var saved []time.Time
+	n, err = s.Replay(ctx, func(ctx context.Context, result check.CheckResults) error {
+		saved = append(saved, result.TS)
+		return nil
+	})
+	if err != nil || n != 3 || !saved[0].Equal(start.Add(time.Second)) || !saved[2].Equal(start.Add(3*time.Second)) {
+		t.Fatalf("expected the 3 remaining results to be replayed in order, got %d, %v, %v", n, saved, err)
+	}
+	if stats := s.Stats(); stats.Pending != 0 || stats.Bytes != 0 {
+		t.Errorf("expected an empty spool, got %+v", stats)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, spoolSegmentPrefix+"*")); len(segments) != 0 {
+		t.Errorf("expected replayed segments to be removed, got %v", segments)
+	}
+}
+
+func TestSpoolReplaysAtStartup(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir := t.TempDir()
+	s, err := openSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spooled := check.CheckResults{TS: time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)}
+	if err := s.Append(spooled); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+
+	m := NewMockStorer(ctrl)
+	c := &commonStorer{}
+	if err := c.init(saveEach(m.SaveCheckResults), WithSpool(dir, 0)); err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	m.EXPECT().SaveCheckResults(gomock.Any(), spooled).Return(nil)
+	c.AsyncQueryRetry(ctx, []time.Duration{0}, func(ctx context.Context, attempt int) error { return nil })
+	c.wg.Wait()
+	if stats, _ := c.SpoolStats(); stats.Pending != 0 {
+		t.Errorf("expected the spool to be replayed, got %+v", stats)
+	}
+}
+
+func TestAsyncSaveCheckResultsSpools(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockStorer(ctrl)
+	c := &commonStorer{}
+	if err := c.init(saveEach(m.SaveCheckResults), WithSpool(t.TempDir(), 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	first := check.CheckResults{TS: time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)}
+	m.EXPECT().SaveCheckResults(gomock.Any(), first).Return(errors.New("failed to save"))
+	c.AsyncSaveCheckResults(ctx, first, []time.Duration{0})
+	c.wg.Wait()
+	if stats, _ := c.SpoolStats(); stats.Pending != 1 {
+		t.Fatalf("expected the failed result to be spooled, got %+v", stats)
+	}
+
+	// The next successful save replays the spooled result, with its failed attempt recorded.
+	second := check.CheckResults{TS: first.TS.Add(time.Second)}
+	spooled := first
+	spooled.Errors = []check.CheckError{{Check: "result_save_attempt_1", Error: "failed to save"}}
+	gomock.InOrder(
+		m.EXPECT().SaveCheckResults(gomock.Any(), second).Return(nil),
+		m.EXPECT().SaveCheckResults(gomock.Any(), spooled).Return(nil),
+	)
+	c.AsyncSaveCheckResults(ctx, second, []time.Duration{0})
+	c.wg.Wait()
+	if stats, _ := c.SpoolStats(); stats.Pending != 0 {
+		t.Errorf("expected the spool to be empty, got %+v", stats)
+	}
+}
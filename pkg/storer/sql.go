@@ -0,0 +1,901 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// hasErrors is a condition matching checks which recorded errors.
+const hasErrors = `EXISTS (SELECT 1 FROM check_errors WHERE check_errors.check_id = checks.id)`
+
+// sqlReader implements the read and maintenance methods which are common to the SQL storers.
+type sqlReader struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (r *sqlReader) builder() sq.StatementBuilderType {
+	return sq.StatementBuilder.PlaceholderFormat(r.dialect.placeholder).RunWith(r.db)
+}
+
+// QueryResults returns stored check results matching the filter, newest first.
+func (r *sqlReader) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	after, err := parseCursor(page.Cursor)
+	if err != nil {
+		return ResultsPage{}, err
+	}
+	cond := r.resultsCond(filter)
+	if after != nil {
+		cond = append(cond, sq.Or{
+			sq.Lt{"checks.ts": after.ts},
+			sq.And{sq.Eq{"checks.ts": after.ts}, sq.Lt{"checks.id": after.id}},
+		})
+	}
+	limit := page.limit()
+	rows, err := r.builder().Select(append([]string{"checks.id", "checks.uuid", "checks.ts"}, instanceColumns...)...).
+		From("checks").
+		InnerJoin("instances ON instances.id = checks.instance_id").
+		Where(cond).
+		OrderBy("checks.ts DESC", "checks.id DESC").
+		Limit(uint64(limit + 1)).
+		QueryContext(ctx)
+	if err != nil {
+		return ResultsPage{}, fmt.Errorf("querying checks: %w", err)
+	}
+	defer rows.Close()
+
+	var out ResultsPage
+	var ids []int64
+	var last cursor
+	instances := make(map[int64]*check.Instance)
+	for rows.Next() {
+		if len(out.Results) == limit {
+			out.NextCursor = last.String()
+			break
+		}
+		var res check.CheckResults
+		var id int64
+		var resultID sql.NullString
+		var row instanceRow
+		if err := rows.Scan(append([]interface{}{&id, &resultID, &res.TS}, row.dest()...)...); err != nil {
+			return ResultsPage{}, fmt.Errorf("reading checks: %w", err)
+		}
+		res.ID = resultID.String
+		res.TS = res.TS.UTC()
+		instance, ok := instances[row.id]
+		if !ok {
+			instance = row.instance()
+			instances[row.id] = instance
+		}
+		res.Instance = instance
+		out.Results = append(out.Results, res)
+		ids = append(ids, id)
+		last = cursor{ts: res.TS, id: id}
+	}
+	if err := rows.Err(); err != nil {
+		return ResultsPage{}, fmt.Errorf("reading checks: %w", err)
+	}
+	// Release the connection before querying the details, as some drivers only allow one.
+	rows.Close()
+	if len(out.Results) == 0 {
+		return out, nil
+	}
+
+	index := make(map[int64]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+	if err := r.loadErrors(ctx, ids, func(id int64, e check.CheckError) {
+		res := &out.Results[index[id]]
+		res.Errors = append(res.Errors, e)
+	}); err != nil {
+		return ResultsPage{}, err
+	}
+	if err := r.loadMeasurements(ctx, ids, func(id int64, m check.CheckMeasurement) {
+		res := &out.Results[index[id]]
+		res.Measurements = append(res.Measurements, m)
+	}); err != nil {
+		return ResultsPage{}, err
+	}
+	if err := r.loadLabels(ctx, instances); err != nil {
+		return ResultsPage{}, err
+	}
+	for i := range out.Results {
+		out.Results[i] = out.Results[i].FilterChecks(filter.Checks)
+	}
+	return out, nil
+}
+
+// QueryResultStats summarizes stored check results matching the filter.
+func (r *sqlReader) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	var out ResultStats
+	var lastCheck nullTime
+	err := r.builder().Select(
+		"COUNT(*)",
+		"COALESCE(SUM(CASE WHEN "+hasErrors+" THEN 1 ELSE 0 END), 0)",
+		"MAX(checks.ts)",
+	).
+		From("checks").
+		InnerJoin("instances ON instances.id = checks.instance_id").
+		Where(r.resultsCond(filter)).
+		QueryRowContext(ctx).
+		Scan(&out.Cycles, &out.CyclesWithErrors, &lastCheck)
+	if err != nil {
+		return ResultStats{}, fmt.Errorf("querying result stats: %w", err)
+	}
+	out.LastCheck = lastCheck.Time
+	return out, nil
+}
+
+// instanceColumns are the instance columns scanned by instanceRow.
+var instanceColumns = []string{
+	"instances.id",
+	"instances.uuid",
+	"instances.app_id",
+	"instances.hostname",
+	"instances.public_ipv4",
+	"instances.started_at",
+	"instances.stopped_at",
+}
+
+// instanceRow scans an instance selected with instanceColumns.
+type instanceRow struct {
+	id                   int64
+	uuid, hostname       string
+	appID, publicIPv4    sql.NullString
+	startedAt, stoppedAt sql.NullTime
+}
+
+func (i *instanceRow) dest() []interface{} {
+	return []interface{}{&i.id, &i.uuid, &i.appID, &i.hostname, &i.publicIPv4, &i.startedAt, &i.stoppedAt}
+}
+
+func (i *instanceRow) instance() *check.Instance {
+	instance := &check.Instance{
+		UUID:       i.uuid,
+		AppID:      i.appID.String,
+		Hostname:   i.hostname,
+		PublicIPv4: i.publicIPv4.String,
+		StartedAt:  i.startedAt.Time.UTC(),
+	}
+	if i.stoppedAt.Valid {
+		instance.StoppedAt = i.stoppedAt.Time.UTC()
+	}
+	return instance
+}
+
+// ListInstances returns stored instances matching the filter, oldest first.
+func (r *sqlReader) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	cond := labelsCond(filter.Labels)
+	if len(filter.AppIDs) > 0 {
+		cond = append(cond, sq.Eq{"instances.app_id": filter.AppIDs})
+	}
+	if len(filter.UUIDs) > 0 {
+		cond = append(cond, sq.Eq{"instances.uuid": filter.UUIDs})
+	}
+	if !filter.Start.IsZero() {
+		cond = append(cond, sq.Or{sq.Eq{"instances.stopped_at": nil}, sq.GtOrEq{"instances.stopped_at": filter.Start.UTC()}})
+	}
+	if !filter.End.IsZero() {
+		cond = append(cond, sq.LtOrEq{"instances.started_at": filter.End.UTC()})
+	}
+	rows, err := r.builder().Select(instanceColumns...).
+		From("instances").
+		Where(cond).
+		OrderBy("instances.started_at", "instances.id").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying instances: %w", err)
+	}
+	defer rows.Close()
+	var out []*check.Instance
+	byID := make(map[int64]*check.Instance)
+	for rows.Next() {
+		var row instanceRow
+		if err := rows.Scan(row.dest()...); err != nil {
+			return nil, fmt.Errorf("reading instances: %w", err)
+		}
+		instance := row.instance()
+		out = append(out, instance)
+		byID[row.id] = instance
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading instances: %w", err)
+	}
+	rows.Close()
+	if len(out) == 0 {
+		return nil, nil
+	}
+	if err := r.loadLabels(ctx, byID); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// QueryMeasurements returns a series per instance and measurement of the check results matching the
+// filter, ordered by instance UUID and measurement.
+func (r *sqlReader) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	cond := r.resultsCond(filter)
+	if len(filter.Checks) > 0 {
+		durations := make([]string, 0, len(filter.Checks))
+		for _, c := range filter.Checks {
+			durations = append(durations, c+"_duration")
+		}
+		cond = append(cond, sq.Eq{"check_measurements.measurement": durations})
+	}
+	rows, err := r.builder().Select(
+		"instances.uuid",
+		"instances.app_id",
+		"check_measurements.measurement",
+		"checks.ts",
+		"check_measurements.value",
+	).
+		From("check_measurements").
+		InnerJoin("checks ON checks.id = check_measurements.check_id").
+		InnerJoin("instances ON instances.id = checks.instance_id").
+		Where(cond).
+		OrderBy("instances.uuid", "check_measurements.measurement", "checks.ts").
+		Limit(uint64(maxMeasurementPoints + 1)).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying measurements: %w", err)
+	}
+	defer rows.Close()
+	var out []MeasurementSeries
+	for points := 0; rows.Next(); points++ {
+		if points == maxMeasurementPoints {
+			return nil, ErrTooManyMeasurements
+		}
+		var uuid, measurement string
+		var appID sql.NullString
+		var p MeasurementPoint
+		if err := rows.Scan(&uuid, &appID, &measurement, &p.TS, &p.Value); err != nil {
+			return nil, fmt.Errorf("reading measurements: %w", err)
+		}
+		p.TS = p.TS.UTC()
+		if n := len(out); n == 0 || out[n-1].InstanceUUID != uuid || out[n-1].Measurement != measurement {
+			out = append(out, MeasurementSeries{Measurement: measurement, InstanceUUID: uuid, AppID: appID.String})
+		}
+		series := &out[len(out)-1]
+		series.Points = append(series.Points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading measurements: %w", err)
+	}
+	return out, nil
+}
+
+// labelsCond builds conditions matching instances with all of the labels. The query must include
+// the instances table.
+func labelsCond(labels map[string]string) sq.And {
+	cond := sq.And{}
+	for _, k := range sortedLabelKeys(labels) {
+		cond = append(cond, sq.Expr(
+			`EXISTS (SELECT 1 FROM instance_labels WHERE instance_labels.instance_id = instances.id AND instance_labels.k = ? AND instance_labels.v = ?)`,
+			k, labels[k],
+		))
+	}
+	return cond
+}
+
+// AnalyzeLongestGapPerApp reports the longest interval between consecutive checks of each app.
+// Daily rollups are used for days whose raw results were deleted by the retention policy.
+func (r *sqlReader) AnalyzeLongestGapPerApp(
+	ctx context.Context,
+	start time.Time,
+	end time.Time,
+	apps []string,
+	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
+) error {
+	rollups, err := r.gapRollups(ctx, start, end, apps)
+	if err != nil {
+		return err
+	}
+
+	cond := sq.And{sq.GtOrEq{"ts": start.UTC()}, sq.LtOrEq{"ts": end.UTC()}}
+	if len(apps) > 0 {
+		cond = append(cond, sq.Eq{"instances.app_id": apps})
+	}
+	q := r.builder().Select("COALESCE(instances.app_id, '')", "ts").
+		InnerJoin("instances on instances.id = checks.instance_id").
+		From("checks").
+		Where(cond).
+		OrderBy("instances.app_id", "ts ASC")
+	rows, err := q.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var g *gapTracker
+	flush := func() {
+		if g == nil {
+			return
+		}
+		// Rollups after the app's last raw result.
+		for _, seg := range rollups[g.appID] {
+			g.add(seg)
+		}
+		delete(rollups, g.appID)
+		if g.appID != "" && g.maxInterval > 0 {
+			output(g.appID, g.maxInterval, g.maxIntervalTS)
+		}
+	}
+	for rows.Next() {
+		var appID string
+		var ts time.Time
+		if err := rows.Scan(&appID, &ts); err != nil {
+			return err
+		}
+		ts = ts.UTC()
+		if g == nil || appID != g.appID {
+			flush()
+			g = &gapTracker{appID: appID}
+		}
+		// Rollups before this raw result.
+		segs := rollups[appID]
+		for len(segs) > 0 && segs[0].first.Before(ts) {
+			g.add(segs[0])
+			segs = segs[1:]
+		}
+		rollups[appID] = segs
+		g.add(gapRollup{cycles: 1, first: ts, last: ts, maxGapTS: ts})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	flush()
+	// Apps which only have rollups in the range.
+	remaining := make([]string, 0, len(rollups))
+	for appID := range rollups {
+		remaining = append(remaining, appID)
+	}
+	sort.Strings(remaining)
+	for _, appID := range remaining {
+		g = &gapTracker{appID: appID}
+		flush()
+	}
+	return nil
+}
+
+// gapTracker finds the longest interval between checks of an app from raw results and rollups,
+// added in order.
+type gapTracker struct {
+	appID         string
+	started       bool
+	lastTS        time.Time
+	maxInterval   time.Duration
+	maxIntervalTS time.Time
+}
+
+func (g *gapTracker) add(seg gapRollup) {
+	if !g.started {
+		g.started = true
+		g.lastTS = seg.first
+		g.maxIntervalTS = seg.first
+	} else if interval := seg.first.Sub(g.lastTS); interval > g.maxInterval {
+		g.maxInterval = interval
+		g.maxIntervalTS = seg.first
+	}
+	if seg.cycles > 1 && seg.maxGap > g.maxInterval {
+		g.maxInterval = seg.maxGap
+		g.maxIntervalTS = seg.maxGapTS
+	}
+	if seg.last.After(g.lastTS) {
+		g.lastTS = seg.last
+	}
+}
+
+// gapRollups loads the daily gap rollups overlapping the range, ordered by time for each app. No
+// rollups are returned if the schema predates them.
+func (r *sqlReader) gapRollups(ctx context.Context, start, end time.Time, apps []string) (map[string][]gapRollup, error) {
+	out := make(map[string][]gapRollup)
+	var tables int
+	if err := r.db.QueryRowContext(ctx, r.dialect.hasTable, "gap_rollups").Scan(&tables); err != nil {
+		return nil, fmt.Errorf("checking for gap_rollups: %w", err)
+	}
+	if tables == 0 {
+		return out, nil
+	}
+	cond := sq.And{
+		sq.Eq{"resolution": resolutionDay},
+		sq.GtOrEq{"bucket_start": start.UTC().Truncate(oneDay)},
+		sq.LtOrEq{"bucket_start": end.UTC()},
+	}
+	if len(apps) > 0 {
+		cond = append(cond, sq.Eq{"app_id": apps})
+	}
+	rows, err := r.builder().Select("app_id", "cycles", "first_ts", "last_ts", "max_gap_ms", "max_gap_ts").
+		From("gap_rollups").
+		Where(cond).
+		OrderBy("app_id", "bucket_start").
+		QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("querying gap rollups: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var appID string
+		var g gapRollup
+		var maxGapMS int64
+		if err := rows.Scan(&appID, &g.cycles, &g.first, &g.last, &maxGapMS, &g.maxGapTS); err != nil {
+			return nil, fmt.Errorf("reading gap rollups: %w", err)
+		}
+		g.first, g.last, g.maxGapTS = g.first.UTC(), g.last.UTC(), g.maxGapTS.UTC()
+		g.maxGap = time.Duration(maxGapMS) * time.Millisecond
+		out[appID] = append(out[appID], g)
+	}
+	return out, rows.Err()
+}
+
+// resultsCond builds the WHERE conditions for a results filter. The query must join the checks and
+// instances tables.
+func (r *sqlReader) resultsCond(filter ResultsFilter) sq.And {
+	cond := labelsCond(filter.Labels)
+	if len(filter.AppIDs) > 0 {
+		cond = append(cond, sq.Eq{"instances.app_id": filter.AppIDs})
+	}
+	if len(filter.InstanceUUIDs) > 0 {
+		cond = append(cond, sq.Eq{"instances.uuid": filter.InstanceUUIDs})
+	}
+	if len(filter.ExcludeInstanceUUIDs) > 0 {
+		cond = append(cond, sq.NotEq{"instances.uuid": filter.ExcludeInstanceUUIDs})
+	}
+	if filter.ErrorsOnly {
+		cond = append(cond, sq.Expr(hasErrors))
+	}
+	if !filter.Start.IsZero() {
+		cond = append(cond, sq.GtOrEq{"checks.ts": filter.Start.UTC()})
+	}
+	if !filter.End.IsZero() {
+		cond = append(cond, sq.LtOrEq{"checks.ts": filter.End.UTC()})
+	}
+	if len(filter.Checks) > 0 {
+		// A check either fails and records an error, or succeeds and records its duration.
+		errorArgs := make([]interface{}, 0, len(filter.Checks))
+		durationArgs := make([]interface{}, 0, len(filter.Checks))
+		for _, c := range filter.Checks {
+			errorArgs = append(errorArgs, c)
+			durationArgs = append(durationArgs, c+"_duration")
+		}
+		cond = append(cond, sq.Or{
+			sq.Expr(`EXISTS (SELECT 1 FROM check_errors WHERE check_errors.check_id = checks.id AND check_errors.check_name IN (`+placeholders(len(errorArgs))+`))`, errorArgs...),
+			sq.Expr(`EXISTS (SELECT 1 FROM check_measurements WHERE check_measurements.check_id = checks.id AND check_measurements.measurement IN (`+placeholders(len(durationArgs))+`))`, durationArgs...),
+		})
+	}
+	return cond
+}
+
+func (r *sqlReader) loadErrors(ctx context.Context, ids []int64, f func(id int64, e check.CheckError)) error {
+	rows, err := r.builder().Select("check_id", "check_name", "error").
+		From("check_errors").
+		Where(sq.Eq{"check_id": ids}).
+		OrderBy("check_id", "check_name").
+		QueryContext(ctx)
+	if err != nil {
+		return fmt.Errorf("querying check errors: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var e check.CheckError
+		if err := rows.Scan(&id, &e.Check, &e.Error); err != nil {
+			return fmt.Errorf("reading check errors: %w", err)
+		}
+		f(id, e)
+	}
+	return rows.Err()
+}
+
+func (r *sqlReader) loadMeasurements(ctx context.Context, ids []int64, f func(id int64, m check.CheckMeasurement)) error {
+	rows, err := r.builder().Select("check_id", "measurement", "value").
+		From("check_measurements").
+		Where(sq.Eq{"check_id": ids}).
+		OrderBy("check_id", "measurement").
+		QueryContext(ctx)
+	if err != nil {
+		return fmt.Errorf("querying check measurements: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var m check.CheckMeasurement
+		if err := rows.Scan(&id, &m.Check, &m.Value); err != nil {
+			return fmt.Errorf("reading check measurements: %w", err)
+		}
+		f(id, m)
+	}
+	return rows.Err()
+}
+
+func (r *sqlReader) loadLabels(ctx context.Context, instances map[int64]*check.Instance) error {
+	ids := make([]int64, 0, len(instances))
+	for id := range instances {
+		ids = append(ids, id)
+	}
+	rows, err := r.builder().Select("instance_id", "k", "v").
+		From("instance_labels").
+		Where(sq.Eq{"instance_id": ids}).
+		QueryContext(ctx)
+	if err != nil {
+		return fmt.Errorf("querying instance labels: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var k, v string
+		if err := rows.Scan(&id, &k, &v); err != nil {
+			return fmt.Errorf("reading instance labels: %w", err)
+		}
+		instance := instances[id]
+		if instance.Labels == nil {
+			instance.Labels = make(map[string]string)
+		}
+		instance.Labels[k] = v
+	}
+	return rows.Err()
+}
+
+// maxErrorLength is the size of the check_errors.error column.
+const maxErrorLength = 512
+
+// saveCheckDetails stores the errors and measurements of the check with the given id.
+func saveCheckDetails(ctx context.Context, tx sq.BaseRunner, placeholder sq.PlaceholderFormat, id int64, result check.CheckResults) error {
+	if len(result.Errors) > 0 {
+		q := sq.Insert("check_errors").Columns("check_id", "check_name", "error").PlaceholderFormat(placeholder)
+		for _, e := range result.Errors {
+			q = q.Values(id, e.Check, truncateError(e.Error))
+		}
+		if _, err := q.RunWith(tx).ExecContext(ctx); err != nil {
+			return fmt.Errorf("storing errors: %w", err)
+		}
+	}
+	if len(result.Measurements) > 0 {
+		q := sq.Insert("check_measurements").Columns("check_id", "measurement", "value").PlaceholderFormat(placeholder)
+		for _, e := range result.Measurements {
+			q = q.Values(id, e.Check, e.Value)
+		}
+		if _, err := q.RunWith(tx).ExecContext(ctx); err != nil {
+			return fmt.Errorf("storing measurements: %w", err)
+		}
+	}
+	return nil
+}
+
+// insertCheckResultsReturning saves check results in a single transaction, using INSERT ...
+// RETURNING to read the new ids, as supported by PostgreSQL and SQLite. Results which were already
+// saved are skipped. instanceIDs holds the database id of each result's instance.
+func insertCheckResultsReturning(
+	ctx context.Context,
+	db *sql.DB,
+	placeholder sq.PlaceholderFormat,
+	results []check.CheckResults,
+	instanceIDs []int64,
+) (err error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelDefault,
+	})
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	for i, result := range results {
+		var id int64
+		err = sq.Insert("checks").Columns("uuid", "instance_id", "ts").
+			Values(resultID(result), instanceIDs[i], result.TS.UTC()).
+			Suffix("ON CONFLICT (uuid) DO NOTHING RETURNING id").
+			PlaceholderFormat(placeholder).
+			RunWith(tx).QueryRowContext(ctx).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Saved by an earlier attempt whose acknowledgement was lost.
+			err = nil
+			continue
+		} else if err != nil {
+			return fmt.Errorf("storing check result: %w", err)
+		}
+		if err = saveCheckDetails(ctx, tx, placeholder, id, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resultID returns the result's ID for the checks.uuid column. Results without an ID are never
+// considered duplicates.
+func resultID(result check.CheckResults) sql.NullString {
+	return sql.NullString{Valid: result.ID != "", String: result.ID}
+}
+
+// instanceInsert inserts the instance's fields.
+func instanceInsert(instance *check.Instance) sq.InsertBuilder {
+	return sq.Insert("instances").Columns(
+		"uuid",
+		"app_id",
+		"hostname",
+		"public_ipv4",
+		"started_at",
+		"stopped_at",
+	).Values(
+		instance.UUID,
+		sql.NullString{Valid: instance.AppID != "", String: instance.AppID},
+		instance.Hostname,
+		sql.NullString{Valid: instance.PublicIPv4 != "", String: instance.PublicIPv4},
+		instance.StartedAt.UTC(),
+		sql.NullTime{Valid: !instance.StoppedAt.IsZero(), Time: instance.StoppedAt.UTC()},
+	)
+}
+
+// upsertInstanceReturning inserts or updates an instance and synchronizes its labels in a single
+// transaction, using INSERT ... ON CONFLICT and RETURNING, as supported by PostgreSQL and SQLite.
+// It returns the instance's database id.
+func upsertInstanceReturning(ctx context.Context, db *sql.DB, placeholder sq.PlaceholderFormat, instance *check.Instance) (id int64, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	err = instanceInsert(instance).Suffix(`
+	ON CONFLICT (uuid) DO UPDATE SET
+	app_id = EXCLUDED.app_id,
+	hostname = EXCLUDED.hostname,
+	public_ipv4 = EXCLUDED.public_ipv4,
+	started_at = EXCLUDED.started_at,
+	stopped_at = EXCLUDED.stopped_at
+	RETURNING id
+	`).PlaceholderFormat(placeholder).RunWith(tx).QueryRowContext(ctx).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("updating instance record: %w", err)
+	}
+	err = syncLabels(ctx, tx, placeholder, `ON CONFLICT (instance_id, k) DO UPDATE SET v = EXCLUDED.v`, id, instanceLabels(instance))
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insertInstanceReturning inserts an instance with its labels if it isn't stored yet, using
+// INSERT ... ON CONFLICT and RETURNING, as supported by PostgreSQL and SQLite. A stored instance
+// is left unchanged, as the copy in a replayed or imported result may be stale. It returns the
+// instance's database id.
+func insertInstanceReturning(ctx context.Context, db *sql.DB, placeholder sq.PlaceholderFormat, instance *check.Instance) (id int64, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	err = instanceInsert(instance).Suffix("ON CONFLICT (uuid) DO NOTHING RETURNING id").
+		PlaceholderFormat(placeholder).RunWith(tx).QueryRowContext(ctx).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return selectInstanceID(ctx, tx, placeholder, instance.UUID)
+	} else if err != nil {
+		return 0, fmt.Errorf("inserting instance record: %w", err)
+	}
+	err = syncLabels(ctx, tx, placeholder, `ON CONFLICT (instance_id, k) DO UPDATE SET v = EXCLUDED.v`, id, instanceLabels(instance))
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// selectInstanceID reads the database id of a stored instance.
+func selectInstanceID(ctx context.Context, tx *sql.Tx, placeholder sq.PlaceholderFormat, uuid string) (id int64, err error) {
+	err = sq.Select("id").From("instances").Where(sq.Eq{"uuid": uuid}).
+		PlaceholderFormat(placeholder).RunWith(tx).QueryRowContext(ctx).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("reading instance id: %w", err)
+	}
+	return id, nil
+}
+
+// instanceIDs tracks the database ids of stored instances by UUID. They are kept per storer rather
+// than on the instance, as an instance may be stored in several databases.
+type instanceIDs struct {
+	lock sync.Mutex
+	ids  map[string]int64
+}
+
+// ensure returns the database id of each result's instance, storing instances which are missing
+// with insert the first time they are seen. Results may carry a stale copy of their instance, ex.
+// when replayed from the spool or imported from an archive, so insert mustn't change a stored
+// instance. Changes to an instance are only stored by update.
+func (c *instanceIDs) ensure(
+	ctx context.Context,
+	results []check.CheckResults,
+	insert func(context.Context, *check.Instance) (int64, error),
+) ([]int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ids := make([]int64, len(results))
+	for i, result := range results {
+		id, ok := c.ids[result.Instance.UUID]
+		if !ok {
+			var err error
+			if id, err = c.store(ctx, result.Instance, insert); err != nil {
+				return nil, fmt.Errorf("ensuring instance: %w", err)
+			}
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// update stores the instance with upsert, recording its database id.
+func (c *instanceIDs) update(ctx context.Context, instance *check.Instance, upsert func(context.Context, *check.Instance) (int64, error)) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := c.store(ctx, instance, upsert)
+	return err
+}
+
+// store stores the instance with save, recording its database id.
+func (c *instanceIDs) store(ctx context.Context, instance *check.Instance, save func(context.Context, *check.Instance) (int64, error)) (int64, error) {
+	id, err := save(ctx, instance)
+	if err != nil {
+		return 0, err
+	}
+	if c.ids == nil {
+		c.ids = make(map[string]int64)
+	}
+	c.ids[instance.UUID] = id
+	return id, nil
+}
+
+// instanceLabels copies the instance's labels, which may be replaced at runtime under its lock.
+func instanceLabels(instance *check.Instance) map[string]string {
+	instance.Lock()
+	defer instance.Unlock()
+	labels := make(map[string]string, len(instance.Labels))
+	for k, v := range instance.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// syncLabels makes the stored labels of an instance match labels, upserting with upsertSuffix and
+// deleting removed keys. Each change is recorded in instance_label_history.
+func syncLabels(
+	ctx context.Context,
+	tx *sql.Tx,
+	placeholder sq.PlaceholderFormat,
+	upsertSuffix string,
+	instanceID int64,
+	labels map[string]string,
+) error {
+	rows, err := sq.Select("k", "v").From("instance_labels").Where(sq.Eq{"instance_id": instanceID}).
+		PlaceholderFormat(placeholder).RunWith(tx).QueryContext(ctx)
+	if err != nil {
+		return fmt.Errorf("querying instance labels: %w", err)
+	}
+	stored := make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning instance label: %w", err)
+		}
+		stored[k] = v
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("querying instance labels: %w", err)
+	}
+
+	now := time.Now().UTC()
+	upsert := sq.Insert("instance_labels").Columns("instance_id", "k", "v")
+	history := sq.Insert("instance_label_history").Columns("instance_id", "k", "old_v", "new_v", "changed_at")
+	var upserts int
+	var removed []string
+	for _, k := range sortedLabelKeys(labels) {
+		old, ok := stored[k]
+		if ok && old == labels[k] {
+			continue
+		}
+		upsert = upsert.Values(instanceID, k, labels[k])
+		upserts++
+		history = history.Values(instanceID, k, sql.NullString{Valid: ok, String: old}, labels[k], now)
+	}
+	for _, k := range sortedLabelKeys(stored) {
+		if _, ok := labels[k]; !ok {
+			removed = append(removed, k)
+			history = history.Values(instanceID, k, stored[k], nil, now)
+		}
+	}
+	if upserts == 0 && len(removed) == 0 {
+		return nil
+	}
+	if upserts > 0 {
+		if _, err := upsert.Suffix(upsertSuffix).PlaceholderFormat(placeholder).RunWith(tx).ExecContext(ctx); err != nil {
+			return fmt.Errorf("storing instance labels: %w", err)
+		}
+	}
+	if len(removed) > 0 {
+		_, err := sq.Delete("instance_labels").Where(sq.Eq{"instance_id": instanceID, "k": removed}).
+			PlaceholderFormat(placeholder).RunWith(tx).ExecContext(ctx)
+		if err != nil {
+			return fmt.Errorf("deleting removed instance labels: %w", err)
+		}
+	}
+	if _, err := history.PlaceholderFormat(placeholder).RunWith(tx).ExecContext(ctx); err != nil {
+		return fmt.Errorf("recording instance label history: %w", err)
+	}
+	return nil
+}
+
+// truncateError truncates an error to fit its column without splitting a UTF-8 sequence.
+func truncateError(s string) string {
+	if len(s) <= maxErrorLength {
+		return s
+	}
+	i := maxErrorLength
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return s[:i]
+}
+
+// nullTime scans nullable timestamps, including aggregates which some drivers return without type
+// information.
+type nullTime struct {
+	Time time.Time
+}
+
+func (n *nullTime) Scan(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		n.Time = time.Time{}
+	case time.Time:
+		n.Time = v.UTC()
+	case []byte:
+		return n.parse(string(v))
+	case string:
+		return n.parse(v)
+	default:
+		return fmt.Errorf("unsupported timestamp type %T", v)
+	}
+	return nil
+}
+
+func (n *nullTime) parse(s string) error {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			n.Time = t.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to parse timestamp %q", s)
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
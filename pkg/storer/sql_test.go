@@ -0,0 +1,128 @@
+package storer
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+	"github.com/google/uuid"
+)
+
+func TestSQLStorers(t *testing.T) {
+	tcs := []struct {
+		name string
+		new  func(t *testing.T) Storer
+	}{
+		{
+			name: "sqlite",
+			new: func(t *testing.T) Storer {
+				s, err := NewSQLiteStorer(context.Background(), "sqlite:"+filepath.Join(t.TempDir(), "self-check.db"), true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+		},
+		{name: "postgres", new: newPostgresTestStorer},
+		{name: "mysql", new: newMySQLTestStorer},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.new(t)
+			defer s.Close()
+			testSQLStorer(t, s)
+		})
+	}
+}
+
+// testSQLStorer checks that results and instances saved by a SQL storer are read back by the shared
+// sqlReader. The app ID is unique so the test can run against a shared database.
+func testSQLStorer(t *testing.T, s Storer) {
+	ctx := context.Background()
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	instance := &check.Instance{
+		UUID:      uuid.NewString(),
+		AppID:     uuid.NewString(),
+		Hostname:  "a",
+		StartedAt: start,
+		Labels:    map[string]string{"region": "nyc"},
+	}
+	first := check.CheckResults{
+		ID:           uuid.NewString(),
+		Instance:     instance,
+		TS:           start,
+		Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.1}},
+	}
+	for i, r := range []check.CheckResults{
+		first,
+		{ID: uuid.NewString(), Instance: instance, TS: start.Add(10 * time.Second), Errors: []check.CheckError{{Check: "database", Error: "timeout"}}},
+		{ID: uuid.NewString(), Instance: instance, TS: start.Add(time.Hour), Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.2}}},
+	} {
+		if err := s.SaveCheckResults(ctx, r); err != nil {
+			t.Fatalf("saving result %d: %v", i, err)
+		}
+	}
+	// A retry after a lost acknowledgement carries an extra error, which must not be stored.
+	first.Errors = []check.CheckError{{Check: "result_save_attempt_1", Error: "timeout"}}
+	if err := s.SaveCheckResults(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	// Instances are upserted, replacing their labels.
+	instance.Labels = map[string]string{"region": "ams"}
+	instance.StoppedAt = start.Add(2 * time.Hour)
+	if err := s.UpdateInstance(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := ResultsFilter{AppIDs: []string{instance.AppID}}
+	page, err := s.QueryResults(ctx, filter, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", page.Results)
+	}
+	oldest := page.Results[2]
+	if oldest.ID != first.ID || !oldest.TS.Equal(start) || len(oldest.Errors) != 0 || len(oldest.Measurements) != 1 {
+		t.Errorf("expected the first result to be stored once, got %+v", oldest)
+	}
+	if got := oldest.Instance; got.Labels["region"] != "ams" || len(got.Labels) != 1 || !got.StoppedAt.Equal(instance.StoppedAt) {
+		t.Errorf("expected the updated instance, got %+v", got)
+	}
+
+	stats, err := s.QueryResultStats(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ResultStats{Cycles: 3, CyclesWithErrors: 1, LastCheck: start.Add(time.Hour)}); stats.Cycles != want.Cycles ||
+		stats.CyclesWithErrors != want.CyclesWithErrors || !stats.LastCheck.Equal(want.LastCheck) {
+		t.Errorf("expected stats %+v, got %+v", want, stats)
+	}
+
+	var gaps []time.Duration
+	err = s.AnalyzeLongestGapPerApp(ctx, start, start.Add(2*time.Hour), []string{instance.AppID}, func(appID string, interval time.Duration, maxIntervalTS time.Time) {
+		if appID != instance.AppID || !maxIntervalTS.Equal(start.Add(time.Hour)) {
+			t.Errorf("unexpected gap for %q at %s", appID, maxIntervalTS)
+		}
+		gaps = append(gaps, interval)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 || gaps[0] != time.Hour-10*time.Second {
+		t.Errorf("expected a single gap of %s, got %v", time.Hour-10*time.Second, gaps)
+	}
+}
+
+func TestTruncateError(t *testing.T) {
+	s := ""
+	for len(s) < maxErrorLength-1 {
+		s += "a"
+	}
+	s += "é"
+	if got := truncateError(s); got != s[:maxErrorLength-1] {
+		t.Errorf("expected multi-byte rune to be dropped, got length %d", len(got))
+	}
+}
@@ -0,0 +1,132 @@
+package storer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+
+	sq "github.com/Masterminds/squirrel"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDefaultParams are applied to every connection. Timestamps are written in a format which
+// sorts correctly as text, since SQLite has no native timestamp type.
+var sqliteDefaultParams = url.Values{
+	"_pragma":      {"busy_timeout(10000)", "journal_mode(WAL)"},
+	"_time_format": {"sqlite"},
+}
+
+type sqliteStorer struct {
+	db *sql.DB
+	commonStorer
+	sqlReader
+	instances instanceIDs
+}
+
+// NewSQLiteStorer creates a new storer backed by a SQLite database file. The uri takes the form
+// `sqlite:path/to/file.db`, `sqlite:///absolute/path/to/file.db` or the SQLite URI style
+// `sqlite:file:path/to/file.db`, optionally followed by query parameters understood by the driver
+// (ex. `?_pragma=synchronous(NORMAL)`). A bare `file:` uri creates a file storer, see NewFileStorer.
+func NewSQLiteStorer(ctx context.Context, uri string, createTables bool, opts ...StorerOption) (Storer, error) {
+	dsn, err := sqliteDSN(uri)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, so serialize access rather than contend for the lock. This
+	// also ensures in-memory databases are shared by all queries.
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &sqliteStorer{
+		db:        db,
+		sqlReader: sqlReader{db: db, dialect: sqliteDialect},
+	}
+	if err := s.init(ctx, createTables, opts); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sqliteDSN converts a storer uri to a DSN for the driver.
+func sqliteDSN(uri string) (string, error) {
+	path := strings.TrimPrefix(uri, "sqlite:")
+	// Accept SQLite URIs, sqlite:file:relative.db, and URL style paths, sqlite://relative.db and
+	// sqlite:///absolute.db.
+	path = strings.TrimPrefix(path, "file:")
+	path = strings.TrimPrefix(path, "//")
+	path, rawQuery, _ := strings.Cut(path, "?")
+	if path == "" {
+		return "", fmt.Errorf("sqlite uri %q is missing a path", uri)
+	}
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("parsing sqlite uri parameters: %w", err)
+	}
+	for k, v := range sqliteDefaultParams {
+		if k == "_pragma" {
+			q[k] = append(append([]string(nil), v...), q[k]...)
+		} else if !q.Has(k) {
+			q[k] = v
+		}
+	}
+	return path + "?" + q.Encode(), nil
+}
+
+// SaveCheckResults saves check results.
+func (s *sqliteStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return s.saveCheckResults(ctx, []check.CheckResults{result})
+}
+
+// saveCheckResults saves a batch of check results in a single transaction.
+func (s *sqliteStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) error {
+	instanceIDs, err := s.instances.ensure(ctx, results, s.insertInstance)
+	if err != nil {
+		return err
+	}
+	return insertCheckResultsReturning(ctx, s.db, sq.Question, results, instanceIDs)
+}
+
+// Close shuts down the database handle and any async savers.
+func (s *sqliteStorer) Close() error {
+	s.commonStorer.close()
+	return s.db.Close()
+}
+
+func (s *sqliteStorer) init(ctx context.Context, createTables bool, opts []StorerOption) error {
+	if err := s.commonStorer.init(s.saveCheckResults, opts...); err != nil {
+		return err
+	}
+	if !createTables {
+		return nil
+	}
+	_, err := s.Migrator().Up(ctx, false)
+	return err
+}
+
+// Migrator manages the database schema.
+func (s *sqliteStorer) Migrator() *Migrator {
+	return newMigrator(s.db, s.dialect)
+}
+
+func (s *sqliteStorer) updateInstance(ctx context.Context, instance *check.Instance) (int64, error) {
+	return upsertInstanceReturning(ctx, s.db, sq.Question, instance)
+}
+
+func (s *sqliteStorer) insertInstance(ctx context.Context, instance *check.Instance) (int64, error) {
+	return insertInstanceReturning(ctx, s.db, sq.Question, instance)
+}
+
+func (s *sqliteStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
+	return s.instances.update(ctx, instance, s.updateInstance)
+}
@@ -0,0 +1,269 @@
+package storer
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestSQLiteStorer(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "self-check.db")
+	s, err := New(ctx, "sqlite://"+path, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Timestamps in other zones must still compare correctly.
+	zone := time.FixedZone("test", -5*60*60)
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	previous := &check.Instance{UUID: "previous", AppID: "app", Hostname: "a", StartedAt: start.In(zone)}
+	current := &check.Instance{
+		UUID:      "current",
+		AppID:     "app",
+		Hostname:  "b",
+		StartedAt: start.Add(time.Hour),
+		Labels:    map[string]string{"region": "nyc"},
+	}
+	for i, r := range []check.CheckResults{
+		{Instance: previous, TS: start.In(zone), Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.1}}},
+		{Instance: previous, TS: start.Add(10 * time.Second), Errors: []check.CheckError{{Check: "database", Error: "timeout"}}},
+		{Instance: current, TS: start.Add(time.Hour), Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.2}}},
+		{Instance: current, TS: start.Add(time.Hour + 5*time.Second).In(zone), Errors: []check.CheckError{{Check: "dns", Error: "nxdomain"}}},
+	} {
+		if err := s.SaveCheckResults(ctx, r); err != nil {
+			t.Fatalf("saving result %d: %v", i, err)
+		}
+	}
+	previous.StoppedAt = start.Add(20 * time.Second)
+	if err := s.UpdateInstance(ctx, previous); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := s.QueryResults(ctx, ResultsFilter{AppIDs: []string{"app"}}, Page{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 3 || page.NextCursor == "" {
+		t.Fatalf("expected a full page with a cursor, got %d results and cursor %q", len(page.Results), page.NextCursor)
+	}
+	if newest := page.Results[0]; !newest.TS.Equal(start.Add(time.Hour+5*time.Second)) || newest.Instance.Labels["region"] != "nyc" || len(newest.Errors) != 1 {
+		t.Errorf("unexpected newest result %+v", newest)
+	}
+	page, err = s.QueryResults(ctx, ResultsFilter{AppIDs: []string{"app"}}, Page{Limit: 3, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.NextCursor != "" || !page.Results[0].TS.Equal(start) {
+		t.Fatalf("expected the oldest result on the last page, got %+v", page)
+	}
+	if stopped := page.Results[0].Instance.StoppedAt; !stopped.Equal(previous.StoppedAt) {
+		t.Errorf("expected stopped_at %s, got %s", previous.StoppedAt, stopped)
+	}
+
+	page, err = s.QueryResults(ctx, ResultsFilter{Checks: []string{"database"}, Start: start.Add(time.Second)}, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 2 {
+		t.Errorf("expected 2 database results after start, got %d", len(page.Results))
+	}
+
+	stats, err := s.QueryResultStats(ctx, ResultsFilter{AppIDs: []string{"app"}, ExcludeInstanceUUIDs: []string{"current"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ResultStats{Cycles: 2, CyclesWithErrors: 1, LastCheck: start.Add(10 * time.Second)}); stats != want {
+		t.Errorf("expected stats %+v, got %+v", want, stats)
+	}
+
+	var gaps []time.Duration
+	err = s.AnalyzeLongestGapPerApp(ctx, start, start.Add(2*time.Hour), nil, func(appID string, interval time.Duration, maxIntervalTS time.Time) {
+		if appID != "app" || !maxIntervalTS.Equal(start.Add(time.Hour)) {
+			t.Errorf("unexpected gap for %q at %s", appID, maxIntervalTS)
+		}
+		gaps = append(gaps, interval)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 || gaps[0] != time.Hour-10*time.Second {
+		t.Errorf("expected a single gap of %s, got %v", time.Hour-10*time.Second, gaps)
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	for _, tc := range []struct {
+		uri, path string
+	}{
+		{uri: "sqlite:self-check.db", path: "self-check.db"},
+		{uri: "sqlite://self-check.db", path: "self-check.db"},
+		{uri: "sqlite:///data/self-check.db", path: "/data/self-check.db"},
+		{uri: "sqlite:file:self-check.db", path: "self-check.db"},
+		{uri: "sqlite:file:///data/self-check.db?_pragma=synchronous(NORMAL)", path: "/data/self-check.db"},
+	} {
+		dsn, err := sqliteDSN(tc.uri)
+		if err != nil {
+			t.Errorf("%s: %v", tc.uri, err)
+			continue
+		}
+		if path, _, _ := strings.Cut(dsn, "?"); path != tc.path {
+			t.Errorf("%s: expected path %q, got %q", tc.uri, tc.path, path)
+		}
+	}
+	if _, err := sqliteDSN("sqlite:file:"); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
+
+func TestSQLiteStorerIdempotentSave(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteStorer(ctx, "sqlite:"+filepath.Join(t.TempDir(), "self-check.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	instance := &check.Instance{UUID: "a", StartedAt: time.Now()}
+	r := check.CheckResults{ID: "5f1c3e56-6a35-4c0b-9f0e-1d2f0b7c1a11", Instance: instance, TS: time.Now()}
+	if err := s.SaveCheckResults(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	// A retry after a lost acknowledgement carries an extra error, which must not be stored.
+	r.Errors = []check.CheckError{{Check: "result_save_attempt_1", Error: "timeout"}}
+	if err := s.SaveCheckResults(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	page, err := s.QueryResults(ctx, ResultsFilter{}, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != r.ID || len(page.Results[0].Errors) != 0 {
+		t.Errorf("expected the result to be stored once, got %+v", page.Results)
+	}
+}
+
+func TestSQLiteStorerLabelSync(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSQLiteStorer(ctx, "sqlite:"+filepath.Join(t.TempDir(), "self-check.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	instance := &check.Instance{UUID: "a", StartedAt: time.Now(), Labels: map[string]string{"deployment": "1", "team": "x"}}
+	for _, labels := range []map[string]string{instance.Labels, {"deployment": "2"}, {"deployment": "2"}} {
+		instance.Labels = labels
+		if err := s.UpdateInstance(ctx, instance); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := s.(*sqliteStorer).db
+	rows, err := db.QueryContext(ctx, `SELECT k, v FROM instance_labels WHERE instance_id = (SELECT id FROM instances WHERE uuid = ?)`, instance.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []string
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, k+"="+v)
+	}
+	rows.Close()
+	if strings.Join(stored, ",") != "deployment=2" {
+		t.Errorf("expected only the current labels, got %v", stored)
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT k, COALESCE(old_v, '-'), COALESCE(new_v, '-') FROM instance_label_history ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history []string
+	for rows.Next() {
+		var k, oldV, newV string
+		if err := rows.Scan(&k, &oldV, &newV); err != nil {
+			t.Fatal(err)
+		}
+		history = append(history, k+":"+oldV+">"+newV)
+	}
+	rows.Close()
+	// Unchanged labels aren't recorded again.
+	expected := "deployment:->1,team:->x,deployment:1>2,team:x>-"
+	if got := strings.Join(history, ","); got != expected {
+		t.Errorf("expected history %s, got %s", expected, got)
+	}
+}
+
+func TestSQLiteStorerReplaysStaleInstance(t *testing.T) {
+	ctx := context.Background()
+	uri := "sqlite:" + filepath.Join(t.TempDir(), "self-check.db")
+	s, err := NewSQLiteStorer(ctx, uri, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	instance := &check.Instance{UUID: "a", AppID: "app", StartedAt: start, Labels: map[string]string{"team": "x"}}
+	if err := s.UpdateInstance(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+	// A result which failed to save is spooled with a copy of the running instance, which then
+	// relabels and stops.
+	spooled := check.CheckResults{ID: "spooled", Instance: instance.Copy(), TS: start.Add(time.Minute)}
+	instance.Labels = map[string]string{"team": "y"}
+	instance.StoppedAt = start.Add(time.Hour)
+	if err := s.UpdateInstance(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Append(spooled); err != nil {
+		t.Fatal(err)
+	}
+	sp.close()
+
+	// The next run replays the spool at startup.
+	s, err = NewSQLiteStorer(ctx, uri, true, WithSpool(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AsyncQueryRetry(ctx, []time.Duration{0}, func(ctx context.Context, attempt int) error { return nil })
+	s.(*sqliteStorer).wg.Wait()
+	if stats, _ := s.(*sqliteStorer).SpoolStats(); stats.Pending != 0 {
+		t.Fatalf("expected the spool to be replayed, got %+v", stats)
+	}
+
+	page, err := s.QueryResults(ctx, ResultsFilter{}, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != "spooled" {
+		t.Fatalf("expected the spooled result, got %+v", page.Results)
+	}
+	instances, err := s.ListInstances(ctx, InstanceFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || !instances[0].StoppedAt.Equal(instance.StoppedAt) || instances[0].Labels["team"] != "y" {
+		t.Errorf("expected the stopped instance to be unchanged, got %+v", instances)
+	}
+	var history int
+	if err := s.(*sqliteStorer).db.QueryRowContext(ctx, `SELECT COUNT(*) FROM instance_label_history`).Scan(&history); err != nil {
+		t.Fatal(err)
+	}
+	if history != 2 {
+		t.Errorf("expected only the recorded label changes in the history, got %d", history)
+	}
+}
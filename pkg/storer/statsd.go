@@ -0,0 +1,168 @@
+package storer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+const (
+	// defaultStatsDPrefix prefixes metric names, ex. `self_check.measurement`.
+	defaultStatsDPrefix = "self_check"
+	// maxStatsDPacket keeps packets within a typical MTU, so they aren't fragmented.
+	maxStatsDPacket = 1432
+)
+
+// statsDStorer sends metrics derived from results over UDP in the StatsD format.
+type statsDStorer struct {
+	conn   net.Conn
+	prefix string
+	tags   bool
+	commonStorer
+}
+
+// NewStatsDStorer creates a storer which sends each measurement as a gauge and each check's success
+// or failure as a counter. The uri takes the form `statsd://host:8125`, with optional parameters:
+//   - prefix: prefix for metric names (default self_check)
+//   - tags: send DogStatsD tags (default true); disable for servers which don't support them
+func NewStatsDStorer(uri string, opts ...StorerOption) (Storer, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parsing statsd uri: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("statsd uri %q is missing a host", uri)
+	}
+	s := &statsDStorer{prefix: defaultStatsDPrefix, tags: true}
+	q := u.Query()
+	if q.Has("prefix") {
+		s.prefix = q.Get("prefix")
+	}
+	if v := q.Get("tags"); v != "" {
+		if s.tags, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("parsing tags: %w", err)
+		}
+	}
+	// Dialing UDP doesn't send anything, so this only fails if the address is invalid.
+	if s.conn, err = net.Dial("udp", u.Host); err != nil {
+		return nil, fmt.Errorf("dialing statsd: %w", err)
+	}
+	if err := s.commonStorer.init(s.saveCheckResults, opts...); err != nil {
+		s.conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveCheckResults saves check results.
+func (s *statsDStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return s.saveCheckResults(ctx, []check.CheckResults{result})
+}
+
+// saveCheckResults sends the metrics of each result, packing as many lines into each packet as fit.
+func (s *statsDStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) error {
+	var packet []byte
+	for _, r := range results {
+		for _, p := range metricPoints(r) {
+			line := s.line(p)
+			if len(packet) > 0 && len(packet)+1+len(line) > maxStatsDPacket {
+				if _, err := s.conn.Write(packet); err != nil {
+					return fmt.Errorf("sending metrics: %w", err)
+				}
+				packet = packet[:0]
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+	}
+	if len(packet) > 0 {
+		if _, err := s.conn.Write(packet); err != nil {
+			return fmt.Errorf("sending metrics: %w", err)
+		}
+	}
+	return nil
+}
+
+// line formats a point, ex. `self_check.measurement:0.25|g|#check:database_duration,app_id:abc`.
+func (s *statsDStorer) line(p metricPoint) string {
+	var b strings.Builder
+	if s.prefix != "" {
+		b.WriteString(s.prefix)
+		b.WriteByte('.')
+	}
+	b.WriteString(p.name)
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(p.value, 'f', -1, 64))
+	if p.counter {
+		b.WriteString("|c")
+	} else {
+		b.WriteString("|g")
+	}
+	if s.tags {
+		sep := "|#"
+		for _, t := range p.tags {
+			if t.value == "" {
+				continue
+			}
+			b.WriteString(sep)
+			b.WriteString(statsDTagEscaper.Replace(t.key))
+			b.WriteByte(':')
+			b.WriteString(statsDTagEscaper.Replace(t.value))
+			sep = ","
+		}
+	}
+	return b.String()
+}
+
+// statsDTagEscaper replaces the characters which delimit DogStatsD tags and lines.
+var statsDTagEscaper = strings.NewReplacer(",", "_", "|", "_", ":", "_", "#", "_", "\n", "_")
+
+// Close waits briefly for async saves to complete.
+func (s *statsDStorer) Close() error {
+	s.commonStorer.close()
+	return s.conn.Close()
+}
+
+// UpdateInstance is a no-op, as each result includes its instance.
+func (s *statsDStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
+	return nil
+}
+
+// AnalyzeLongestGapPerApp is unsupported because metrics can't be read back.
+func (s *statsDStorer) AnalyzeLongestGapPerApp(
+	ctx context.Context,
+	start time.Time,
+	end time.Time,
+	apps []string,
+	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
+) error {
+	return ErrUnsupported
+}
+
+// QueryResults is unsupported because metrics can't be read back.
+func (s *statsDStorer) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	return ResultsPage{}, ErrUnsupported
+}
+
+// QueryResultStats is unsupported because metrics can't be read back.
+func (s *statsDStorer) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	return ResultStats{}, ErrUnsupported
+}
+
+// ListInstances is unsupported because metrics can't be read back.
+func (s *statsDStorer) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	return nil, ErrUnsupported
+}
+
+// QueryMeasurements is unsupported because metrics can't be read back.
+func (s *statsDStorer) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,95 @@
+package storer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+func TestStatsDStorer(t *testing.T) {
+	ctx := context.Background()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := New(ctx, "statsd://"+conn.LocalAddr().String(), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	instance := &check.Instance{AppID: "app", Hostname: "host", Labels: map[string]string{"region": "nyc,1"}}
+	err = s.SaveCheckResults(ctx, check.CheckResults{
+		Instance:     instance,
+		TS:           time.Now(),
+		Measurements: []check.CheckMeasurement{{Check: "database_duration", Value: 0.25}},
+		Errors: []check.CheckError{
+			{Check: "internal_dns", Error: "timeout"},
+			{Check: "result_save_attempt_1", Error: "timeout"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, maxStatsDPacket)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"self_check.measurement:0.25|g|#check:database_duration,app_id:app,hostname:host,region:nyc_1",
+		"self_check.success:1|c|#check:database,app_id:app,hostname:host,region:nyc_1",
+		"self_check.failure:1|c|#check:internal_dns,app_id:app,hostname:host,region:nyc_1",
+	}
+	if got := string(buf[:n]); got != strings.Join(expected, "\n") {
+		t.Errorf("unexpected packet:\n%s", got)
+	}
+}
+
+func TestStatsDStorerPackets(t *testing.T) {
+	ctx := context.Background()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := New(ctx, "statsd://"+conn.LocalAddr().String()+"?prefix=app&tags=false", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var measurements []check.CheckMeasurement
+	for i := 0; i < 200; i++ {
+		measurements = append(measurements, check.CheckMeasurement{Check: "check", Value: 1})
+	}
+	if err := s.SaveCheckResults(ctx, check.CheckResults{Measurements: measurements}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines int
+	buf := make([]byte, 64<<10)
+	for lines < len(measurements) {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("after %d lines: %v", lines, err)
+		}
+		if n > maxStatsDPacket {
+			t.Errorf("packet of %d bytes exceeds the limit", n)
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line != "app.measurement:1|g" {
+				t.Fatalf("unexpected line %q", line)
+			}
+			lines++
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package storer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/auth"
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// WebhookConfig configures the webhook storer.
+type WebhookConfig struct {
+	// Headers are added to every request. The content-type defaults to application/json.
+	Headers map[string]string
+	// Secret signs requests with the auth package's HMAC headers, if set.
+	Secret []byte
+	// BodyTemplate is a text/template rendering the request body. It is executed with
+	// WebhookPayload, and may use the `json` func to encode values. By default the body is the JSON
+	// encoded result, or an array of results when batching.
+	BodyTemplate string
+	// BatchSize is the maximum number of results per request. Results are only batched when saves
+	// queue up. If it is 1 or less, each request carries a single result.
+	BatchSize int
+	Timeout   time.Duration
+}
+
+// WithWebhook configures webhook storers. It is ignored by other storers.
+func WithWebhook(cfg WebhookConfig) StorerOption {
+	return func(o *storerOptions) {
+		o.webhook = cfg
+	}
+}
+
+// WebhookPayload is the data passed to a webhook body template.
+type WebhookPayload struct {
+	// Result is the first result of the request.
+	Result  check.CheckResults
+	Results []check.CheckResults
+}
+
+// webhookStorer posts results to an HTTP endpoint.
+type webhookStorer struct {
+	url    string
+	cfg    WebhookConfig
+	tmpl   *template.Template
+	client *http.Client
+	now    func() time.Time
+	commonStorer
+}
+
+// NewWebhookStorer creates a storer which POSTs results to the uri.
+func NewWebhookStorer(uri string, opts ...StorerOption) (Storer, error) {
+	var o storerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := &webhookStorer{
+		url: uri,
+		cfg: o.webhook,
+		now: time.Now,
+	}
+	if s.cfg.Timeout <= 0 {
+		s.cfg.Timeout = defaultWebhookTimeout
+	}
+	if s.cfg.BatchSize < 1 {
+		s.cfg.BatchSize = 1
+	}
+	s.client = &http.Client{Timeout: s.cfg.Timeout}
+	if s.cfg.BodyTemplate != "" {
+		tmpl, err := template.New("body").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(s.cfg.BodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("parsing webhook body template: %w", err)
+		}
+		s.tmpl = tmpl
+	}
+	if err := s.commonStorer.init(s.saveCheckResults, opts...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveCheckResults saves check results.
+func (s *webhookStorer) SaveCheckResults(ctx context.Context, result check.CheckResults) error {
+	return s.saveCheckResults(ctx, []check.CheckResults{result})
+}
+
+// saveCheckResults posts results in requests of up to BatchSize results each.
+func (s *webhookStorer) saveCheckResults(ctx context.Context, results []check.CheckResults) error {
+	for len(results) > 0 {
+		n := s.cfg.BatchSize
+		if n > len(results) {
+			n = len(results)
+		}
+		if err := s.post(ctx, results[:n]); err != nil {
+			return err
+		}
+		results = results[n:]
+	}
+	return nil
+}
+
+func (s *webhookStorer) body(results []check.CheckResults) ([]byte, error) {
+	if s.tmpl != nil {
+		var buf bytes.Buffer
+		if err := s.tmpl.Execute(&buf, WebhookPayload{Result: results[0], Results: results}); err != nil {
+			return nil, fmt.Errorf("rendering webhook body: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	if s.cfg.BatchSize == 1 {
+		return json.Marshal(results[0])
+	}
+	return json.Marshal(results)
+}
+
+func (s *webhookStorer) post(ctx context.Context, results []check.CheckResults) error {
+	body, err := s.body(results)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("content-type", "application/json")
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(s.cfg.Secret) > 0 {
+		auth.Sign(req, s.cfg.Secret, body, s.now())
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close waits briefly for async saves to complete.
+func (s *webhookStorer) Close() error {
+	s.commonStorer.close()
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// UpdateInstance is a no-op, as each result includes its instance.
+func (s *webhookStorer) UpdateInstance(ctx context.Context, instance *check.Instance) error {
+	return nil
+}
+
+// AnalyzeLongestGapPerApp is unsupported because results can't be read back.
+func (s *webhookStorer) AnalyzeLongestGapPerApp(
+	ctx context.Context,
+	start time.Time,
+	end time.Time,
+	apps []string,
+	output func(appID string, interval time.Duration, maxIntervalTS time.Time),
+) error {
+	return ErrUnsupported
+}
+
+// QueryResults is unsupported because results can't be read back.
+func (s *webhookStorer) QueryResults(ctx context.Context, filter ResultsFilter, page Page) (ResultsPage, error) {
+	return ResultsPage{}, ErrUnsupported
+}
+
+// QueryResultStats is unsupported because results can't be read back.
+func (s *webhookStorer) QueryResultStats(ctx context.Context, filter ResultsFilter) (ResultStats, error) {
+	return ResultStats{}, ErrUnsupported
+}
+
+// ListInstances is unsupported because results can't be read back.
+func (s *webhookStorer) ListInstances(ctx context.Context, filter InstanceFilter) ([]*check.Instance, error) {
+	return nil, ErrUnsupported
+}
+
+// QueryMeasurements is unsupported because results can't be read back.
+func (s *webhookStorer) QueryMeasurements(ctx context.Context, filter ResultsFilter) ([]MeasurementSeries, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,124 @@
+package storer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/apps-self-check/pkg/auth"
+	"github.com/digitalocean/apps-self-check/pkg/types/check"
+)
+
+type webhookReceiver struct {
+	t      *testing.T
+	status int
+	lock   sync.Mutex
+	bodies []string
+	reqs   []*http.Request
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		wr.t.Error(err)
+	}
+	wr.lock.Lock()
+	wr.bodies = append(wr.bodies, string(body))
+	wr.reqs = append(wr.reqs, r)
+	wr.lock.Unlock()
+	if wr.status != 0 {
+		w.WriteHeader(wr.status)
+	}
+}
+
+func TestWebhookStorer(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("secret")
+	verify := auth.NewHMAC(secret, time.Minute)
+	rec := &webhookReceiver{t: t}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verify.Authenticate(r); err != nil {
+			t.Errorf("verifying signature: %v", err)
+		}
+		rec.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	s, err := New(ctx, srv.URL+"/results", "", false, WithWebhook(WebhookConfig{
+		Headers: map[string]string{"authorization": "Bearer token"},
+		Secret:  secret,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	instance := &check.Instance{UUID: "a", AppID: "app"}
+	if err := s.SaveCheckResults(ctx, check.CheckResults{ID: "1", Instance: instance}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(rec.reqs))
+	}
+	if got := rec.reqs[0].Header.Get("authorization"); got != "Bearer token" {
+		t.Errorf("unexpected authorization header %q", got)
+	}
+	if got := rec.reqs[0].Header.Get("content-type"); got != "application/json" {
+		t.Errorf("unexpected content-type %q", got)
+	}
+	var result check.CheckResults
+	if err := json.Unmarshal([]byte(rec.bodies[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "1" || result.Instance == nil || result.Instance.AppID != "app" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestWebhookStorerBatchTemplate(t *testing.T) {
+	ctx := context.Background()
+	rec := &webhookReceiver{t: t}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	s, err := NewWebhookStorer(srv.URL, WithWebhook(WebhookConfig{
+		BodyTemplate: `{"count":{{len .Results}},"first":{{json .Result.ID}}}`,
+		BatchSize:    2,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	err = s.(*webhookStorer).saveCheckResults(ctx, []check.CheckResults{{ID: "1"}, {ID: "2"}, {ID: "3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`{"count":2,"first":"1"}`, `{"count":1,"first":"3"}`}
+	if len(rec.bodies) != len(expected) {
+		t.Fatalf("expected %d requests, got %v", len(expected), rec.bodies)
+	}
+	for i := range expected {
+		if rec.bodies[i] != expected[i] {
+			t.Errorf("request %d: expected %s, got %s", i, expected[i], rec.bodies[i])
+		}
+	}
+}
+
+func TestWebhookStorerError(t *testing.T) {
+	rec := &webhookReceiver{t: t, status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	s, err := NewWebhookStorer(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.SaveCheckResults(context.Background(), check.CheckResults{ID: "1"}); err == nil {
+		t.Error("expected an error for a 503 response")
+	}
+}
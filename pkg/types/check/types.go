@@ -12,7 +12,6 @@ import (
 )
 
 type Instance struct {
-	DatabaseID int64
 	UUID       string
 	AppID      string
 	Hostname   string
@@ -39,6 +38,31 @@ func NewInstance() (*Instance, error) {
 	}, nil
 }
 
+// Copy returns a copy of the instance and its labels, taken under the lock. Results carry a copy so
+// storers can read it without the lock while the instance changes.
+func (i *Instance) Copy() *Instance {
+	if i == nil {
+		return nil
+	}
+	i.Lock()
+	defer i.Unlock()
+	out := &Instance{
+		UUID:       i.UUID,
+		AppID:      i.AppID,
+		Hostname:   i.Hostname,
+		PublicIPv4: i.PublicIPv4,
+		StartedAt:  i.StartedAt,
+		StoppedAt:  i.StoppedAt,
+	}
+	if i.Labels != nil {
+		out.Labels = make(map[string]string, len(i.Labels))
+		for k, v := range i.Labels {
+			out.Labels[k] = v
+		}
+	}
+	return out
+}
+
 func (i *Instance) UpdatePublicIPv4(ctx context.Context) error {
 	// dig +short myip.opendns.com @resolver1.opendns.com
 	m := new(dns.Msg)
@@ -71,10 +95,48 @@ type CheckError struct {
 }
 
 type CheckResults struct {
+	// ID uniquely identifies the results, so saves can be retried without storing duplicates.
+	ID           string `json:",omitempty"`
 	Instance     *Instance
 	TS           time.Time
 	Errors       []CheckError
 	Measurements []CheckMeasurement
+	// Predecessor is set on results restored from a previous instance of the same app.
+	Predecessor bool `json:",omitempty"`
+}
+
+// FilterChecks returns a copy of the results limited to errors and measurements of the named
+// checks, see MeasurementOf. If checks is empty, the results are returned unmodified.
+func (r CheckResults) FilterChecks(checks []string) CheckResults {
+	if len(checks) == 0 {
+		return r
+	}
+	out := r
+	out.Errors, out.Measurements = nil, nil
+	for _, e := range r.Errors {
+		for _, name := range checks {
+			if e.Check == name {
+				out.Errors = append(out.Errors, e)
+				break
+			}
+		}
+	}
+	for _, m := range r.Measurements {
+		for _, name := range checks {
+			if MeasurementOf(m.Check, name) {
+				out.Measurements = append(out.Measurements, m)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// MeasurementOf reports whether a measurement belongs to the named check: its duration, which the
+// checker records as `<check>_duration`. Prefixes can't be used, as check names may share them
+// (ex. `database` and `database_dns`).
+func MeasurementOf(measurement, check string) bool {
+	return measurement == check+"_duration"
 }
 
 type CheckMeasurement struct {